@@ -0,0 +1,234 @@
+// /backend/calendar/calendar.go
+
+package calendar
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"myinvestmap/quotes"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata"
+)
+
+// Calendars for the exchanges in the data directory are compiled in. Each
+// file describes one trading schedule shared by one or more venues:
+//
+//	{
+//	  "exchanges": ["XNYS", "XNAS"],
+//	  "timezone": "America/New_York",
+//	  "open": "09:30",
+//	  "close": "16:00",
+//	  "weekend": ["Saturday", "Sunday"],
+//	  "holidays": ["2026-01-01"],
+//	  "earlyCloses": {"2026-11-27": "13:00"}
+//	}
+//
+// exchanges may use any code LookupExchange understands and weekend defaults
+// to Saturday and Sunday. Holiday lists have to be extended every year;
+// exchanges without a calendar are treated as always open.
+//
+//go:embed data/*.json
+var embedded embed.FS
+
+// Calendar is the regular trading session of an exchange.
+type Calendar struct {
+	Location    *time.Location
+	Open        time.Duration
+	Close       time.Duration
+	Weekend     map[time.Weekday]bool
+	Holidays    map[string]bool
+	EarlyCloses map[string]time.Duration
+}
+
+type calendarFile struct {
+	Exchanges   []string          `json:"exchanges"`
+	Timezone    string            `json:"timezone"`
+	Open        string            `json:"open"`
+	Close       string            `json:"close"`
+	Weekend     []string          `json:"weekend"`
+	Holidays    []string          `json:"holidays"`
+	EarlyCloses map[string]string `json:"earlyCloses"`
+}
+
+var (
+	mu        sync.RWMutex
+	calendars = make(map[string]*Calendar)
+)
+
+func init() {
+	data, err := fs.Sub(embedded, "data")
+	if err == nil {
+		err = Load(data)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Load reads every .json file in fsys, replacing the calendars of the
+// exchanges they list.
+func Load(fsys fs.FS) error {
+	files, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return err
+	}
+
+	loaded := make(map[string]*Calendar)
+	for _, name := range files {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return fmt.Errorf("error reading calendar %s: %v", name, err)
+		}
+		var file calendarFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("error parsing calendar %s: %v", name, err)
+		}
+		cal, err := file.toCalendar()
+		if err != nil {
+			return fmt.Errorf("error parsing calendar %s: %v", name, err)
+		}
+		for _, exchange := range file.Exchanges {
+			loaded[quotes.NormalizeExchange(exchange)] = cal
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for exchange, cal := range loaded {
+		calendars[exchange] = cal
+	}
+	return nil
+}
+
+// Lookup returns the calendar of an exchange given by code, MIC or alias.
+func Lookup(exchange string) (*Calendar, bool) {
+	if strings.TrimSpace(exchange) == "" {
+		return nil, false
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	cal, ok := calendars[quotes.NormalizeExchange(exchange)]
+	return cal, ok
+}
+
+func (f calendarFile) toCalendar() (*Calendar, error) {
+	if len(f.Exchanges) == 0 {
+		return nil, fmt.Errorf("no exchanges listed")
+	}
+	location, err := time.LoadLocation(f.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	cal := &Calendar{
+		Location:    location,
+		Weekend:     map[time.Weekday]bool{time.Saturday: true, time.Sunday: true},
+		Holidays:    make(map[string]bool, len(f.Holidays)),
+		EarlyCloses: make(map[string]time.Duration, len(f.EarlyCloses)),
+	}
+	if cal.Open, err = parseClock(f.Open); err != nil {
+		return nil, fmt.Errorf("open: %v", err)
+	}
+	if cal.Close, err = parseClock(f.Close); err != nil {
+		return nil, fmt.Errorf("close: %v", err)
+	}
+	if cal.Close <= cal.Open {
+		return nil, fmt.Errorf("close %s is not after open %s", f.Close, f.Open)
+	}
+
+	if len(f.Weekend) > 0 {
+		cal.Weekend = make(map[time.Weekday]bool, len(f.Weekend))
+		for _, name := range f.Weekend {
+			day, ok := weekdays[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown weekday %q", name)
+			}
+			cal.Weekend[day] = true
+		}
+	}
+	for _, day := range f.Holidays {
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			return nil, fmt.Errorf("holiday: %v", err)
+		}
+		cal.Holidays[day] = true
+	}
+	for day, close := range f.EarlyCloses {
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			return nil, fmt.Errorf("early close: %v", err)
+		}
+		if cal.EarlyCloses[day], err = parseClock(close); err != nil {
+			return nil, fmt.Errorf("early close %s: %v", day, err)
+		}
+	}
+	return cal, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// IsTradingDay reports whether the exchange trades on the local date of t.
+func (c *Calendar) IsTradingDay(t time.Time) bool {
+	local := t.In(c.Location)
+	return !c.Weekend[local.Weekday()] && !c.Holidays[local.Format("2006-01-02")]
+}
+
+// IsOpen reports whether t falls within a trading session.
+func (c *Calendar) IsOpen(t time.Time) bool {
+	if !c.IsTradingDay(t) {
+		return false
+	}
+	open, close := c.session(t)
+	return !t.Before(open) && t.Before(close)
+}
+
+// LastClose returns the end of the most recent session that closed at or
+// before t.
+func (c *Calendar) LastClose(t time.Time) time.Time {
+	day := t.In(c.Location)
+	// Long holiday stretches never exceed a couple of weeks.
+	for i := 0; i < 21; i++ {
+		if c.IsTradingDay(day) {
+			if _, close := c.session(day); !close.After(t) {
+				return close
+			}
+		}
+		day = day.AddDate(0, 0, -1)
+	}
+	return time.Time{}
+}
+
+// session returns the opening and closing time on the local date of t.
+func (c *Calendar) session(t time.Time) (time.Time, time.Time) {
+	local := t.In(c.Location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, c.Location)
+	close := c.Close
+	if early, ok := c.EarlyCloses[local.Format("2006-01-02")]; ok {
+		close = early
+	}
+	return clockOn(midnight, c.Open), clockOn(midnight, close)
+}
+
+// clockOn adds a wall clock time to midnight, staying correct on days when
+// daylight saving time changes.
+func clockOn(midnight time.Time, clock time.Duration) time.Time {
+	return time.Date(midnight.Year(), midnight.Month(), midnight.Day(), int(clock/time.Hour), int(clock%time.Hour/time.Minute), 0, 0, midnight.Location())
+}
@@ -4,7 +4,9 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
+	"myinvestmap/quotes"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -38,19 +40,42 @@ func createTables(db *sql.DB) {
 		log.Fatal(err)
 	}
 
+	createInstrumentsTableSQL := `
+	CREATE TABLE IF NOT EXISTS instruments (
+	    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	    symbol TEXT NOT NULL,
+	    exchange TEXT NOT NULL,
+	    name TEXT,
+	    currency TEXT,
+	    type TEXT NOT NULL DEFAULT 'stock',
+	    isin TEXT,
+	    lastPrice REAL,
+	    lastPriceAt DATETIME,
+	    createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	    updatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	    UNIQUE (symbol, exchange)
+	);`
+
+	_, err = db.Exec(createInstrumentsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Assets are the user's transactions; everything known about the traded
+	// security lives in instruments. stockTag and exchange always match the
+	// referenced instrument.
 	createAssetsTableSQL := `
 	CREATE TABLE IF NOT EXISTS assets (
 	    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
 	    stockTag TEXT NOT NULL,
 	    exchange TEXT NOT NULL,
-	    name TEXT,
 	    price REAL NOT NULL,
 	    quantity REAL NOT NULL,
-	    currentPrice REAL,
 	    isPurchase BOOLEAN NOT NULL DEFAULT true,
 	    createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
 	    updatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
 	    user_id INTEGER,
+	    instrument_id INTEGER REFERENCES instruments(id),
 	    FOREIGN KEY (user_id) REFERENCES users(id)
 	);`
 
@@ -59,6 +84,177 @@ func createTables(db *sql.DB) {
 		log.Fatal(err)
 	}
 
+	migrateAssetsToInstruments(db)
+
+	addColumnIfNotExists(db, "assets", "tradedAt", "DATETIME")
+
+	// The wallet or exchange account crypto is held in.
+	addColumnIfNotExists(db, "assets", "account", "TEXT")
+
+	// The currency the trade was priced in; the instrument's when NULL.
+	addColumnIfNotExists(db, "assets", "currency", "TEXT")
+
+	// Commissions and transaction taxes paid on the trade, in its currency.
+	addColumnIfNotExists(db, "assets", "fee", "REAL NOT NULL DEFAULT 0")
+	addColumnIfNotExists(db, "assets", "tax", "REAL NOT NULL DEFAULT 0")
+
+	// Totals are converted into this currency.
+	addColumnIfNotExists(db, "users", "baseCurrency", "TEXT NOT NULL DEFAULT 'USD'")
+
+	// Which lots sales are matched against; see portfolio.IsMethod.
+	addColumnIfNotExists(db, "users", "costBasisMethod", "TEXT NOT NULL DEFAULT 'average'")
+
+	// Whether sales may exceed the quantity held.
+	addColumnIfNotExists(db, "users", "allowShortSelling", "BOOLEAN NOT NULL DEFAULT false")
+
+	// Range of trading days already requested from a provider, whether or
+	// not any candles came back for them.
+	addColumnIfNotExists(db, "instruments", "backfilledFrom", "TEXT")
+	addColumnIfNotExists(db, "instruments", "backfilledTo", "TEXT")
+
+	// Set when a provider's symbol search last returned the instrument, which
+	// makes it a known listing for validating new assets.
+	addColumnIfNotExists(db, "instruments", "mic", "TEXT")
+	addColumnIfNotExists(db, "instruments", "country", "TEXT")
+	addColumnIfNotExists(db, "instruments", "listedAt", "DATETIME")
+
+	// Where the last price came from, and why the refresh after it failed.
+	addColumnIfNotExists(db, "instruments", "lastPriceSource", "TEXT")
+	addColumnIfNotExists(db, "instruments", "lastRefreshError", "TEXT")
+	addColumnIfNotExists(db, "instruments", "lastRefreshErrorAt", "DATETIME")
+
+	// When the price was last fetched, as opposed to when it was quoted.
+	addColumnIfNotExists(db, "instruments", "lastRefreshAt", "DATETIME")
+
+	_, err = db.Exec(`UPDATE assets SET tradedAt = createdAt WHERE tradedAt IS NULL;`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	createPriceHistoryTableSQL := `
+	CREATE TABLE IF NOT EXISTS price_history (
+	    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	    instrument_id INTEGER NOT NULL,
+	    price REAL NOT NULL,
+	    priceAt DATETIME NOT NULL,
+	    source TEXT NOT NULL DEFAULT '',
+	    createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	    UNIQUE (instrument_id, priceAt, source),
+	    FOREIGN KEY (instrument_id) REFERENCES instruments(id)
+	);`
+
+	_, err = db.Exec(createPriceHistoryTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	createPriceCandlesTableSQL := `
+	CREATE TABLE IF NOT EXISTS price_candles (
+	    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	    instrument_id INTEGER NOT NULL,
+	    tradingDay TEXT NOT NULL,
+	    open REAL,
+	    high REAL,
+	    low REAL,
+	    close REAL NOT NULL,
+	    volume REAL,
+	    source TEXT NOT NULL DEFAULT '',
+	    createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	    UNIQUE (instrument_id, tradingDay),
+	    FOREIGN KEY (instrument_id) REFERENCES instruments(id)
+	);`
+
+	_, err = db.Exec(createPriceCandlesTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Daily FX rates, one unit of base in quote. Today's row is overwritten
+	// with the latest rate until the day is over.
+	createFXRatesTableSQL := `
+	CREATE TABLE IF NOT EXISTS fx_rates (
+	    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	    base TEXT NOT NULL,
+	    quote TEXT NOT NULL,
+	    rateDate TEXT NOT NULL,
+	    rate REAL NOT NULL,
+	    source TEXT NOT NULL DEFAULT '',
+	    fetchedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	    UNIQUE (base, quote, rateDate)
+	);`
+
+	_, err = db.Exec(createFXRatesTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Dividends and interest received. Dates are kept as plain days.
+	createIncomeTableSQL := `
+	CREATE TABLE IF NOT EXISTS income (
+	    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	    user_id INTEGER NOT NULL,
+	    instrument_id INTEGER NOT NULL,
+	    type TEXT NOT NULL DEFAULT 'dividend',
+	    account TEXT,
+	    currency TEXT NOT NULL,
+	    grossAmount REAL NOT NULL,
+	    withholdingTax REAL NOT NULL DEFAULT 0,
+	    exDate TEXT,
+	    payDate TEXT NOT NULL,
+	    createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	    updatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	    FOREIGN KEY (user_id) REFERENCES users(id),
+	    FOREIGN KEY (instrument_id) REFERENCES instruments(id)
+	);`
+
+	_, err = db.Exec(createIncomeTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Splits, symbol changes and spin-offs. user_id is 0 for actions fetched
+	// from a provider, which apply to everyone holding the instrument.
+	createCorporateActionsTableSQL := `
+	CREATE TABLE IF NOT EXISTS corporate_actions (
+	    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	    user_id INTEGER NOT NULL DEFAULT 0,
+	    instrument_id INTEGER NOT NULL,
+	    type TEXT NOT NULL,
+	    effectiveDate TEXT NOT NULL,
+	    newShares REAL NOT NULL DEFAULT 1,
+	    oldShares REAL NOT NULL DEFAULT 1,
+	    new_instrument_id INTEGER,
+	    costFraction REAL NOT NULL DEFAULT 0,
+	    source TEXT NOT NULL DEFAULT 'manual',
+	    createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	    UNIQUE (instrument_id, type, effectiveDate, user_id),
+	    FOREIGN KEY (instrument_id) REFERENCES instruments(id),
+	    FOREIGN KEY (new_instrument_id) REFERENCES instruments(id)
+	);`
+
+	_, err = db.Exec(createCorporateActionsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The purchases a sale disposes of under the specific lot method. Both
+	// ends are rows in assets.
+	createLotSelectionsTableSQL := `
+	CREATE TABLE IF NOT EXISTS lot_selections (
+	    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	    sale_id INTEGER NOT NULL,
+	    lot_id INTEGER NOT NULL,
+	    quantity REAL NOT NULL,
+	    UNIQUE (sale_id, lot_id),
+	    FOREIGN KEY (sale_id) REFERENCES assets(id),
+	    FOREIGN KEY (lot_id) REFERENCES assets(id)
+	);`
+
+	_, err = db.Exec(createLotSelectionsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	createApiKeysTableSQL := `
     CREATE TABLE IF NOT EXISTS api_keys (
         id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
@@ -71,4 +267,224 @@ func createTables(db *sql.DB) {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	addColumnIfNotExists(db, "api_keys", "provider", "TEXT NOT NULL DEFAULT 'twelvedata'")
+
+	_, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id);`)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	createProviderCredentialsTableSQL := `
+    CREATE TABLE IF NOT EXISTS provider_credentials (
+        id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        provider TEXT NOT NULL,
+        api_key TEXT NOT NULL,
+        is_primary BOOLEAN NOT NULL DEFAULT false,
+        priority INTEGER NOT NULL DEFAULT 0,
+        createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+        updatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+        FOREIGN KEY (user_id) REFERENCES users(id)
+    );`
+
+	_, err = db.Exec(createProviderCredentialsTableSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Single keys saved before provider_credentials existed become the
+	// user's primary credential.
+	migrateApiKeysSQL := `
+    INSERT INTO provider_credentials (user_id, provider, api_key, is_primary)
+    SELECT user_id, provider, api_key, true FROM api_keys
+    WHERE NOT EXISTS (SELECT 1 FROM provider_credentials pc WHERE pc.user_id = api_keys.user_id);`
+
+	_, err = db.Exec(migrateApiKeysSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// migrateAssetsToInstruments moves instrument data out of assets tables
+// created before the instruments table existed. Instruments are created
+// normalized the way handlers create them, and the whole move happens in one
+// transaction so that an interrupted start leaves the old table intact.
+func migrateAssetsToInstruments(db *sql.DB) {
+	addColumnIfNotExists(db, "assets", "instrument_id", "INTEGER REFERENCES instruments(id)")
+
+	// Only tables from before instruments existed still carry these; the
+	// currency column added since is the trade's own.
+	legacy := columnExists(db, "assets", "currentPrice")
+	var legacyColumns []string
+	currency := "NULL"
+	if legacy {
+		for _, column := range []string{"name", "currentPrice", "currency"} {
+			if columnExists(db, "assets", column) {
+				legacyColumns = append(legacyColumns, column)
+			}
+		}
+		if columnExists(db, "assets", "currency") {
+			currency = "MAX(currency)"
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	if legacy {
+		copyInstruments(tx, currency)
+	}
+	linkAssets(tx)
+
+	for _, column := range legacyColumns {
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE assets DROP COLUMN %s", column)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// copyInstruments creates an instrument for every listing held in a legacy
+// assets table, carrying over its last price.
+func copyInstruments(tx *sql.Tx, currency string) {
+	selectLegacyInstrumentsSQL := fmt.Sprintf(`
+	SELECT stockTag, exchange, MAX(name), %s, MAX(currentPrice), CASE WHEN MAX(currentPrice) IS NULL THEN NULL ELSE MAX(updatedAt) END
+	FROM assets GROUP BY stockTag, exchange;`, currency)
+
+	type legacyInstrument struct {
+		listing     quotes.Symbol
+		name        sql.NullString
+		currency    sql.NullString
+		lastPrice   sql.NullFloat64
+		lastPriceAt sql.NullString
+	}
+
+	rows, err := tx.Query(selectLegacyInstrumentsSQL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var instruments []legacyInstrument
+	for rows.Next() {
+		var i legacyInstrument
+		if err := rows.Scan(&i.listing.Symbol, &i.listing.Exchange, &i.name, &i.currency, &i.lastPrice, &i.lastPriceAt); err != nil {
+			log.Fatal(err)
+		}
+		instruments = append(instruments, i)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	rows.Close()
+
+	// Spellings of the same listing become one instrument, taking whatever
+	// each of them knew and the most recent price.
+	var order []string
+	merged := make(map[string]*legacyInstrument, len(instruments))
+	for _, i := range instruments {
+		i.listing = i.listing.Normalize()
+		key := i.listing.Key()
+		m, ok := merged[key]
+		if !ok {
+			i := i
+			merged[key] = &i
+			order = append(order, key)
+			continue
+		}
+		if !m.name.Valid {
+			m.name = i.name
+		}
+		if !m.currency.Valid {
+			m.currency = i.currency
+		}
+		if i.lastPrice.Valid && (!m.lastPrice.Valid || i.lastPriceAt.String > m.lastPriceAt.String) {
+			m.lastPrice, m.lastPriceAt = i.lastPrice, i.lastPriceAt
+		}
+	}
+
+	for _, key := range order {
+		i := merged[key]
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO instruments (symbol, exchange, name, currency, lastPrice, lastPriceAt) VALUES (?, ?, ?, ?, ?, ?)`,
+			i.listing.Symbol, i.listing.Exchange, i.name, i.currency, i.lastPrice, i.lastPriceAt); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// linkAssets points every asset without an instrument at the one for its
+// listing, creating it where needed, and spells the asset's listing the same.
+func linkAssets(tx *sql.Tx) {
+	rows, err := tx.Query(`SELECT DISTINCT stockTag, exchange FROM assets WHERE instrument_id IS NULL`)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var listings []quotes.Symbol
+	for rows.Next() {
+		var s quotes.Symbol
+		if err := rows.Scan(&s.Symbol, &s.Exchange); err != nil {
+			log.Fatal(err)
+		}
+		listings = append(listings, s)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	rows.Close()
+
+	for _, s := range listings {
+		listing := s.Normalize()
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO instruments (symbol, exchange) VALUES (?, ?)`, listing.Symbol, listing.Exchange); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := tx.Exec(`UPDATE assets SET instrument_id = (SELECT id FROM instruments WHERE symbol = ?1 AND exchange = ?2), stockTag = ?1, exchange = ?2 WHERE instrument_id IS NULL AND stockTag = ?3 AND exchange = ?4`,
+			listing.Symbol, listing.Exchange, s.Symbol, s.Exchange); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// addColumnIfNotExists lets tables created by older versions pick up new
+// columns, since SQLite has no ADD COLUMN IF NOT EXISTS.
+func addColumnIfNotExists(db *sql.DB, table, column, definition string) {
+	if columnExists(db, table, column) {
+		return
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func columnExists(db *sql.DB, table, column string) bool {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			columnType string
+			notNull    bool
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultVal, &primaryKey); err != nil {
+			log.Fatal(err)
+		}
+		if name == column {
+			return true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	return false
 }
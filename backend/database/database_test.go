@@ -0,0 +1,122 @@
+// /backend/database/database_test.go
+
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateLegacyAssets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	_, err = legacy.Exec(`
+	CREATE TABLE assets (
+	    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+	    stockTag TEXT NOT NULL,
+	    exchange TEXT NOT NULL,
+	    name TEXT,
+	    currency TEXT,
+	    price REAL NOT NULL,
+	    quantity REAL NOT NULL,
+	    currentPrice REAL,
+	    isPurchase BOOLEAN NOT NULL DEFAULT true,
+	    createdAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	    updatedAt DATETIME DEFAULT CURRENT_TIMESTAMP,
+	    user_id INTEGER
+	);
+	INSERT INTO assets (stockTag, exchange, name, currency, price, quantity, currentPrice, user_id) VALUES
+	    ('aapl', 'nasdaq', 'Apple Inc.', 'USD', 150, 10, 190.5, 1),
+	    ('AAPL', 'XNAS', NULL, NULL, 160, 5, NULL, 2),
+	    ('SHEL', 'LSE', 'Shell plc', 'GBX', 2400, 20, 2650.5, 1);`)
+	if err != nil {
+		t.Fatalf("creating legacy assets: %v", err)
+	}
+	legacy.Close()
+
+	db := InitDB(path)
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT symbol, exchange, COALESCE(name, ''), lastPrice FROM instruments ORDER BY symbol`)
+	if err != nil {
+		t.Fatalf("fetching instruments: %v", err)
+	}
+	type instrument struct {
+		symbol, exchange, name string
+		lastPrice              sql.NullFloat64
+	}
+	var got []instrument
+	for rows.Next() {
+		var i instrument
+		if err := rows.Scan(&i.symbol, &i.exchange, &i.name, &i.lastPrice); err != nil {
+			t.Fatalf("scanning instrument: %v", err)
+		}
+		got = append(got, i)
+	}
+	rows.Close()
+
+	want := []instrument{
+		{symbol: "AAPL", exchange: "XNAS", name: "Apple Inc.", lastPrice: sql.NullFloat64{Float64: 190.5, Valid: true}},
+		{symbol: "SHEL", exchange: "XLON", name: "Shell plc", lastPrice: sql.NullFloat64{Float64: 2650.5, Valid: true}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got instruments %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("instrument %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	var unlinked, aaplAssets int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM assets WHERE instrument_id IS NULL`).Scan(&unlinked); err != nil {
+		t.Fatalf("counting assets: %v", err)
+	}
+	if unlinked != 0 {
+		t.Errorf("%d assets left without an instrument", unlinked)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM assets a JOIN instruments i ON i.id = a.instrument_id WHERE i.symbol = 'AAPL'`).Scan(&aaplAssets); err != nil {
+		t.Fatalf("counting assets: %v", err)
+	}
+	if aaplAssets != 2 {
+		t.Errorf("%d assets linked to AAPL, want both spellings", aaplAssets)
+	}
+	var mismatched int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM assets a JOIN instruments i ON i.id = a.instrument_id WHERE a.stockTag != i.symbol OR a.exchange != i.exchange`).Scan(&mismatched); err != nil {
+		t.Fatalf("counting assets: %v", err)
+	}
+	if mismatched != 0 {
+		t.Errorf("%d assets spell their listing differently from their instrument", mismatched)
+	}
+
+	for _, column := range []string{"name", "currentPrice"} {
+		if columnExists(db, "assets", column) {
+			t.Errorf("legacy column %s was not dropped", column)
+		}
+	}
+}
+
+func TestInitDBKeepsTradeCurrency(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db := InitDB(path)
+	if _, err := db.Exec(`INSERT INTO assets (stockTag, exchange, price, quantity, currency, user_id) VALUES ('SHEL', 'XLON', 2400, 20, 'GBX', 1)`); err != nil {
+		t.Fatalf("inserting asset: %v", err)
+	}
+	db.Close()
+
+	// Starting again must not mistake the trade currency for a legacy column.
+	db = InitDB(path)
+	defer db.Close()
+
+	var currency sql.NullString
+	if err := db.QueryRow(`SELECT currency FROM assets`).Scan(&currency); err != nil {
+		t.Fatalf("fetching asset: %v", err)
+	}
+	if currency.String != "GBX" {
+		t.Errorf("currency = %v, want GBX", currency)
+	}
+}
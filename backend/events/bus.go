@@ -0,0 +1,235 @@
+// /backend/events/bus.go
+
+package events
+
+import (
+	"myinvestmap/models"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistory is how many recent events Default keeps for clients
+	// resuming after a reconnect.
+	DefaultHistory = 1024
+
+	// DefaultBuffer is how many undelivered events a subscription holds
+	// before it is marked as lagging.
+	DefaultBuffer = 64
+)
+
+const (
+	QuoteUpdated       = "quote.updated"
+	RefreshFailed      = "refresh.failed"
+	TransactionCreated = "transaction.created"
+	TransactionUpdated = "transaction.updated"
+	TransactionDeleted = "transaction.deleted"
+	IncomeCreated      = "income.created"
+	IncomeUpdated      = "income.updated"
+	IncomeDeleted      = "income.deleted"
+
+	CorporateActionCreated = "corporate_action.created"
+	CorporateActionDeleted = "corporate_action.deleted"
+)
+
+// Event is something that changed in the backend. UserID is zero for events
+// that concern every user holding the affected instrument.
+type Event struct {
+	ID     uint64      `json:"id"`
+	Type   string      `json:"type"`
+	UserID int         `json:"-"`
+	Time   time.Time   `json:"time"`
+	Data   interface{} `json:"data"`
+}
+
+// ChangesTransactions reports whether e added, changed or removed one of the
+// user's transactions, or a corporate action restating them, and with it
+// their positions.
+func (e Event) ChangesTransactions() bool {
+	switch e.Type {
+	case TransactionCreated, TransactionUpdated, TransactionDeleted, CorporateActionCreated, CorporateActionDeleted:
+		return true
+	}
+	return false
+}
+
+// Quote is the Data of a QuoteUpdated event.
+type Quote struct {
+	Symbol   string    `json:"symbol"`
+	Exchange string    `json:"exchange"`
+	Price    float64   `json:"price"`
+	PriceAt  time.Time `json:"priceAt"`
+	Source   string    `json:"source"`
+}
+
+// RefreshFailure is the Data of a RefreshFailed event.
+type RefreshFailure struct {
+	Symbols []models.SymbolRef `json:"symbols"`
+	Error   string             `json:"error"`
+}
+
+// DeletedTransaction is the Data of a TransactionDeleted event. Created and
+// updated transactions carry the models.Asset itself.
+type DeletedTransaction struct {
+	ID int `json:"id"`
+}
+
+// DeletedIncome is the Data of an IncomeDeleted event. Created and updated
+// payments carry the models.Income itself.
+type DeletedIncome struct {
+	ID int `json:"id"`
+}
+
+// DeletedCorporateAction is the Data of a CorporateActionDeleted event.
+type DeletedCorporateAction struct {
+	ID int `json:"id"`
+}
+
+// Default is the bus the handlers and the refresher publish to.
+var Default = NewBus(DefaultHistory)
+
+// Bus fans events out to subscribers without ever blocking the publisher and
+// keeps a short history so that subscribers can catch up after reconnecting.
+type Bus struct {
+	mu      sync.RWMutex
+	nextID  uint64
+	history []Event
+	size    int
+	subs    map[*Subscription]struct{}
+}
+
+// Subscription receives the events its filter accepts on C. When C is full,
+// further events are dropped and Lagging reports true until Resync is
+// called, so a slow consumer costs nobody else anything.
+type Subscription struct {
+	C <-chan Event
+	// StartID is the ID of the last event published before the subscription
+	// started; every later event the filter accepts is delivered on C.
+	StartID uint64
+
+	bus     *Bus
+	ch      chan Event
+	filter  func(Event) bool
+	mu      sync.Mutex
+	lagging bool
+}
+
+func NewBus(history int) *Bus {
+	return &Bus{size: history, subs: make(map[*Subscription]struct{})}
+}
+
+// Publish assigns e the next ID and timestamp and delivers it.
+func (b *Bus) Publish(e Event) Event {
+	b.mu.Lock()
+	b.nextID++
+	e.ID = b.nextID
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if b.size > 0 {
+		if len(b.history) == b.size {
+			copy(b.history, b.history[1:])
+			b.history = b.history[:b.size-1]
+		}
+		b.history = append(b.history, e)
+	}
+	// Delivering under the lock keeps every subscriber's events in ID order;
+	// deliver never blocks.
+	for sub := range b.subs {
+		sub.deliver(e)
+	}
+	b.mu.Unlock()
+	return e
+}
+
+// Subscribe starts delivering the events filter accepts. A nil filter
+// accepts everything.
+func (b *Bus) Subscribe(filter func(Event) bool, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, bus: b, ch: ch, filter: filter}
+
+	b.mu.Lock()
+	sub.StartID = b.nextID
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Since returns the kept events after id that filter accepts. ok is false
+// when older events have already been discarded, in which case the caller
+// has to start over from current state.
+func (b *Bus) Since(id uint64, filter func(Event) bool) (events []Event, ok bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if id > b.nextID {
+		return nil, false
+	}
+	if id < b.nextID && (len(b.history) == 0 || b.history[0].ID > id+1) {
+		return nil, false
+	}
+	for _, e := range b.history {
+		if e.ID > id && (filter == nil || filter(e)) {
+			events = append(events, e)
+		}
+	}
+	return events, true
+}
+
+// LastID returns the ID of the most recent event.
+func (b *Bus) LastID() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.nextID
+}
+
+func (s *Subscription) deliver(e Event) {
+	if s.filter != nil && !s.filter(e) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lagging {
+		return
+	}
+	select {
+	case s.ch <- e:
+	default:
+		s.lagging = true
+	}
+}
+
+// Lagging reports whether events were dropped since the last Resync.
+func (s *Subscription) Lagging() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lagging
+}
+
+// Resync empties C and resumes delivery. The caller is expected to send the
+// consumer a fresh snapshot of whatever the dropped events would have
+// changed.
+func (s *Subscription) Resync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+drain:
+	for {
+		select {
+		case <-s.ch:
+		default:
+			break drain
+		}
+	}
+	s.lagging = false
+}
+
+// Close stops delivery. C is not closed, so pending receives must select on
+// something else as well.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	delete(s.bus.subs, s)
+	s.bus.mu.Unlock()
+}
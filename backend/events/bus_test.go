@@ -0,0 +1,22 @@
+// /backend/events/bus_test.go
+
+package events
+
+import "testing"
+
+func TestSubscribeStartsAfterLastPublished(t *testing.T) {
+	bus := NewBus(10)
+	bus.Publish(Event{Type: QuoteUpdated})
+	bus.Publish(Event{Type: QuoteUpdated})
+
+	sub := bus.Subscribe(nil, DefaultBuffer)
+	defer sub.Close()
+	if sub.StartID != 2 {
+		t.Errorf("StartID = %d, want 2", sub.StartID)
+	}
+
+	e := bus.Publish(Event{Type: QuoteUpdated})
+	if got := <-sub.C; got.ID != e.ID || got.ID <= sub.StartID {
+		t.Errorf("delivered event %d, want %d after StartID %d", got.ID, e.ID, sub.StartID)
+	}
+}
@@ -4,6 +4,7 @@ go 1.21.0
 
 require (
 	github.com/gorilla/mux v1.8.1
+	github.com/gorilla/websocket v1.5.3
 	github.com/mattn/go-sqlite3 v1.14.18
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/rs/cors v1.10.1
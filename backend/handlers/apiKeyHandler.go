@@ -1,4 +1,5 @@
-// /backend/handlers/assetHandler.go
+// /backend/handlers/apiKeyHandler.go
+
 package handlers
 
 import (
@@ -6,13 +7,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"myinvestmap/models"
+	"myinvestmap/quotes"
 	"net/http"
 )
 
-const (
-	upsertAPIKeySQL = `INSERT INTO api_keys (user_id, api_key) VALUES (?, ?) ON CONFLICT(user_id) DO UPDATE SET api_key = excluded.api_key`
-	getAPIKeySQL    = `SELECT api_key FROM api_keys WHERE user_id = ?`
-)
+// SaveAPIKey and GetAPIKey predate /api/providers and operate on the user's
+// primary provider credential.
 
 func SaveAPIKey(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
@@ -27,12 +27,35 @@ func SaveAPIKey(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := db.Exec(upsertAPIKeySQL, userClaims.UserID, apiKeyRequest.APIKey); err != nil {
+	if apiKeyRequest.Provider == "" {
+		apiKeyRequest.Provider = quotes.TwelveDataName
+	}
+
+	credentials, err := loadProviderCredentials(db, userClaims.UserID)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("error saving API key: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(models.APIKeyResponse{APIKey: apiKeyRequest.APIKey})
+	credential := models.ProviderCredential{IsPrimary: true}
+	if len(credentials) > 0 {
+		credential = credentials[0]
+		credential.IsPrimary = true
+	}
+	credential.Provider = apiKeyRequest.Provider
+	credential.APIKey = apiKeyRequest.APIKey
+
+	if err := validateProviderCredential(credential); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := saveProviderCredential(db, userClaims.UserID, credential); err != nil {
+		http.Error(w, fmt.Sprintf("error saving API key: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(models.APIKeyResponse{APIKey: apiKeyRequest.APIKey, Provider: apiKeyRequest.Provider})
 }
 
 func GetAPIKey(db *sql.DB, w http.ResponseWriter, r *http.Request) {
@@ -42,15 +65,15 @@ func GetAPIKey(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var apiKey string
-	if err := db.QueryRow(getAPIKeySQL, userClaims.UserID).Scan(&apiKey); err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "API key not found", http.StatusNotFound)
-			return
-		}
+	credentials, err := loadProviderCredentials(db, userClaims.UserID)
+	if err != nil {
 		http.Error(w, fmt.Sprintf("error retrieving API key: %v", err), http.StatusInternalServerError)
 		return
 	}
+	if len(credentials) == 0 {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
 
-	json.NewEncoder(w).Encode(models.APIKeyResponse{APIKey: apiKey})
+	json.NewEncoder(w).Encode(models.APIKeyResponse{APIKey: credentials[0].APIKey, Provider: credentials[0].Provider})
 }
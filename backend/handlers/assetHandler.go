@@ -5,26 +5,33 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"myinvestmap/events"
 	"myinvestmap/models"
+	"myinvestmap/portfolio"
+	"myinvestmap/quotes"
+	"myinvestmap/refresher"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 )
 
 const (
-	insertAssetSQL      = `INSERT INTO assets (user_id, stockTag, exchange, price, quantity, IsPurchase) VALUES (?, ?, ?, ?, ?, ?)`
-	selectAssetsSQL     = `SELECT id, stockTag, exchange, price, quantity, isPurchase, name, currentPrice, createdAt, updatedAt FROM assets WHERE user_id = ?`
-	selectMaxUpdateSQL  = `SELECT MAX(updatedAt) FROM assets`
-	distinctStockTagSQL = `SELECT DISTINCT stockTag FROM assets WHERE user_id = ? ORDER BY updatedAt ASC LIMIT 8`
-	updateAssetSQL      = `UPDATE assets SET name = ?, currentPrice = ?, updatedAt = CURRENT_TIMESTAMP WHERE stockTag = ?`
-	deleteAssetSQL      = `DELETE FROM assets WHERE id = ? AND user_id = ?`
-	updateAssetByIDSQL  = `UPDATE assets SET stockTag = ?, exchange = ?, price = ?, quantity = ? WHERE id = ?`
-	selectAPIKeySQL     = `SELECT api_key FROM api_keys WHERE user_id = ?`
+	insertAssetSQL     = `INSERT INTO assets (user_id, instrument_id, stockTag, exchange, account, currency, price, quantity, fee, tax, IsPurchase, tradedAt) VALUES (?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''), ?, ?, ?, ?, ?, ?)`
+	selectAssetsSQL    = `SELECT a.id, a.instrument_id, i.type, a.stockTag, a.exchange, COALESCE(a.account, ''), a.price, a.quantity, a.fee, a.tax, a.isPurchase, i.name, COALESCE(a.currency, i.currency, ''), i.lastPrice, i.lastPriceAt, i.lastRefreshAt, COALESCE(i.lastPriceSource, ''), COALESCE(i.lastRefreshError, ''), i.lastRefreshErrorAt, a.tradedAt, a.createdAt, a.updatedAt FROM assets a JOIN instruments i ON i.id = a.instrument_id WHERE a.user_id = ?`
+	selectExchangesSQL = `SELECT DISTINCT exchange FROM assets WHERE user_id = ? AND stockTag = ?`
+	deleteAssetSQL     = `DELETE FROM assets WHERE id = ? AND user_id = ?`
+	updateAssetByIDSQL = `UPDATE assets SET instrument_id = ?, stockTag = ?, exchange = ?, account = NULLIF(?, ''), currency = NULLIF(?, ''), price = ?, quantity = ?, fee = ?, tax = ?, tradedAt = COALESCE(?, tradedAt), updatedAt = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?`
+
+	insertLotSelectionSQL  = `INSERT INTO lot_selections (sale_id, lot_id, quantity) VALUES (?, ?, ?)`
+	selectLotSelectionsSQL = `SELECT s.sale_id, s.lot_id, s.quantity FROM lot_selections s JOIN assets a ON a.id = s.sale_id WHERE a.user_id = ? ORDER BY s.id`
+	selectLotPurchaseSQL   = `SELECT isPurchase, tradedAt FROM assets WHERE id = ? AND user_id = ? AND instrument_id = ?`
+	selectAssetTradeSQL    = `SELECT isPurchase, tradedAt FROM assets WHERE id = ? AND user_id = ?`
+	deleteLotSelectionsSQL = `DELETE FROM lot_selections WHERE sale_id = ? OR lot_id = ?`
+	deleteSaleLotsSQL      = `DELETE FROM lot_selections WHERE sale_id = ?`
 )
 
 func AddAsset(db *sql.DB, w http.ResponseWriter, r *http.Request) {
@@ -40,6 +47,26 @@ func AddAsset(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := validateAssetSymbol(r.Context(), db, &newAsset, userClaims.UserID); err != nil {
+		http.Error(w, err.Error(), assetSymbolStatus(err))
+		return
+	}
+	if err := validateTradeCosts(newAsset); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if newAsset.Quantity <= 0 {
+		http.Error(w, "quantity must be positive", http.StatusBadRequest)
+		return
+	}
+
+	instrumentID, isNew, err := ensureInstrument(db, newAsset.StockTag, newAsset.Exchange)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	statement, err := db.Prepare(insertAssetSQL)
 	if err != nil {
 		http.Error(w, "failed to prepare SQL statement", http.StatusInternalServerError)
@@ -47,13 +74,26 @@ func AddAsset(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	}
 	defer statement.Close()
 
+	newAsset.InstrumentID = instrumentID
+	if newAsset.TradedAt.IsZero() {
+		newAsset.TradedAt = time.Now()
+	}
+	newAsset.TradedAt = newAsset.TradedAt.UTC()
 	newAsset.IsPurchase = true
-	if _, err = statement.Exec(userClaims.UserID, newAsset.StockTag, newAsset.Exchange, newAsset.Price, newAsset.Quantity, newAsset.IsPurchase); err != nil {
+	result, err := statement.Exec(userClaims.UserID, newAsset.InstrumentID, newAsset.StockTag, newAsset.Exchange, newAsset.Account, newAsset.Currency, newAsset.Price, newAsset.Quantity, newAsset.Fee, newAsset.Tax, newAsset.IsPurchase, newAsset.TradedAt)
+	if err != nil {
 		http.Error(w, "failed to execute SQL statement", http.StatusInternalServerError)
 		return
 	}
+	if id, err := result.LastInsertId(); err == nil {
+		newAsset.ID = int(id)
+	}
+	publishTransaction(events.TransactionCreated, userClaims.UserID, newAsset)
 
-	updateStockData(db, []string{newAsset.StockTag}, userClaims.UserID)
+	refresher.RefreshRequested(r.Context(), db, []quotes.Symbol{{Symbol: newAsset.StockTag, Exchange: newAsset.Exchange}}, userClaims.UserID)
+	if isNew {
+		backfillNewInstrument(db, instrumentID, userClaims.UserID)
+	}
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(newAsset)
@@ -72,10 +112,35 @@ func UpdateSelectedAssets(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updateStockData(db, req.Symbols, userClaims.UserID)
+	symbols, err := requestedSymbols(db, req, userClaims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	plan, err := refresher.RefreshRequested(r.Context(), db, symbols, userClaims.UserID)
+
+	response := models.UpdateStockResponse{
+		Status:    "success",
+		Refreshed: []models.SymbolRef{},
+		Deferred:  []models.DeferredSymbol{},
+	}
+	if err != nil {
+		response.Status = "error"
+		response.Error = err.Error()
+	}
+	for _, batch := range plan.Batches {
+		for _, s := range batch {
+			response.Refreshed = append(response.Refreshed, models.SymbolRef{Symbol: s.Symbol, Exchange: s.Exchange})
+		}
+	}
+	for _, d := range plan.Deferred {
+		response.Deferred = append(response.Deferred, models.DeferredSymbol{Symbol: d.Symbol.Symbol, Exchange: d.Symbol.Exchange, RefreshAt: d.RefreshAt})
+	}
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	json.NewEncoder(w).Encode(response)
 }
 
 func SellAsset(db *sql.DB, w http.ResponseWriter, r *http.Request) {
@@ -91,20 +156,95 @@ func SellAsset(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	statement, err := db.Prepare(insertAssetSQL)
-	if err != nil {
-		http.Error(w, "failed to prepare SQL statement", http.StatusInternalServerError)
+	if err := validateAssetSymbol(r.Context(), db, &soldAsset, userClaims.UserID); err != nil {
+		http.Error(w, err.Error(), assetSymbolStatus(err))
+		return
+	}
+	if err := validateTradeCosts(soldAsset); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer statement.Close()
 
+	if soldAsset.Quantity <= 0 {
+		http.Error(w, "quantity must be positive", http.StatusBadRequest)
+		return
+	}
+	if soldAsset.TradedAt.IsZero() {
+		soldAsset.TradedAt = time.Now()
+	}
+	soldAsset.TradedAt = soldAsset.TradedAt.UTC()
 	soldAsset.IsPurchase = false
-	if _, err = statement.Exec(userClaims.UserID, soldAsset.StockTag, soldAsset.Exchange, soldAsset.Price, soldAsset.Quantity, soldAsset.IsPurchase); err != nil {
+
+	settings, err := loadSettings(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, "failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	// The sale and the lots it names are saved together, and only if the
+	// user holds enough to cover it. Inserting before reading the holdings
+	// takes SQLite's write lock, so concurrent sales are checked one after
+	// the other.
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	instrumentID, isNew, err := ensureInstrument(tx, soldAsset.StockTag, soldAsset.Exchange)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	soldAsset.InstrumentID = instrumentID
+	if err := validateLotSelections(tx, soldAsset, userClaims.UserID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := tx.Exec(insertAssetSQL, userClaims.UserID, soldAsset.InstrumentID, soldAsset.StockTag, soldAsset.Exchange, soldAsset.Account, soldAsset.Currency, soldAsset.Price, soldAsset.Quantity, soldAsset.Fee, soldAsset.Tax, soldAsset.IsPurchase, soldAsset.TradedAt)
+	if err != nil {
+		http.Error(w, "failed to execute SQL statement", http.StatusInternalServerError)
+		return
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
 		http.Error(w, "failed to execute SQL statement", http.StatusInternalServerError)
 		return
 	}
+	soldAsset.ID = int(id)
+
+	if !settings.AllowShortSelling {
+		oversold, err := checkOversell(tx, userClaims.UserID, soldAsset)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if oversold != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(oversold)
+			return
+		}
+	}
+
+	for _, lot := range soldAsset.Lots {
+		if _, err := tx.Exec(insertLotSelectionSQL, soldAsset.ID, lot.LotID, lot.Quantity); err != nil {
+			http.Error(w, "failed to save lot selection", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	publishTransaction(events.TransactionCreated, userClaims.UserID, soldAsset)
 
-	updateStockData(db, []string{soldAsset.StockTag}, userClaims.UserID)
+	refresher.RefreshRequested(r.Context(), db, []quotes.Symbol{{Symbol: soldAsset.StockTag, Exchange: soldAsset.Exchange}}, userClaims.UserID)
+	if isNew {
+		backfillNewInstrument(db, instrumentID, userClaims.UserID)
+	}
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(soldAsset)
@@ -117,7 +257,12 @@ func GetAssets(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updateStockDataIfNeeded(db, userClaims.UserID)
+	actions, err := loadCorporateActions(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	replayed := toPortfolioActions(actions)
 
 	rows, err := db.Query(selectAssetsSQL, userClaims.UserID)
 	if err != nil {
@@ -129,10 +274,21 @@ func GetAssets(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 	var assets []models.Asset
 	for rows.Next() {
 		var asset models.Asset
-		if err := rows.Scan(&asset.ID, &asset.StockTag, &asset.Exchange, &asset.Price, &asset.Quantity, &asset.IsPurchase, &asset.Name, &asset.CurrentPrice, &asset.CreatedAt, &asset.UpdatedAt); err != nil {
+		var priceAt, refreshedAt, errorAt sql.NullTime
+		if err := rows.Scan(&asset.ID, &asset.InstrumentID, &asset.AssetType, &asset.StockTag, &asset.Exchange, &asset.Account, &asset.Price, &asset.Quantity, &asset.Fee, &asset.Tax, &asset.IsPurchase, &asset.Name, &asset.Currency, &asset.CurrentPrice, &priceAt, &refreshedAt, &asset.PriceSource, &asset.RefreshError, &errorAt, &asset.TradedAt, &asset.CreatedAt, &asset.UpdatedAt); err != nil {
 			http.Error(w, "failed to scan asset row", http.StatusInternalServerError)
 			return
 		}
+		if priceAt.Valid {
+			asset.PriceAsOf = &priceAt.Time
+		}
+		if errorAt.Valid {
+			asset.RefreshErrorAt = &errorAt.Time
+		}
+		if !refreshedAt.Valid {
+			refreshedAt = priceAt
+		}
+		asset.IsStale = refresher.IsStale(asset.Exchange, refreshedAt.Time)
 		assets = append(assets, asset)
 	}
 
@@ -140,6 +296,20 @@ func GetAssets(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "error iterating over assets rows", http.StatusInternalServerError)
 		return
 	}
+	rows.Close()
+
+	selections, err := loadLotSelections(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range assets {
+		assets[i].Lots = selections[assets[i].ID]
+		if err := adjustAsset(db, &assets[i], actions, replayed); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(assets)
@@ -165,12 +335,122 @@ func UpdateAsset(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err = db.Exec(updateAssetByIDSQL, updatedAsset.StockTag, updatedAsset.Exchange, updatedAsset.Price, updatedAsset.Quantity, id); err != nil {
+	if err := validateAssetSymbol(r.Context(), db, &updatedAsset, userClaims.UserID); err != nil {
+		http.Error(w, err.Error(), assetSymbolStatus(err))
+		return
+	}
+	if err := validateTradeCosts(updatedAsset); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if updatedAsset.Quantity <= 0 {
+		http.Error(w, "quantity must be positive", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := loadSettings(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, "failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var storedAt time.Time
+	err = tx.QueryRow(selectAssetTradeSQL, id, userClaims.UserID).Scan(&updatedAsset.IsPurchase, &storedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "asset not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		http.Error(w, "failed to execute SQL statement", http.StatusInternalServerError)
 		return
 	}
 
-	updateStockData(db, []string{updatedAsset.StockTag}, userClaims.UserID)
+	instrumentID, isNew, err := ensureInstrument(tx, updatedAsset.StockTag, updatedAsset.Exchange)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	updatedAsset.InstrumentID = instrumentID
+	updatedAsset.ID = id
+
+	var tradedAt sql.NullTime
+	if !updatedAsset.TradedAt.IsZero() {
+		tradedAt = sql.NullTime{Time: updatedAsset.TradedAt.UTC(), Valid: true}
+		updatedAsset.TradedAt = tradedAt.Time
+	} else {
+		updatedAsset.TradedAt = storedAt.UTC()
+	}
+
+	// The lots a sale names must still fit it after the edit, whether the
+	// edit replaces them or keeps the ones saved with it.
+	lotsChanged := updatedAsset.Lots != nil
+	if !updatedAsset.IsPurchase && !lotsChanged {
+		selections, err := loadLotSelections(tx, userClaims.UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		updatedAsset.Lots = selections[id]
+	}
+	if !updatedAsset.IsPurchase {
+		if err := validateLotSelections(tx, updatedAsset, userClaims.UserID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Raising a sale or lowering a purchase may leave sales short of shares.
+	shortBefore := 0.0
+	if !settings.AllowShortSelling {
+		if shortBefore, err = shortfall(tx, userClaims.UserID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result, err := tx.Exec(updateAssetByIDSQL, updatedAsset.InstrumentID, updatedAsset.StockTag, updatedAsset.Exchange, updatedAsset.Account, updatedAsset.Currency, updatedAsset.Price, updatedAsset.Quantity, updatedAsset.Fee, updatedAsset.Tax, tradedAt, id, userClaims.UserID)
+	if err != nil {
+		http.Error(w, "failed to execute SQL statement", http.StatusInternalServerError)
+		return
+	}
+	if !updatedAsset.IsPurchase && lotsChanged {
+		if _, err := tx.Exec(deleteSaleLotsSQL, id); err != nil {
+			http.Error(w, "failed to save lot selection", http.StatusInternalServerError)
+			return
+		}
+		for _, lot := range updatedAsset.Lots {
+			if _, err := tx.Exec(insertLotSelectionSQL, id, lot.LotID, lot.Quantity); err != nil {
+				http.Error(w, "failed to save lot selection", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	if !settings.AllowShortSelling {
+		if err := checkShortfall(tx, userClaims.UserID, shortBefore); err != nil {
+			http.Error(w, err.Error(), shortfallStatus(err))
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+		publishTransaction(events.TransactionUpdated, userClaims.UserID, updatedAsset)
+	}
+
+	refresher.RefreshRequested(r.Context(), db, []quotes.Symbol{{Symbol: updatedAsset.StockTag, Exchange: updatedAsset.Exchange}}, userClaims.UserID)
+	if isNew {
+		backfillNewInstrument(db, instrumentID, userClaims.UserID)
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(updatedAsset)
@@ -185,135 +465,287 @@ func DeleteAsset(db *sql.DB, w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 	id := vars["id"]
-	if _, err := db.Exec(deleteAssetSQL, id, userClaims.UserID); err != nil {
+
+	settings, err := loadSettings(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, "failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Deleting a purchase may leave later sales short of shares.
+	shortBefore := 0.0
+	if !settings.AllowShortSelling {
+		if shortBefore, err = shortfall(tx, userClaims.UserID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result, err := tx.Exec(deleteAssetSQL, id, userClaims.UserID)
+	if err != nil {
 		http.Error(w, "failed to execute SQL statement", http.StatusInternalServerError)
 		return
 	}
+	affected, err := result.RowsAffected()
+	deleted := err == nil && affected > 0
+	assetID, _ := strconv.Atoi(id)
+	if deleted {
+		if _, err := tx.Exec(deleteLotSelectionsSQL, assetID, assetID); err != nil {
+			http.Error(w, "failed to delete lot selections", http.StatusInternalServerError)
+			return
+		}
+		if !settings.AllowShortSelling {
+			if err := checkShortfall(tx, userClaims.UserID, shortBefore); err != nil {
+				http.Error(w, err.Error(), shortfallStatus(err))
+				return
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+	if deleted {
+		events.Default.Publish(events.Event{Type: events.TransactionDeleted, UserID: userClaims.UserID, Data: events.DeletedTransaction{ID: assetID}})
+	}
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprint(w, "Deleted")
 }
 
-func updateStockDataIfNeeded(db *sql.DB, userID int) error {
-	var lastUpdateStr string
-	err := db.QueryRow(selectMaxUpdateSQL).Scan(&lastUpdateStr)
-	if err != nil {
-		return fmt.Errorf("error fetching last update time: %v", err)
+func publishTransaction(eventType string, userID int, asset models.Asset) {
+	events.Default.Publish(events.Event{Type: eventType, UserID: userID, Data: asset})
+}
+
+// validateTradeCosts rejects negative fees and taxes; rebates are not
+// modelled.
+func validateTradeCosts(asset models.Asset) error {
+	if asset.Fee < 0 {
+		return fmt.Errorf("fee must not be negative")
+	}
+	if asset.Tax < 0 {
+		return fmt.Errorf("tax must not be negative")
 	}
+	return nil
+}
 
-	lastUpdate, err := time.Parse("2006-01-02 15:04:05", lastUpdateStr)
+// checkOversell replays the user's trades with and without sale, which must
+// already be saved in tx, and reports it as oversold when it sells more than
+// was held at the time or leaves a later sale short of shares it had. The
+// available quantity is in the shares at the time of the sale.
+func checkOversell(tx *sql.Tx, userID int, sale models.Asset) (*models.OversellError, error) {
+	trades, err := loadPortfolioTrades(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := loadCorporateActions(tx, userID)
 	if err != nil {
-		return fmt.Errorf("error parsing last update time: %v", err)
+		return nil, err
 	}
 
-	if time.Since(lastUpdate) >= time.Minute {
-		symbols, err := getSymbolsToUpdate(db, userID)
-		if err != nil {
-			return err
+	with := make([]portfolio.Trade, 0, len(trades))
+	without := make([]portfolio.Trade, 0, len(trades))
+	for _, t := range trades {
+		with = append(with, t.Trade)
+		if t.ID != sale.ID {
+			without = append(without, t.Trade)
+		}
+	}
+	replayed := toPortfolioActions(actions)
+
+	// Quantities do not depend on the cost basis method.
+	shortBefore := 0.0
+	for _, h := range portfolio.Replay(without, replayed, portfolio.DefaultMethod) {
+		for _, s := range h.Sales {
+			shortBefore += s.Short
 		}
-		if len(symbols) > 0 {
-			if err := updateStockData(db, symbols, userID); err != nil {
-				return err
+	}
+	var matched, shortAfter float64
+	for _, h := range portfolio.Replay(with, replayed, portfolio.DefaultMethod) {
+		for _, s := range h.Sales {
+			if s.TradeID == sale.ID {
+				matched = s.Quantity - s.Short
+				if s.Short > 0 {
+					return newOversellError(sale, matched, "%g %s on %s held, cannot sell %g", matched, sale.StockTag, sale.Exchange, sale.Quantity), nil
+				}
+				continue
 			}
+			shortAfter += s.Short
 		}
 	}
-	return nil
+	if excess := shortAfter - shortBefore; excess > oversellTolerance {
+		available := matched - excess
+		if available < 0 {
+			available = 0
+		}
+		return newOversellError(sale, available, "selling %g %s on %s would leave later sales short, at most %g can be sold", sale.Quantity, sale.StockTag, sale.Exchange, available), nil
+	}
+	return nil, nil
 }
 
-func getSymbolsToUpdate(db *sql.DB, userID int) ([]string, error) {
-	rows, err := db.Query(distinctStockTagSQL, userID)
+// oversellTolerance absorbs rounding in quantities replayed across splits.
+const oversellTolerance = 1e-9
+
+// errShortfall is returned by checkShortfall when an edit leaves sales short.
+var errShortfall = errors.New("sales short of shares")
+
+// shortfall replays the user's trades, as saved in tx, and returns the
+// shares their sales sold beyond what was held at the time.
+func shortfall(tx *sql.Tx, userID int) (float64, error) {
+	trades, err := loadPortfolioTrades(tx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("error fetching symbols for update: %v", err)
+		return 0, err
+	}
+	actions, err := loadCorporateActions(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	replay := make([]portfolio.Trade, 0, len(trades))
+	for _, t := range trades {
+		replay = append(replay, t.Trade)
 	}
-	defer rows.Close()
 
-	var symbols []string
-	for rows.Next() {
-		var stockTag string
-		if err := rows.Scan(&stockTag); err != nil {
-			return nil, fmt.Errorf("error scanning stockTag: %v", err)
+	// Quantities do not depend on the cost basis method.
+	short := 0.0
+	for _, h := range portfolio.Replay(replay, toPortfolioActions(actions), portfolio.DefaultMethod) {
+		for _, s := range h.Sales {
+			short += s.Short
 		}
-		symbols = append(symbols, stockTag)
 	}
-	return symbols, nil
+	return short, nil
 }
 
-func updateStockData(db *sql.DB, symbols []string, userID int) error {
-	stockData, err := fetchStockDataFromAPI(db, symbols, userID)
+// checkShortfall rejects an edit, already applied in tx, that sells more
+// shares short than the shortBefore the trades sold before it.
+func checkShortfall(tx *sql.Tx, userID int, shortBefore float64) error {
+	short, err := shortfall(tx, userID)
 	if err != nil {
-		return fmt.Errorf("error fetching stock data: %v", err)
+		return err
 	}
-
-	for _, data := range stockData {
-		if data.Price != "" {
-			if _, err := db.Exec(updateAssetSQL, data.Name, data.Price, data.Symbol); err != nil {
-				return fmt.Errorf("error updating asset in database: %v", err)
-			}
-		}
+	if excess := short - shortBefore; excess > oversellTolerance {
+		return fmt.Errorf("%w: the change would sell %g more shares than are held", errShortfall, excess)
 	}
 	return nil
 }
 
-func fetchStockDataFromAPI(db *sql.DB, symbols []string, userID int) ([]models.StockQuote, error) {
-	if len(symbols) == 0 {
-		return nil, nil
+// shortfallStatus answers 400 for an edit that oversells and 500 when the
+// holdings could not be replayed.
+func shortfallStatus(err error) int {
+	if errors.Is(err, errShortfall) {
+		return http.StatusBadRequest
 	}
-	var apiKey string
-	err := db.QueryRow(selectAPIKeySQL, userID).Scan(&apiKey)
-	if err != nil {
-		return nil, fmt.Errorf("Error fetching API key: %v", err)
+	return http.StatusInternalServerError
+}
+
+func newOversellError(sale models.Asset, available float64, format string, args ...interface{}) *models.OversellError {
+	return &models.OversellError{
+		Error:     fmt.Sprintf(format, args...),
+		StockTag:  sale.StockTag,
+		Exchange:  sale.Exchange,
+		Requested: sale.Quantity,
+		Available: available,
 	}
+}
 
-	symbolsQuery := strings.Join(symbols, ",")
-	apiURL := fmt.Sprintf("https://api.twelvedata.com/quote?symbol=%s&apikey=%s", symbolsQuery, apiKey)
-	fmt.Println("API Request:", string(apiURL))
+// validateLotSelections checks the lots a sale names: each must be one of
+// the user's purchases of the sale's instrument made no later than the sale,
+// and together they may not name more shares than are sold. Lots the sale
+// cannot reach because they were sold earlier are matched by FIFO instead
+// when replaying. The sale's InstrumentID must already be resolved.
+func validateLotSelections(db execQueryRower, sale models.Asset, userID int) error {
+	total := 0.0
+	seen := make(map[int]bool)
+	for _, lot := range sale.Lots {
+		if lot.Quantity <= 0 {
+			return fmt.Errorf("lot %d: quantity must be positive", lot.LotID)
+		}
+		if seen[lot.LotID] {
+			return fmt.Errorf("lot %d is named twice", lot.LotID)
+		}
+		seen[lot.LotID] = true
 
-	resp, err := http.Get(apiURL)
-	if err != nil {
-		return nil, fmt.Errorf("HTTP request error: %v", err)
+		var isPurchase bool
+		var tradedAt time.Time
+		err := db.QueryRow(selectLotPurchaseSQL, lot.LotID, userID, sale.InstrumentID).Scan(&isPurchase, &tradedAt)
+		if err == sql.ErrNoRows || (err == nil && !isPurchase) {
+			return fmt.Errorf("lot %d is not one of your purchases of %s on %s", lot.LotID, sale.StockTag, sale.Exchange)
+		}
+		if err != nil {
+			return fmt.Errorf("error fetching lot: %v", err)
+		}
+		if tradedAt.After(sale.TradedAt) {
+			return fmt.Errorf("lot %d was bought after the sale", lot.LotID)
+		}
+		total += lot.Quantity
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned non-OK status: %s", resp.Status)
+	if total > sale.Quantity {
+		return fmt.Errorf("lots name %g shares but only %g are sold", total, sale.Quantity)
 	}
+	return nil
+}
 
-	bodyBytes, err := io.ReadAll(resp.Body)
+// loadLotSelections returns the lots named by each of the user's sales.
+func loadLotSelections(db queryer, userID int) (map[int][]models.LotSelection, error) {
+	rows, err := db.Query(selectLotSelectionsSQL, userID)
 	if err != nil {
-		return nil, fmt.Errorf("Error reading response body: %v", err)
+		return nil, fmt.Errorf("error fetching lot selections: %v", err)
 	}
-	fmt.Println("API Response:", string(bodyBytes))
+	defer rows.Close()
 
-	var quotes []models.StockQuote
-	if len(symbols) == 1 {
-		var quote models.StockQuoteApiResponce
-		if err := json.Unmarshal(bodyBytes, &quote); err != nil {
-			return nil, fmt.Errorf("JSON Decode error: %v", err)
+	selections := make(map[int][]models.LotSelection)
+	for rows.Next() {
+		var saleID int
+		var lot models.LotSelection
+		if err := rows.Scan(&saleID, &lot.LotID, &lot.Quantity); err != nil {
+			return nil, fmt.Errorf("error scanning lot selection: %v", err)
 		}
+		selections[saleID] = append(selections[saleID], lot)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching lot selections: %v", err)
+	}
+	return selections, nil
+}
 
-		quotes = append(quotes, models.StockQuote{
-			Symbol: quote.Symbol,
-			Name:   quote.Name,
-			Price:  quote.Price,
-		})
-	} else {
-		var response map[string]interface{}
-		if err := json.Unmarshal(bodyBytes, &response); err != nil {
-			return nil, fmt.Errorf("JSON Decode error: %v", err)
+// requestedSymbols resolves bare symbols to every exchange the user holds
+// them on, so that a refresh never crosses listings.
+func requestedSymbols(db *sql.DB, req models.UpdateStockRequest, userID int) ([]quotes.Symbol, error) {
+	var symbols []quotes.Symbol
+	for _, ref := range req.Assets {
+		symbols = append(symbols, quotes.Symbol{Symbol: ref.Symbol, Exchange: ref.Exchange}.Normalize())
+	}
+
+	for _, tag := range req.Symbols {
+		rows, err := db.Query(selectExchangesSQL, userID, tag)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching exchanges for %s: %v", tag, err)
 		}
 
-		for symbol, data := range response {
-			quoteData, ok := data.(map[string]interface{})
-			if !ok {
-				continue
+		found := false
+		for rows.Next() {
+			var exchange string
+			if err := rows.Scan(&exchange); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error scanning exchange: %v", err)
 			}
-			quotes = append(quotes, models.StockQuote{
-				Symbol: symbol,
-				Name:   quoteData["name"].(string),
-				Price:  quoteData["close"].(string),
-			})
+			symbols = append(symbols, quotes.Symbol{Symbol: tag, Exchange: exchange}.Normalize())
+			found = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error fetching exchanges for %s: %v", tag, err)
 		}
-	}
 
-	return quotes, nil
+		if !found {
+			symbols = append(symbols, quotes.Symbol{Symbol: tag}.Normalize())
+		}
+	}
+	return symbols, nil
 }
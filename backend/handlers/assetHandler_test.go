@@ -0,0 +1,133 @@
+// /backend/handlers/assetHandler_test.go
+
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"myinvestmap/database"
+	"myinvestmap/models"
+	"myinvestmap/quotes"
+	"myinvestmap/refresher"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+const testUserID = 1
+
+// newTestDB opens an empty database with one user and SAP listed on XETR.
+// Refreshes go to a fake provider serving fakeQuotes.
+func newTestDB(t *testing.T, fakeQuotes ...quotes.Quote) (*sql.DB, *quotes.Fake) {
+	t.Helper()
+	db := database.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(`INSERT INTO users (id, username, email, password) VALUES (?, 'test', 'test@example.com', 'x')`, testUserID); err != nil {
+		t.Fatalf("inserting user: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO instruments (symbol, exchange, currency, listedAt) VALUES ('SAP', 'XETR', 'EUR', CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatalf("inserting instrument: %v", err)
+	}
+
+	fake := quotes.NewFake(fakeQuotes...)
+	providerFor, cache := refresher.ProviderFor, refresher.Cache
+	refresher.ProviderFor = func(db *sql.DB, userID int) (quotes.QuoteProvider, error) {
+		return fake, nil
+	}
+	refresher.Cache = quotes.NewCache(quotes.DefaultCacheTTL)
+	t.Cleanup(func() { refresher.ProviderFor, refresher.Cache = providerFor, cache })
+	return db, fake
+}
+
+// serve calls handler with body as the authenticated test user.
+func serve(db *sql.DB, handler func(*sql.DB, http.ResponseWriter, *http.Request), method, body string, vars map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/", strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), "userClaims", &models.Claims{UserID: testUserID}))
+	if vars != nil {
+		r = mux.SetURLVars(r, vars)
+	}
+	w := httptest.NewRecorder()
+	handler(db, w, r)
+	return w
+}
+
+func TestAddAssetRejectsNonPositiveQuantity(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	for _, body := range []string{
+		`{"stockTag":"SAP","exchange":"XETR","price":150,"quantity":0}`,
+		`{"stockTag":"SAP","exchange":"XETR","price":150,"quantity":-10}`,
+	} {
+		if w := serve(db, AddAsset, http.MethodPost, body, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("AddAsset(%s) = %d %s, want 400", body, w.Code, w.Body)
+		}
+	}
+}
+
+func TestUpdateAssetRejectsNegativeSale(t *testing.T) {
+	db, _ := newTestDB(t)
+
+	if w := serve(db, AddAsset, http.MethodPost, `{"stockTag":"SAP","exchange":"XETR","price":150,"quantity":10,"tradedAt":"2025-01-10T00:00:00Z"}`, nil); w.Code != http.StatusCreated {
+		t.Fatalf("AddAsset = %d %s", w.Code, w.Body)
+	}
+	if w := serve(db, SellAsset, http.MethodPost, `{"stockTag":"SAP","exchange":"XETR","price":160,"quantity":5,"tradedAt":"2025-02-10T00:00:00Z"}`, nil); w.Code != http.StatusCreated {
+		t.Fatalf("SellAsset = %d %s", w.Code, w.Body)
+	}
+
+	w := serve(db, UpdateAsset, http.MethodPut, `{"stockTag":"SAP","exchange":"XETR","price":160,"quantity":-5}`, map[string]string{"id": "2"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("UpdateAsset = %d %s, want 400", w.Code, w.Body)
+	}
+	var quantity float64
+	if err := db.QueryRow(`SELECT quantity FROM assets WHERE id = 2`).Scan(&quantity); err != nil {
+		t.Fatalf("loading sale: %v", err)
+	}
+	if quantity != 5 {
+		t.Errorf("sale quantity = %v, want 5", quantity)
+	}
+}
+
+func TestUpdateSelectedAssetsNormalizesRefs(t *testing.T) {
+	db, _ := newTestDB(t, quotes.Quote{Symbol: "SAP", Exchange: "XETR", Price: 180})
+
+	w := serve(db, UpdateSelectedAssets, http.MethodPost, `{"assets":[{"symbol":"sap","exchange":"xetra"}]}`, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("UpdateSelectedAssets = %d %s", w.Code, w.Body)
+	}
+	var price sql.NullFloat64
+	if err := db.QueryRow(`SELECT lastPrice FROM instruments WHERE symbol = 'SAP' AND exchange = 'XETR'`).Scan(&price); err != nil {
+		t.Fatalf("loading instrument: %v", err)
+	}
+	if !price.Valid || price.Float64 != 180 {
+		t.Errorf("lastPrice = %v, want 180", price)
+	}
+}
+
+func TestAddAssetDefersRefreshWithoutCredits(t *testing.T) {
+	db, fake := newTestDB(t, quotes.Quote{Symbol: "SAP", Exchange: "XETR", Price: 180})
+	fake.SetCapabilities(quotes.Capabilities{CreditsPerMinute: 1})
+	limits := refresher.Limits
+	refresher.Limits = refresher.NewLimiter()
+	refresher.Limits.Spend(testUserID, fake.Name(), 1)
+	sap := []quotes.Symbol{{Symbol: "SAP", Exchange: "XETR"}}
+	t.Cleanup(func() {
+		refresher.Limits = limits
+		refresher.Pending.Remove(testUserID, sap)
+	})
+
+	if w := serve(db, AddAsset, http.MethodPost, `{"stockTag":"SAP","exchange":"XETR","price":150,"quantity":10}`, nil); w.Code != http.StatusCreated {
+		t.Fatalf("AddAsset = %d %s", w.Code, w.Body)
+	}
+	if fake.Calls() != 0 {
+		t.Errorf("provider called %d times with no credits left", fake.Calls())
+	}
+	if got := refresher.Pending.Peek(testUserID); !reflect.DeepEqual(got, sap) {
+		t.Errorf("pending = %v, want %v", got, sap)
+	}
+}
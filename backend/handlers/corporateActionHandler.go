@@ -0,0 +1,317 @@
+// /backend/handlers/corporateActionHandler.go
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"myinvestmap/events"
+	"myinvestmap/models"
+	"myinvestmap/portfolio"
+	"myinvestmap/quotes"
+	"myinvestmap/refresher"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// selectCorporateActionsSQL lists the actions of the instruments the
+	// user traded, their own and those fetched from a provider. A provider's
+	// action is left out when the user entered the same one themselves.
+	selectCorporateActionsSQL = `SELECT ca.id, ca.type, ca.instrument_id, i.symbol, i.exchange, ca.effectiveDate, ca.newShares, ca.oldShares, COALESCE(ca.new_instrument_id, 0), COALESCE(n.symbol, ''), COALESCE(n.exchange, ''), ca.costFraction, ca.source, ca.createdAt FROM corporate_actions ca JOIN instruments i ON i.id = ca.instrument_id LEFT JOIN instruments n ON n.id = ca.new_instrument_id WHERE (ca.user_id = ?1 OR (ca.user_id = 0 AND ca.instrument_id IN (SELECT instrument_id FROM assets WHERE user_id = ?1) AND NOT EXISTS (SELECT 1 FROM corporate_actions u WHERE u.user_id = ?1 AND u.instrument_id = ca.instrument_id AND u.type = ca.type AND u.effectiveDate = ca.effectiveDate))) ORDER BY ca.effectiveDate ASC, ca.id ASC`
+	insertCorporateActionSQL  = `INSERT INTO corporate_actions (user_id, instrument_id, type, effectiveDate, newShares, oldShares, new_instrument_id, costFraction, source) VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, 0), ?, 'manual')`
+	deleteCorporateActionSQL  = `DELETE FROM corporate_actions WHERE id = ? AND user_id = ?`
+	selectInstrumentPriceSQL  = `SELECT lastPrice FROM instruments WHERE id = ?`
+)
+
+func GetCorporateActions(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	actions, err := loadCorporateActions(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(actions)
+}
+
+// AddCorporateAction records an action the user entered by hand. It only
+// applies to the user's own positions.
+func AddCorporateAction(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	var action models.CorporateAction
+	if err := json.NewDecoder(r.Body).Decode(&action); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	newInstrumentID, err := validateCorporateAction(db, &action, userClaims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec(insertCorporateActionSQL, userClaims.UserID, action.InstrumentID, action.Type, action.EffectiveDate.Format("2006-01-02"), action.NewShares, action.OldShares, newInstrumentID, action.CostFraction)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		http.Error(w, fmt.Sprintf("a %s of %s on %s is already recorded", action.Type, action.StockTag, action.EffectiveDate.Format("2006-01-02")), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to execute SQL statement", http.StatusInternalServerError)
+		return
+	}
+	if id, err := result.LastInsertId(); err == nil {
+		action.ID = int(id)
+	}
+	action.Source = "manual"
+	action.NewInstrumentID = newInstrumentID
+	events.Default.Publish(events.Event{Type: events.CorporateActionCreated, UserID: userClaims.UserID, Data: action})
+
+	if newInstrumentID != 0 {
+		refresher.RefreshRequested(r.Context(), db, []quotes.Symbol{{Symbol: action.NewStockTag, Exchange: action.NewExchange}}, userClaims.UserID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(action)
+}
+
+// DeleteCorporateAction removes an action the user entered. Actions fetched
+// from a provider are shared and cannot be deleted.
+func DeleteCorporateAction(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid corporate action ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec(deleteCorporateActionSQL, id, userClaims.UserID)
+	if err != nil {
+		http.Error(w, "failed to execute SQL statement", http.StatusInternalServerError)
+		return
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "corporate action not found", http.StatusNotFound)
+		return
+	}
+	events.Default.Publish(events.Event{Type: events.CorporateActionDeleted, UserID: userClaims.UserID, Data: events.DeletedCorporateAction{ID: id}})
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "Deleted")
+}
+
+// FetchCorporateActions stores the split history of the user's stocks as
+// reported by their provider.
+func FetchCorporateActions(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	results, err := refresher.FetchSplits(r.Context(), db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]models.SplitFetchResult, 0, len(results))
+	stored := 0
+	for _, result := range results {
+		item := models.SplitFetchResult{Symbol: result.Symbol.Symbol, Exchange: result.Symbol.Exchange, Splits: result.Splits}
+		if result.Err != nil {
+			item.Error = result.Err.Error()
+		}
+		stored += result.Splits
+		response = append(response, item)
+	}
+	if stored > 0 {
+		events.Default.Publish(events.Event{Type: events.CorporateActionCreated, UserID: userClaims.UserID, Data: response})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// validateCorporateAction checks the action against its type and resolves
+// the instruments it refers to, returning the id of the instrument a symbol
+// change or spin-off leads to.
+func validateCorporateAction(db *sql.DB, action *models.CorporateAction, userID int) (int, error) {
+	action.StockTag = strings.TrimSpace(action.StockTag)
+	action.Exchange = strings.TrimSpace(action.Exchange)
+	action.NewStockTag = strings.TrimSpace(action.NewStockTag)
+	action.NewExchange = strings.TrimSpace(action.NewExchange)
+	if action.EffectiveDate.IsZero() {
+		return 0, errors.New("effectiveDate is required")
+	}
+	action.EffectiveDate.Time = refresher.StartOfDay(action.EffectiveDate.Time)
+
+	switch action.Type {
+	case portfolio.ActionSplit:
+		if action.OldShares <= 0 || action.NewShares <= action.OldShares {
+			return 0, errors.New("a split needs newShares greater than oldShares, e.g. 4 for 1")
+		}
+	case portfolio.ActionReverseSplit:
+		if action.NewShares <= 0 || action.NewShares >= action.OldShares {
+			return 0, errors.New("a reverse split needs newShares less than oldShares, e.g. 1 for 10")
+		}
+	case portfolio.ActionSymbolChange:
+		if action.NewShares == 0 && action.OldShares == 0 {
+			action.NewShares, action.OldShares = 1, 1
+		}
+		if action.NewShares <= 0 || action.OldShares <= 0 {
+			return 0, errors.New("newShares and oldShares must be positive")
+		}
+	case portfolio.ActionSpinOff:
+		if action.NewShares <= 0 || action.OldShares <= 0 {
+			return 0, errors.New("a spin-off needs newShares received per oldShares held")
+		}
+		if action.CostFraction < 0 || action.CostFraction >= 1 {
+			return 0, errors.New("costFraction must be at least 0 and less than 1")
+		}
+	default:
+		return 0, fmt.Errorf("unknown type %q", action.Type)
+	}
+	if action.Type != portfolio.ActionSpinOff {
+		action.CostFraction = 0
+	}
+
+	id, listing, _, err := findHeldInstrument(db, action.StockTag, action.Exchange, userID)
+	if err != nil {
+		return 0, err
+	}
+	action.InstrumentID = id
+	action.StockTag, action.Exchange = listing.Symbol, listing.Exchange
+
+	if action.Type != portfolio.ActionSymbolChange && action.Type != portfolio.ActionSpinOff {
+		action.NewStockTag, action.NewExchange = "", ""
+		return 0, nil
+	}
+	if action.NewStockTag == "" {
+		return 0, errors.New("newStockTag is required")
+	}
+	if action.NewExchange == "" {
+		action.NewExchange = action.Exchange
+	}
+	if action.NewStockTag == action.StockTag && action.NewExchange == action.Exchange {
+		return 0, errors.New("newStockTag must name a different instrument")
+	}
+	newInstrumentID, _, err := ensureInstrument(db, action.NewStockTag, action.NewExchange)
+	if err != nil {
+		return 0, err
+	}
+	return newInstrumentID, nil
+}
+
+func loadCorporateActions(db queryer, userID int) ([]models.CorporateAction, error) {
+	rows, err := db.Query(selectCorporateActionsSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching corporate actions: %v", err)
+	}
+	defer rows.Close()
+
+	actions := []models.CorporateAction{}
+	for rows.Next() {
+		var (
+			action        models.CorporateAction
+			effectiveDate string
+		)
+		if err := rows.Scan(&action.ID, &action.Type, &action.InstrumentID, &action.StockTag, &action.Exchange, &effectiveDate, &action.NewShares, &action.OldShares, &action.NewInstrumentID, &action.NewStockTag, &action.NewExchange, &action.CostFraction, &action.Source, &action.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning corporate action: %v", err)
+		}
+		day, _, err := parseTimeParam(effectiveDate)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing effectiveDate: %v", err)
+		}
+		action.EffectiveDate = models.Date{Time: day}
+		actions = append(actions, action)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching corporate actions: %v", err)
+	}
+	return actions, nil
+}
+
+// toPortfolioActions converts actions into the form the portfolio package
+// replays them in.
+func toPortfolioActions(actions []models.CorporateAction) []portfolio.Action {
+	result := make([]portfolio.Action, 0, len(actions))
+	for _, a := range actions {
+		result = append(result, portfolio.Action{
+			ID:              a.ID,
+			Type:            a.Type,
+			InstrumentID:    a.InstrumentID,
+			EffectiveDate:   a.EffectiveDate.Time,
+			Ratio:           a.NewShares / a.OldShares,
+			NewInstrumentID: a.NewInstrumentID,
+			CostFraction:    a.CostFraction,
+		})
+	}
+	return result
+}
+
+// adjustAsset sets the asset's Adjustment when actions changed the shares it
+// stands for. When they became another instrument, that instrument's price
+// is the current one.
+func adjustAsset(db *sql.DB, asset *models.Asset, actions []models.CorporateAction, replayed []portfolio.Action) error {
+	adjusted := portfolio.AdjustTrade(portfolio.Trade{
+		ID:           asset.ID,
+		InstrumentID: asset.InstrumentID,
+		IsPurchase:   asset.IsPurchase,
+		Quantity:     asset.Quantity,
+		Price:        asset.Price,
+		TradedAt:     asset.TradedAt,
+	}, replayed)
+	if len(adjusted.Actions) == 0 {
+		return nil
+	}
+
+	adjustment := &models.AssetAdjustment{
+		StockTag: asset.StockTag,
+		Exchange: asset.Exchange,
+		Price:    adjusted.Price,
+		Quantity: adjusted.Quantity,
+		Actions:  adjusted.Actions,
+	}
+	if adjusted.InstrumentID != asset.InstrumentID {
+		for _, a := range actions {
+			if a.NewInstrumentID == adjusted.InstrumentID {
+				adjustment.StockTag, adjustment.Exchange = a.NewStockTag, a.NewExchange
+				break
+			}
+		}
+		var price sql.NullFloat64
+		if err := db.QueryRow(selectInstrumentPriceSQL, adjusted.InstrumentID).Scan(&price); err != nil {
+			return fmt.Errorf("error fetching instrument price: %v", err)
+		}
+		if price.Valid {
+			adjustment.CurrentPrice = &price.Float64
+		}
+	}
+	asset.Adjustment = adjustment
+	return nil
+}
@@ -0,0 +1,183 @@
+// /backend/handlers/eventsHandler.go
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"myinvestmap/events"
+	"myinvestmap/models"
+	"myinvestmap/quotes"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	selectHeldSymbolsSQL = `SELECT DISTINCT i.symbol, i.exchange FROM instruments i WHERE i.id IN (SELECT instrument_id FROM assets WHERE user_id = ?1 UNION SELECT ca.new_instrument_id FROM corporate_actions ca JOIN assets a ON a.instrument_id = ca.instrument_id AND a.user_id = ?1 WHERE ca.user_id IN (0, ?1))`
+
+	eventsKeepAlive = 25 * time.Second
+)
+
+// StreamEvents sends the user's portfolio events as Server-Sent Events: their
+// own transaction and refresh events, and quote updates for the instruments
+// they hold. A reconnecting EventSource sends Last-Event-ID and gets the
+// missed events replayed; when those are no longer kept, or the client falls
+// behind, a "resync" event tells it to reload its state instead.
+func StreamEvents(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	feed := &eventFeed{db: db, userID: userClaims.UserID}
+	if err := feed.loadHeld(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sub := events.Default.Subscribe(feed.wants, events.DefaultBuffer)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	last := sub.StartID
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		since, err := strconv.ParseUint(value, 10, 64)
+		replay, ok := events.Default.Since(since, feed.wants)
+		if err != nil || !ok {
+			if err := writeResync(w, last, "missed events are no longer available"); err != nil {
+				return
+			}
+		} else {
+			last = since
+			for _, e := range replay {
+				if err := writeServerSentEvent(w, e); err != nil {
+					return
+				}
+				last = e.ID
+			}
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-streamsDone:
+			return
+		case e := <-sub.C:
+			if e.ID <= last {
+				continue
+			}
+			last = e.ID
+			if e.ChangesTransactions() {
+				if err := feed.loadHeld(); err != nil {
+					log.Printf("Event stream for user %d: %v", feed.userID, err)
+				}
+			}
+			err = writeServerSentEvent(w, e)
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		}
+		if err == nil && sub.Lagging() {
+			sub.Resync()
+			last = events.Default.LastID()
+			err = writeResync(w, last, "client fell behind")
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+type eventFeed struct {
+	db     *sql.DB
+	userID int
+
+	mu   sync.Mutex
+	held map[quotes.Symbol]bool
+}
+
+// wants filters the bus down to the user's own events and quotes of the
+// instruments they hold. A new transaction's instrument counts as held right
+// away, as its first quote may be published before loadHeld has run.
+func (f *eventFeed) wants(e events.Event) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if e.UserID != 0 {
+		if e.UserID != f.userID {
+			return false
+		}
+		if asset, ok := e.Data.(models.Asset); ok && e.Type == events.TransactionCreated {
+			f.held[quotes.Symbol{Symbol: asset.StockTag, Exchange: asset.Exchange}] = true
+		}
+		return true
+	}
+	q, ok := e.Data.(events.Quote)
+	if !ok {
+		return false
+	}
+	return f.held[quotes.Symbol{Symbol: q.Symbol, Exchange: q.Exchange}]
+}
+
+func (f *eventFeed) loadHeld() error {
+	rows, err := f.db.Query(selectHeldSymbolsSQL, f.userID)
+	if err != nil {
+		return fmt.Errorf("error fetching held symbols: %v", err)
+	}
+	defer rows.Close()
+
+	held := make(map[quotes.Symbol]bool)
+	for rows.Next() {
+		var s quotes.Symbol
+		if err := rows.Scan(&s.Symbol, &s.Exchange); err != nil {
+			return fmt.Errorf("error scanning held symbol: %v", err)
+		}
+		held[s] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error fetching held symbols: %v", err)
+	}
+
+	f.mu.Lock()
+	f.held = held
+	f.mu.Unlock()
+	return nil
+}
+
+func writeServerSentEvent(w io.Writer, e events.Event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return fmt.Errorf("error encoding event: %v", err)
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
+func writeResync(w io.Writer, id uint64, reason string) error {
+	data, _ := json.Marshal(map[string]string{"reason": reason})
+	_, err := fmt.Fprintf(w, "id: %d\nevent: resync\ndata: %s\n\n", id, data)
+	return err
+}
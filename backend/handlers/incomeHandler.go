@@ -0,0 +1,423 @@
+// /backend/handlers/incomeHandler.go
+
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"myinvestmap/events"
+	"myinvestmap/models"
+	"myinvestmap/quotes"
+	"myinvestmap/refresher"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	insertIncomeSQL         = `INSERT INTO income (user_id, instrument_id, type, account, currency, grossAmount, withholdingTax, exDate, payDate) VALUES (?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?)`
+	selectIncomeSQL         = `SELECT n.id, n.instrument_id, n.type, i.symbol, i.exchange, COALESCE(n.account, ''), n.currency, n.grossAmount, n.withholdingTax, n.exDate, n.payDate, n.createdAt, n.updatedAt FROM income n JOIN instruments i ON i.id = n.instrument_id WHERE n.user_id = ? AND n.payDate >= ? AND n.payDate <= ? ORDER BY n.payDate ASC, n.id ASC`
+	updateIncomeByIDSQL     = `UPDATE income SET instrument_id = ?, type = ?, account = NULLIF(?, ''), currency = ?, grossAmount = ?, withholdingTax = ?, exDate = ?, payDate = ?, updatedAt = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?`
+	deleteIncomeSQL         = `DELETE FROM income WHERE id = ? AND user_id = ?`
+	selectHeldInstrumentSQL = `SELECT i.id, i.symbol, i.exchange, COALESCE(i.currency, '') FROM instruments i WHERE i.symbol = ? AND (? = '' OR i.exchange = ?) AND EXISTS (SELECT 1 FROM assets a WHERE a.instrument_id = i.id AND a.user_id = ?)`
+)
+
+// Income is grouped by the month or year it was paid in, or by instrument.
+const (
+	incomeByMonth      = "month"
+	incomeByYear       = "year"
+	incomeByInstrument = "instrument"
+)
+
+func AddIncome(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	var income models.Income
+	if err := json.NewDecoder(r.Body).Decode(&income); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateIncome(db, &income, userClaims.UserID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec(insertIncomeSQL, userClaims.UserID, income.InstrumentID, income.Type, income.Account, income.Currency, income.GrossAmount, income.WithholdingTax, formatDate(income.ExDate), income.PayDate.Format("2006-01-02"))
+	if err != nil {
+		http.Error(w, "failed to execute SQL statement", http.StatusInternalServerError)
+		return
+	}
+	if id, err := result.LastInsertId(); err == nil {
+		income.ID = int(id)
+	}
+	events.Default.Publish(events.Event{Type: events.IncomeCreated, UserID: userClaims.UserID, Data: income})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(income)
+}
+
+// GetIncome lists the user's income ledger, optionally limited to payments
+// made between from and to.
+func GetIncome(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ledger, err := loadIncome(db, userClaims.UserID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ledger)
+}
+
+func UpdateIncome(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid income ID", http.StatusBadRequest)
+		return
+	}
+
+	var income models.Income
+	if err := json.NewDecoder(r.Body).Decode(&income); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateIncome(db, &income, userClaims.UserID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec(updateIncomeByIDSQL, income.InstrumentID, income.Type, income.Account, income.Currency, income.GrossAmount, income.WithholdingTax, formatDate(income.ExDate), income.PayDate.Format("2006-01-02"), id, userClaims.UserID)
+	if err != nil {
+		http.Error(w, "failed to execute SQL statement", http.StatusInternalServerError)
+		return
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		http.Error(w, "income not found", http.StatusNotFound)
+		return
+	}
+	income.ID = id
+	events.Default.Publish(events.Event{Type: events.IncomeUpdated, UserID: userClaims.UserID, Data: income})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(income)
+}
+
+func DeleteIncome(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid income ID", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec(deleteIncomeSQL, id, userClaims.UserID)
+	if err != nil {
+		http.Error(w, "failed to execute SQL statement", http.StatusInternalServerError)
+		return
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+		events.Default.Publish(events.Event{Type: events.IncomeDeleted, UserID: userClaims.UserID, Data: events.DeletedIncome{ID: id}})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "Deleted")
+}
+
+// GetIncomeByPeriod totals the user's income per month, or per year with
+// period=year, in their base currency.
+func GetIncomeByPeriod(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	groupBy := r.URL.Query().Get("period")
+	if groupBy == "" {
+		groupBy = incomeByMonth
+	}
+	if groupBy != incomeByMonth && groupBy != incomeByYear {
+		http.Error(w, "period must be month or year", http.StatusBadRequest)
+		return
+	}
+	writeIncomeSummary(db, w, r, groupBy)
+}
+
+// GetIncomeByInstrument totals the user's income per instrument in their
+// base currency.
+func GetIncomeByInstrument(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	writeIncomeSummary(db, w, r, incomeByInstrument)
+}
+
+func writeIncomeSummary(db *sql.DB, w http.ResponseWriter, r *http.Request, groupBy string) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings, err := loadSettings(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, "failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	ledger, err := loadIncome(db, userClaims.UserID, from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	summary := summarizeIncome(r.Context(), db, userClaims.UserID, settings.BaseCurrency, groupBy, ledger)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// summarizeIncome converts every payment at the rate of its pay date and
+// sums them per group. Groups are sorted chronologically, or by symbol for
+// instruments.
+func summarizeIncome(ctx context.Context, db *sql.DB, userID int, baseCurrency, groupBy string, ledger []models.Income) models.IncomeSummary {
+	converter := refresher.NewConverter(ctx, db, userID, baseCurrency)
+	summary := models.IncomeSummary{BaseCurrency: converter.Base, GroupBy: groupBy, Groups: []models.IncomeGroup{}}
+
+	groups := make(map[string]*models.IncomeGroup)
+	for _, income := range ledger {
+		rate, ok := converter.Rate(income.Currency, income.PayDate.Time)
+		if !ok {
+			continue
+		}
+
+		var key string
+		switch groupBy {
+		case incomeByYear:
+			key = income.PayDate.Format("2006")
+		case incomeByInstrument:
+			key = quotes.Symbol{Symbol: income.StockTag, Exchange: income.Exchange}.String()
+		default:
+			key = income.PayDate.Format("2006-01")
+		}
+
+		group, ok := groups[key]
+		if !ok {
+			group = &models.IncomeGroup{Key: key}
+			if groupBy == incomeByInstrument {
+				group.Symbol, group.Exchange = income.StockTag, income.Exchange
+			}
+			groups[key] = group
+		}
+		group.GrossAmount += income.GrossAmount * rate.Rate
+		group.WithholdingTax += income.WithholdingTax * rate.Rate
+		group.NetAmount += income.NetAmount * rate.Rate
+		group.Payments++
+	}
+
+	for _, group := range groups {
+		summary.GrossAmount += group.GrossAmount
+		summary.WithholdingTax += group.WithholdingTax
+		summary.NetAmount += group.NetAmount
+		summary.Groups = append(summary.Groups, *group)
+	}
+	sort.Slice(summary.Groups, func(i, j int) bool {
+		return summary.Groups[i].Key < summary.Groups[j].Key
+	})
+	summary.MissingRates = converter.Missing()
+	return summary
+}
+
+// validateIncome checks the payment and resolves its instrument, which must
+// be one the user holds or held. A payment without a currency takes the one
+// the instrument is quoted in.
+func validateIncome(db *sql.DB, income *models.Income, userID int) error {
+	income.StockTag = strings.TrimSpace(income.StockTag)
+	income.Exchange = strings.TrimSpace(income.Exchange)
+	income.Account = strings.TrimSpace(income.Account)
+	income.Currency = quotes.NormalizeCurrency(income.Currency)
+	if income.Type == "" {
+		income.Type = models.IncomeTypeDividend
+	}
+
+	switch {
+	case income.Type != models.IncomeTypeDividend && income.Type != models.IncomeTypeInterest:
+		return fmt.Errorf("unknown type %q", income.Type)
+	case income.StockTag == "":
+		return errors.New("stockTag is required")
+	case income.GrossAmount <= 0:
+		return errors.New("grossAmount must be positive")
+	case income.WithholdingTax < 0 || income.WithholdingTax > income.GrossAmount:
+		return errors.New("withholdingTax must be between 0 and grossAmount")
+	case income.PayDate.IsZero():
+		return errors.New("payDate is required")
+	case income.Type == models.IncomeTypeDividend && income.ExDate == nil:
+		return errors.New("exDate is required for dividends")
+	case income.Currency != "" && !quotes.IsCurrencyCode(income.Currency):
+		return fmt.Errorf("invalid currency %q", income.Currency)
+	}
+
+	income.PayDate.Time = refresher.StartOfDay(income.PayDate.Time)
+	if income.ExDate != nil {
+		exDate := models.Date{Time: refresher.StartOfDay(income.ExDate.Time)}
+		if exDate.After(income.PayDate.Time) {
+			return errors.New("exDate must not be after payDate")
+		}
+		income.ExDate = &exDate
+	}
+
+	id, listing, currency, err := findHeldInstrument(db, income.StockTag, income.Exchange, userID)
+	if err != nil {
+		return err
+	}
+	income.InstrumentID = id
+	income.StockTag, income.Exchange = listing.Symbol, listing.Exchange
+	if income.Currency == "" {
+		income.Currency = quotes.NormalizeCurrency(currency)
+	}
+	if income.Currency == "" {
+		return errors.New("currency is required")
+	}
+	income.NetAmount = income.GrossAmount - income.WithholdingTax
+	return nil
+}
+
+// findHeldInstrument resolves a symbol to the listing the user has traded.
+// The exchange may be omitted when the user only traded it on one exchange.
+func findHeldInstrument(db *sql.DB, symbol, exchange string, userID int) (int, quotes.Symbol, string, error) {
+	listing := quotes.Symbol{Symbol: symbol, Exchange: exchange}.Normalize()
+	symbol, exchange = listing.Symbol, listing.Exchange
+	rows, err := db.Query(selectHeldInstrumentSQL, symbol, exchange, exchange, userID)
+	if err != nil {
+		return 0, quotes.Symbol{}, "", fmt.Errorf("error fetching instrument: %v", err)
+	}
+	defer rows.Close()
+
+	var (
+		ids       []int
+		listings  []quotes.Symbol
+		exchanges []string
+		currency  string
+	)
+	for rows.Next() {
+		var id int
+		var listing quotes.Symbol
+		if err := rows.Scan(&id, &listing.Symbol, &listing.Exchange, &currency); err != nil {
+			return 0, quotes.Symbol{}, "", fmt.Errorf("error scanning instrument: %v", err)
+		}
+		ids = append(ids, id)
+		listings = append(listings, listing)
+		exchanges = append(exchanges, listing.Exchange)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, quotes.Symbol{}, "", fmt.Errorf("error fetching instrument: %v", err)
+	}
+
+	switch len(ids) {
+	case 0:
+		return 0, quotes.Symbol{}, "", fmt.Errorf("%w: %s is not in the portfolio", errInstrumentNotFound, symbol)
+	case 1:
+		return ids[0], listings[0], currency, nil
+	}
+	return 0, quotes.Symbol{}, "", fmt.Errorf("%s is held on %s; pass exchange", symbol, strings.Join(exchanges, ", "))
+}
+
+// loadIncome reads the payments made between from and to, both inclusive
+// days in the 2006-01-02 format.
+func loadIncome(db *sql.DB, userID int, from, to string) ([]models.Income, error) {
+	rows, err := db.Query(selectIncomeSQL, userID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching income: %v", err)
+	}
+	defer rows.Close()
+
+	ledger := []models.Income{}
+	for rows.Next() {
+		var (
+			income  models.Income
+			exDate  sql.NullString
+			payDate string
+		)
+		if err := rows.Scan(&income.ID, &income.InstrumentID, &income.Type, &income.StockTag, &income.Exchange, &income.Account, &income.Currency, &income.GrossAmount, &income.WithholdingTax, &exDate, &payDate, &income.CreatedAt, &income.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning income: %v", err)
+		}
+		if income.PayDate.Time, err = time.Parse("2006-01-02", payDate); err != nil {
+			return nil, fmt.Errorf("error parsing payDate: %v", err)
+		}
+		if exDate.Valid {
+			day, err := time.Parse("2006-01-02", exDate.String)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing exDate: %v", err)
+			}
+			income.ExDate = &models.Date{Time: day}
+		}
+		income.NetAmount = income.GrossAmount - income.WithholdingTax
+		ledger = append(ledger, income)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching income: %v", err)
+	}
+	return ledger, nil
+}
+
+// parseDateRange reads the from and to query parameters, which default to
+// everything.
+func parseDateRange(r *http.Request) (string, string, error) {
+	from, to := "0000-01-01", "9999-12-31"
+	for _, param := range []struct {
+		name  string
+		value *string
+	}{{"from", &from}, {"to", &to}} {
+		value := r.URL.Query().Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, _, err := parseTimeParam(value)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid %s: %v", param.name, err)
+		}
+		*param.value = t.Format("2006-01-02")
+	}
+	return from, to, nil
+}
+
+func formatDate(t *models.Date) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Format("2006-01-02")
+}
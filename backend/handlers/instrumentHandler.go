@@ -0,0 +1,366 @@
+// /backend/handlers/instrumentHandler.go
+
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"myinvestmap/models"
+	"myinvestmap/quotes"
+	"myinvestmap/refresher"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	insertInstrumentSQL       = `INSERT OR IGNORE INTO instruments (symbol, exchange, type, currency) VALUES (?, ?, ?, NULLIF(?, ''))`
+	selectInstrumentIDSQL     = `SELECT id FROM instruments WHERE symbol = ? AND exchange = ?`
+	selectInstrumentHeldSQL   = `SELECT EXISTS (SELECT 1 FROM assets WHERE instrument_id = ?)`
+	selectInstrumentsBySymSQL = `SELECT id, exchange FROM instruments WHERE symbol = ? AND (? = '' OR exchange = ?) AND EXISTS (SELECT 1 FROM assets WHERE instrument_id = instruments.id)`
+	selectPriceHistorySQL     = `SELECT price, priceAt, source FROM price_history WHERE instrument_id = ? AND priceAt >= ? AND priceAt <= ? ORDER BY priceAt ASC, id ASC`
+	selectCandlesSQL          = `SELECT tradingDay, open, high, low, close, volume, source FROM price_candles WHERE instrument_id = ? AND tradingDay >= ? AND tradingDay <= ? ORDER BY tradingDay ASC`
+)
+
+var errInstrumentNotFound = errors.New("instrument not found")
+
+// priceIntervals are the bucket sizes accepted by GetInstrumentPrices. Each
+// bucket reports the last price recorded in it.
+var priceIntervals = map[string]time.Duration{
+	"raw":   0,
+	"1min":  time.Minute,
+	"5min":  5 * time.Minute,
+	"15min": 15 * time.Minute,
+	"30min": 30 * time.Minute,
+	"1h":    time.Hour,
+	"1day":  24 * time.Hour,
+	"1week": 7 * 24 * time.Hour,
+}
+
+// execQueryRower is satisfied by both *sql.DB and *sql.Tx.
+type execQueryRower interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// ensureInstrument returns the id of the instrument for symbol on exchange,
+// creating it on first use. isNew reports whether no asset referenced it yet,
+// which includes listings only known from a symbol search. Instruments are
+// stored normalized, so that every spelling of a listing shares one.
+func ensureInstrument(db execQueryRower, symbol, exchange string) (id int, isNew bool, err error) {
+	listing := quotes.Symbol{Symbol: symbol, Exchange: exchange}.Normalize()
+	symbol, exchange = listing.Symbol, listing.Exchange
+	if symbol == "" {
+		return 0, false, fmt.Errorf("stockTag is required")
+	}
+
+	instrumentType, currency := models.InstrumentTypeStock, ""
+	if (quotes.Symbol{Symbol: symbol, Exchange: exchange}).IsCrypto() {
+		// A pair is priced in its quote currency.
+		instrumentType = models.InstrumentTypeCrypto
+		_, currency, _ = quotes.ParsePair(symbol)
+	}
+
+	if _, err := db.Exec(insertInstrumentSQL, symbol, exchange, instrumentType, currency); err != nil {
+		return 0, false, fmt.Errorf("error saving instrument: %v", err)
+	}
+	if err := db.QueryRow(selectInstrumentIDSQL, symbol, exchange).Scan(&id); err != nil {
+		return 0, false, fmt.Errorf("error fetching instrument: %v", err)
+	}
+
+	var held bool
+	if err := db.QueryRow(selectInstrumentHeldSQL, id).Scan(&held); err != nil {
+		return 0, false, fmt.Errorf("error fetching instrument: %v", err)
+	}
+	return id, !held, nil
+}
+
+func GetInstrumentPrices(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	symbol := mux.Vars(r)["symbol"]
+	query := r.URL.Query()
+	exchange := query.Get("exchange")
+
+	interval := query.Get("interval")
+	if interval == "" {
+		interval = "raw"
+	}
+	bucket, ok := priceIntervals[interval]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unsupported interval %q", interval), http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	if value := query.Get("to"); value != "" {
+		parsed, dateOnly, err := parseTimeParam(value)
+		if err != nil {
+			http.Error(w, "invalid to parameter", http.StatusBadRequest)
+			return
+		}
+		if dateOnly {
+			parsed = parsed.Add(24*time.Hour - time.Nanosecond)
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -30)
+	if value := query.Get("from"); value != "" {
+		parsed, _, err := parseTimeParam(value)
+		if err != nil {
+			http.Error(w, "invalid from parameter", http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+
+	instrumentID, listing, err := findInstrument(db, symbol, exchange)
+	if errors.Is(err, errInstrumentNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(selectPriceHistorySQL, instrumentID, from, to)
+	if err != nil {
+		http.Error(w, "failed to query price history", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	series := models.PriceSeries{
+		Symbol:   listing.Symbol,
+		Exchange: listing.Exchange,
+		Interval: interval,
+		From:     from,
+		To:       to,
+		Prices:   []models.PricePoint{},
+	}
+	for rows.Next() {
+		var point models.PricePoint
+		if err := rows.Scan(&point.Price, &point.Time, &point.Source); err != nil {
+			http.Error(w, "failed to scan price row", http.StatusInternalServerError)
+			return
+		}
+		if bucket > 0 {
+			point.Time = point.Time.Truncate(bucket)
+			if n := len(series.Prices); n > 0 && series.Prices[n-1].Time.Equal(point.Time) {
+				series.Prices[n-1] = point
+				continue
+			}
+		}
+		series.Prices = append(series.Prices, point)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "error iterating over price rows", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(series)
+}
+
+// findInstrument resolves a symbol to a single held listing. The exchange may
+// be omitted when the symbol is only held on one exchange. Since a slash
+// cannot be part of the path, crypto pairs may be given as BTC-USD.
+func findInstrument(db *sql.DB, symbol, exchange string) (int, quotes.Symbol, error) {
+	id, listing, err := findListedInstrument(db, symbol, exchange)
+	if !errors.Is(err, errInstrumentNotFound) {
+		return id, listing, err
+	}
+	if exchange != "" && !(quotes.Symbol{Exchange: exchange}).IsCrypto() {
+		return id, listing, err
+	}
+	base, quote, pairErr := quotes.ParsePair(symbol)
+	if pairErr != nil {
+		return id, listing, err
+	}
+	return findListedInstrument(db, base+"/"+quote, quotes.CryptoExchange)
+}
+
+func findListedInstrument(db *sql.DB, symbol, exchange string) (int, quotes.Symbol, error) {
+	listing := quotes.Symbol{Symbol: symbol, Exchange: exchange}.Normalize()
+	symbol, exchange = listing.Symbol, listing.Exchange
+	rows, err := db.Query(selectInstrumentsBySymSQL, symbol, exchange, exchange)
+	if err != nil {
+		return 0, quotes.Symbol{}, fmt.Errorf("error fetching instrument: %v", err)
+	}
+	defer rows.Close()
+
+	var (
+		ids       []int
+		exchanges []string
+	)
+	for rows.Next() {
+		var id int
+		var listing string
+		if err := rows.Scan(&id, &listing); err != nil {
+			return 0, quotes.Symbol{}, fmt.Errorf("error scanning instrument: %v", err)
+		}
+		ids = append(ids, id)
+		exchanges = append(exchanges, listing)
+	}
+
+	switch len(ids) {
+	case 0:
+		return 0, quotes.Symbol{}, fmt.Errorf("%w: %s", errInstrumentNotFound, symbol)
+	case 1:
+		return ids[0], quotes.Symbol{Symbol: symbol, Exchange: exchanges[0]}, nil
+	}
+	return 0, quotes.Symbol{}, fmt.Errorf("%s is listed on %s; pass exchange", symbol, strings.Join(exchanges, ", "))
+}
+
+// parseTimeParam accepts RFC 3339 timestamps and plain dates, reporting
+// which of the two it got.
+func parseTimeParam(value string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), false, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	return t, true, err
+}
+
+func GetInstrumentCandles(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	symbol := mux.Vars(r)["symbol"]
+	query := r.URL.Query()
+
+	to := time.Now().UTC()
+	if value := query.Get("to"); value != "" {
+		parsed, _, err := parseTimeParam(value)
+		if err != nil {
+			http.Error(w, "invalid to parameter", http.StatusBadRequest)
+			return
+		}
+		to = parsed
+	}
+	from := to.AddDate(-1, 0, 0)
+	if value := query.Get("from"); value != "" {
+		parsed, _, err := parseTimeParam(value)
+		if err != nil {
+			http.Error(w, "invalid from parameter", http.StatusBadRequest)
+			return
+		}
+		from = parsed
+	}
+
+	instrumentID, _, err := findInstrument(db, symbol, query.Get("exchange"))
+	if errors.Is(err, errInstrumentNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(selectCandlesSQL, instrumentID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		http.Error(w, "failed to query candles", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	candles := []models.Candle{}
+	for rows.Next() {
+		var c models.Candle
+		var open, high, low, volume sql.NullFloat64
+		if err := rows.Scan(&c.Day, &open, &high, &low, &c.Close, &volume, &c.Source); err != nil {
+			http.Error(w, "failed to scan candle row", http.StatusInternalServerError)
+			return
+		}
+		c.Open, c.High, c.Low, c.Volume = open.Float64, high.Float64, low.Float64, volume.Float64
+		candles = append(candles, c)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "error iterating over candle rows", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(candles)
+}
+
+func BackfillInstrument(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	instrumentID, _, err := findInstrument(db, mux.Vars(r)["symbol"], r.URL.Query().Get("exchange"))
+	if errors.Is(err, errInstrumentNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result := refresher.BackfillInstrument(r.Context(), db, instrumentID, userClaims.UserID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toBackfillResult(result))
+}
+
+func BackfillInstruments(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	results, err := refresher.BackfillUser(r.Context(), db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]models.BackfillResult, 0, len(results))
+	for _, result := range results {
+		response = append(response, toBackfillResult(result))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func toBackfillResult(result refresher.BackfillResult) models.BackfillResult {
+	response := models.BackfillResult{
+		Symbol:   result.Symbol.Symbol,
+		Exchange: result.Symbol.Exchange,
+		Candles:  result.Candles,
+	}
+	if !result.From.IsZero() {
+		response.From = result.From.Format("2006-01-02")
+		response.To = result.To.Format("2006-01-02")
+	}
+	if result.Err != nil {
+		response.Error = result.Err.Error()
+	}
+	return response
+}
+
+// backfillNewInstrument starts the first backfill of an instrument in the
+// background, so that adding a position does not wait on the provider.
+func backfillNewInstrument(db *sql.DB, instrumentID, userID int) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if result := refresher.BackfillInstrument(ctx, db, instrumentID, userID); result.Err != nil {
+			log.Printf("Backfill of %s failed: %v", result.Symbol, result.Err)
+		}
+	}()
+}
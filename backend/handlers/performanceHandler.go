@@ -0,0 +1,349 @@
+// /backend/handlers/performanceHandler.go
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"myinvestmap/models"
+	"myinvestmap/portfolio"
+	"myinvestmap/quotes"
+	"myinvestmap/refresher"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	selectTradeCostsSQL = `SELECT COALESCE(a.account, ''), COALESCE(a.currency, i.currency, ''), a.price, a.quantity, a.fee, a.tax, a.tradedAt FROM assets a JOIN instruments i ON i.id = a.instrument_id WHERE a.user_id = ?`
+)
+
+// GetPnL reports the user's realized P/L per closed lot, instrument and
+// period, and the unrealized P/L of their open lots. The from and to query
+// parameters limit the sales reported; period groups them by month or year,
+// the default.
+func GetPnL(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	groupBy := r.URL.Query().Get("period")
+	if groupBy == "" {
+		groupBy = incomeByYear
+	}
+	if groupBy != incomeByMonth && groupBy != incomeByYear {
+		http.Error(w, "period must be month or year", http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings, err := loadSettings(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, "failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	replayed, err := replayPortfolio(r.Context(), db, userClaims.UserID, settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	report := models.PnLReport{
+		BaseCurrency:    replayed.converter.Base,
+		CostBasisMethod: settings.CostBasisMethod,
+		GroupBy:         groupBy,
+		RealizedLots:    []models.RealizedLot{},
+		OpenLots:        []models.UnrealizedLot{},
+		Instruments:     []models.InstrumentPnL{},
+		Periods:         []models.PeriodPnL{},
+		Years:           []models.PeriodPnL{},
+	}
+	byInstrument := make(map[int]*models.InstrumentPnL)
+	instrumentPnL := func(instrumentID int, instrument *positionInstrument) *models.InstrumentPnL {
+		pnl, ok := byInstrument[instrumentID]
+		if !ok {
+			pnl = &models.InstrumentPnL{InstrumentID: instrumentID, Symbol: instrument.symbol, Exchange: instrument.exchange, FXMissing: replayed.fxMissing[instrumentID]}
+			byInstrument[instrumentID] = pnl
+		}
+		return pnl
+	}
+	periods := make(map[string]*models.PeriodPnL)
+	years := make(map[string]*models.PeriodPnL)
+
+	for instrumentID, h := range replayed.holdings {
+		instrument, err := replayed.instruments.get(instrumentID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		base := replayed.baseHoldings[instrumentID]
+		fxMissing := replayed.fxMissing[instrumentID]
+
+		for i, sale := range h.Sales {
+			day := sale.SoldAt.UTC().Format("2006-01-02")
+			if len(sale.Matches) == 0 || day < from || day > to {
+				continue
+			}
+			var baseSale portfolio.Sale
+			if base != nil && i < len(base.Sales) {
+				baseSale = base.Sales[i]
+			}
+
+			for j, m := range sale.Matches {
+				lot := models.RealizedLot{
+					SaleID:       sale.TradeID,
+					LotID:        m.TradeID,
+					InstrumentID: instrumentID,
+					Symbol:       instrument.symbol,
+					Exchange:     instrument.exchange,
+					Currency:     instrument.currency,
+					AcquiredAt:   m.AcquiredAt,
+					SoldAt:       sale.SoldAt,
+					Quantity:     m.Quantity,
+					Proceeds:     m.Proceeds,
+					Cost:         m.Cost,
+					RealizedPL:   m.Proceeds - m.Cost,
+					FXMissing:    fxMissing,
+				}
+				if j < len(baseSale.Matches) {
+					lot.ProceedsInBase = baseSale.Matches[j].Proceeds
+					lot.CostInBase = baseSale.Matches[j].Cost
+					lot.RealizedPLInBase = lot.ProceedsInBase - lot.CostInBase
+				}
+				report.RealizedLots = append(report.RealizedLots, lot)
+			}
+
+			if fxMissing {
+				instrumentPnL(instrumentID, instrument)
+				continue
+			}
+			instrumentPnL(instrumentID, instrument).RealizedPL += baseSale.RealizedPL
+			report.RealizedPL += baseSale.RealizedPL
+			addPeriodPnL(periods, periodKey(sale, groupBy), baseSale)
+			addPeriodPnL(years, periodKey(sale, incomeByYear), baseSale)
+		}
+
+		for i, l := range h.Lots {
+			lot := models.UnrealizedLot{
+				LotID:        l.TradeID,
+				InstrumentID: instrumentID,
+				Symbol:       instrument.symbol,
+				Exchange:     instrument.exchange,
+				Currency:     instrument.currency,
+				AcquiredAt:   l.AcquiredAt,
+				Quantity:     l.Quantity,
+				Cost:         l.Cost,
+				FXMissing:    fxMissing,
+			}
+			if base != nil && i < len(base.Lots) {
+				lot.CostInBase = base.Lots[i].Cost
+			}
+			if instrument.lastPrice.Valid {
+				price := instrument.lastPrice.Float64
+				lot.LastPrice = &price
+				lot.MarketValue = l.Quantity * price
+				lot.UnrealizedPL = lot.MarketValue - lot.Cost
+				marketValueInBase, ok := replayed.toBase(lot.MarketValue, instrument)
+				if !ok {
+					lot.FXMissing = true
+				}
+				pnl := instrumentPnL(instrumentID, instrument)
+				if lot.FXMissing {
+					pnl.FXMissing = true
+				} else {
+					lot.MarketValueInBase = marketValueInBase
+					lot.UnrealizedPLInBase = lot.MarketValueInBase - lot.CostInBase
+					pnl.UnrealizedPL += lot.UnrealizedPLInBase
+					report.UnrealizedPL += lot.UnrealizedPLInBase
+				}
+			}
+			report.OpenLots = append(report.OpenLots, lot)
+		}
+	}
+	report.TotalPL = report.RealizedPL + report.UnrealizedPL
+
+	sort.Slice(report.RealizedLots, func(i, j int) bool {
+		a, b := report.RealizedLots[i], report.RealizedLots[j]
+		if !a.SoldAt.Equal(b.SoldAt) {
+			return a.SoldAt.Before(b.SoldAt)
+		}
+		if a.SaleID != b.SaleID {
+			return a.SaleID < b.SaleID
+		}
+		return a.AcquiredAt.Before(b.AcquiredAt)
+	})
+	sort.Slice(report.OpenLots, func(i, j int) bool {
+		a, b := report.OpenLots[i], report.OpenLots[j]
+		if !a.AcquiredAt.Equal(b.AcquiredAt) {
+			return a.AcquiredAt.Before(b.AcquiredAt)
+		}
+		return a.LotID < b.LotID
+	})
+	for _, pnl := range byInstrument {
+		pnl.TotalPL = pnl.RealizedPL + pnl.UnrealizedPL
+		report.Instruments = append(report.Instruments, *pnl)
+	}
+	sort.Slice(report.Instruments, func(i, j int) bool {
+		return report.Instruments[i].InstrumentID < report.Instruments[j].InstrumentID
+	})
+	report.Periods = sortedPeriodPnL(periods)
+	report.Years = sortedPeriodPnL(years)
+	report.MissingRates = replayed.missingRates()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetFees reports the fees and taxes the user paid per broker and year. The
+// from and to query parameters limit the trades reported.
+func GetFees(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	from, to, err := parseDateRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	settings, err := loadSettings(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, "failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	trades, err := loadTradeCosts(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	converter := refresher.NewConverter(r.Context(), db, userClaims.UserID, settings.BaseCurrency)
+	report := models.FeeReport{BaseCurrency: converter.Base, Groups: []models.FeeGroup{}}
+	groups := make(map[[2]string]*models.FeeGroup)
+	for _, t := range trades {
+		day := t.tradedAt.UTC().Format("2006-01-02")
+		if day < from || day > to {
+			continue
+		}
+		currency := quotes.NormalizeCurrency(t.currency)
+		if currency == "" {
+			currency = converter.Base
+		}
+		rate, ok := converter.Rate(currency, t.tradedAt)
+		if !ok {
+			continue
+		}
+
+		key := [2]string{t.account, t.tradedAt.UTC().Format("2006")}
+		group, ok := groups[key]
+		if !ok {
+			group = &models.FeeGroup{Broker: key[0], Year: key[1]}
+			groups[key] = group
+		}
+		group.Fees += t.fee * rate.Rate
+		group.Taxes += t.tax * rate.Rate
+		group.Volume += t.price * t.quantity * rate.Rate
+		group.Trades++
+	}
+
+	for _, group := range groups {
+		group.Total = group.Fees + group.Taxes
+		if group.Volume != 0 {
+			group.CostPercent = group.Total / group.Volume * 100
+		}
+		report.Fees += group.Fees
+		report.Taxes += group.Taxes
+		report.Volume += group.Volume
+		report.Groups = append(report.Groups, *group)
+	}
+	report.Total = report.Fees + report.Taxes
+	if report.Volume != 0 {
+		report.CostPercent = report.Total / report.Volume * 100
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		a, b := report.Groups[i], report.Groups[j]
+		if a.Broker != b.Broker {
+			return a.Broker < b.Broker
+		}
+		return a.Year < b.Year
+	})
+	report.MissingRates = converter.Missing()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+type tradeCosts struct {
+	account  string
+	currency string
+	price    float64
+	quantity float64
+	fee      float64
+	tax      float64
+	tradedAt time.Time
+}
+
+// loadTradeCosts reads all trades up front, since converting them may query
+// the database for FX rates.
+func loadTradeCosts(db *sql.DB, userID int) ([]tradeCosts, error) {
+	rows, err := db.Query(selectTradeCostsSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching trades: %v", err)
+	}
+	defer rows.Close()
+
+	var trades []tradeCosts
+	for rows.Next() {
+		var t tradeCosts
+		if err := rows.Scan(&t.account, &t.currency, &t.price, &t.quantity, &t.fee, &t.tax, &t.tradedAt); err != nil {
+			return nil, fmt.Errorf("error scanning trade: %v", err)
+		}
+		trades = append(trades, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching trades: %v", err)
+	}
+	return trades, nil
+}
+
+func periodKey(sale portfolio.Sale, groupBy string) string {
+	if groupBy == incomeByMonth {
+		return sale.SoldAt.UTC().Format("2006-01")
+	}
+	return sale.SoldAt.UTC().Format("2006")
+}
+
+func addPeriodPnL(periods map[string]*models.PeriodPnL, key string, sale portfolio.Sale) {
+	period, ok := periods[key]
+	if !ok {
+		period = &models.PeriodPnL{Key: key}
+		periods[key] = period
+	}
+	period.Proceeds += sale.Proceeds
+	period.Cost += sale.Cost
+	period.RealizedPL += sale.RealizedPL
+	period.Sales++
+}
+
+func sortedPeriodPnL(periods map[string]*models.PeriodPnL) []models.PeriodPnL {
+	sorted := make([]models.PeriodPnL, 0, len(periods))
+	for _, period := range periods {
+		sorted = append(sorted, *period)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
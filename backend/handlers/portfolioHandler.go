@@ -0,0 +1,193 @@
+// /backend/handlers/portfolioHandler.go
+
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"myinvestmap/models"
+	"myinvestmap/portfolio"
+	"myinvestmap/quotes"
+	"myinvestmap/refresher"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	selectPortfolioTradesSQL     = `SELECT a.id, a.instrument_id, COALESCE(a.currency, i.currency, ''), a.price, a.quantity, a.fee + a.tax, a.isPurchase, a.tradedAt FROM assets a JOIN instruments i ON i.id = a.instrument_id WHERE a.user_id = ?`
+	selectPortfolioInstrumentSQL = `SELECT COALESCE(currency, ''), lastPrice FROM instruments WHERE id = ?`
+)
+
+// GetPortfolioTotals reports the user's investment, market value, profit or
+// loss and total return including income in their base currency.
+func GetPortfolioTotals(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	settings, err := loadSettings(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, "failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	totals, err := portfolioTotals(r.Context(), db, userClaims.UserID, settings.BaseCurrency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(totals)
+}
+
+type portfolioTrade struct {
+	portfolio.Trade
+	currency string
+}
+
+func portfolioTotals(ctx context.Context, db *sql.DB, userID int, baseCurrency string) (models.PortfolioTotals, error) {
+	trades, err := loadPortfolioTrades(db, userID)
+	if err != nil {
+		return models.PortfolioTotals{}, err
+	}
+
+	ledger, err := loadIncome(db, userID, "0000-01-01", "9999-12-31")
+	if err != nil {
+		return models.PortfolioTotals{}, err
+	}
+	actions, err := loadCorporateActions(db, userID)
+	if err != nil {
+		return models.PortfolioTotals{}, err
+	}
+
+	converter := refresher.NewConverter(ctx, db, userID, baseCurrency)
+	byCurrency := make(map[string]*models.CurrencyTotal)
+	currencyTotal := func(currency string) *models.CurrencyTotal {
+		// Trades recorded before currencies were known count as base.
+		currency = quotes.NormalizeCurrency(currency)
+		if currency == "" {
+			currency = converter.Base
+		}
+		total, ok := byCurrency[currency]
+		if !ok {
+			total = &models.CurrencyTotal{Currency: currency}
+			byCurrency[currency] = total
+		}
+		return total
+	}
+
+	replayed := make([]portfolio.Trade, 0, len(trades))
+	for _, t := range trades {
+		// Fees and taxes add to what a purchase cost and reduce what a
+		// sale brought in.
+		cost := t.Price*t.Quantity + t.Costs
+		if !t.IsPurchase {
+			cost = t.Costs - t.Price*t.Quantity
+		}
+
+		total := currencyTotal(t.currency)
+		total.Investment += cost
+		if converted, ok := converter.Convert(cost, total.Currency, t.TradedAt); ok {
+			total.InvestmentInBase += converted
+		}
+		replayed = append(replayed, t.Trade)
+	}
+
+	// Holdings are valued in the shares held today, after splits and symbol
+	// changes, at the price of the instrument they are held in now. Their
+	// quantities do not depend on the cost basis method.
+	for instrumentID, h := range portfolio.Replay(replayed, toPortfolioActions(actions), portfolio.DefaultMethod) {
+		if h.Quantity == 0 {
+			continue
+		}
+		var (
+			currency  string
+			lastPrice sql.NullFloat64
+		)
+		if err := db.QueryRow(selectPortfolioInstrumentSQL, instrumentID).Scan(&currency, &lastPrice); err != nil {
+			return models.PortfolioTotals{}, fmt.Errorf("error fetching instrument: %v", err)
+		}
+		if !lastPrice.Valid {
+			continue
+		}
+		value := h.Quantity * lastPrice.Float64
+		total := currencyTotal(currency)
+		total.MarketValue += value
+		if converted, ok := converter.Convert(value, total.Currency, time.Time{}); ok {
+			total.MarketValueInBase += converted
+		}
+	}
+
+	for _, income := range ledger {
+		total := currencyTotal(income.Currency)
+		total.Income += income.NetAmount
+		if converted, ok := converter.Convert(income.NetAmount, total.Currency, income.PayDate.Time); ok {
+			total.IncomeInBase += converted
+		}
+	}
+
+	totals := models.PortfolioTotals{BaseCurrency: converter.Base, Currencies: []models.CurrencyTotal{}}
+	for _, total := range byCurrency {
+		if rate, ok := converter.Rate(total.Currency, time.Time{}); ok {
+			total.Rate = rate.Rate
+		}
+		totals.Investment += total.InvestmentInBase
+		totals.MarketValue += total.MarketValueInBase
+		totals.Income += total.IncomeInBase
+		totals.Currencies = append(totals.Currencies, *total)
+	}
+	sort.Slice(totals.Currencies, func(i, j int) bool {
+		return totals.Currencies[i].Currency < totals.Currencies[j].Currency
+	})
+
+	totals.ProfitLoss = totals.MarketValue - totals.Investment
+	if totals.Investment != 0 {
+		totals.ProfitLossPercent = totals.ProfitLoss / totals.Investment * 100
+	}
+	totals.TotalReturn = totals.ProfitLoss + totals.Income
+	if totals.Investment != 0 {
+		totals.TotalReturnPercent = totals.TotalReturn / totals.Investment * 100
+	}
+	totals.MissingRates = converter.Missing()
+	return totals, nil
+}
+
+// loadPortfolioTrades reads all trades up front, since converting them may
+// query the database for FX rates. Sales carry the lots they name.
+func loadPortfolioTrades(db queryer, userID int) ([]portfolioTrade, error) {
+	rows, err := db.Query(selectPortfolioTradesSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching trades: %v", err)
+	}
+	defer rows.Close()
+
+	var trades []portfolioTrade
+	for rows.Next() {
+		var t portfolioTrade
+		if err := rows.Scan(&t.ID, &t.InstrumentID, &t.currency, &t.Price, &t.Quantity, &t.Costs, &t.IsPurchase, &t.TradedAt); err != nil {
+			return nil, fmt.Errorf("error scanning trade: %v", err)
+		}
+		trades = append(trades, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching trades: %v", err)
+	}
+	rows.Close()
+
+	selections, err := loadLotSelections(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range trades {
+		for _, lot := range selections[trades[i].ID] {
+			trades[i].Lots = append(trades[i].Lots, portfolio.LotSelection{TradeID: lot.LotID, Quantity: lot.Quantity})
+		}
+	}
+	return trades, nil
+}
@@ -0,0 +1,318 @@
+// /backend/handlers/positionHandler.go
+
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"myinvestmap/models"
+	"myinvestmap/portfolio"
+	"myinvestmap/quotes"
+	"myinvestmap/refresher"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	selectPositionInstrumentSQL = `SELECT symbol, exchange, COALESCE(name, ''), type, COALESCE(currency, ''), lastPrice, lastPriceAt, COALESCE(lastPriceSource, ''), lastRefreshAt FROM instruments WHERE id = ?`
+)
+
+// GetPositions aggregates the user's transactions per instrument, so that
+// every client reports the same quantities, costs and P/L as the web UI.
+func GetPositions(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	settings, err := loadSettings(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, "failed to load settings", http.StatusInternalServerError)
+		return
+	}
+
+	summary, err := loadPositions(r.Context(), db, userClaims.UserID, settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+type positionInstrument struct {
+	symbol          string
+	exchange        string
+	name            string
+	assetType       string
+	currency        string
+	lastPrice       sql.NullFloat64
+	lastPriceAt     sql.NullTime
+	lastPriceSource string
+	lastRefreshAt   sql.NullTime
+}
+
+// positionInstruments reads instruments on first use; holdings may end up in
+// instruments the user never traded, such as spun-off shares.
+type positionInstruments struct {
+	db          *sql.DB
+	instruments map[int]*positionInstrument
+}
+
+func (p *positionInstruments) get(id int) (*positionInstrument, error) {
+	if i, ok := p.instruments[id]; ok {
+		return i, nil
+	}
+	var i positionInstrument
+	err := p.db.QueryRow(selectPositionInstrumentSQL, id).Scan(&i.symbol, &i.exchange, &i.name, &i.assetType, &i.currency, &i.lastPrice, &i.lastPriceAt, &i.lastPriceSource, &i.lastRefreshAt)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching instrument: %v", err)
+	}
+	i.currency = quotes.NormalizeCurrency(i.currency)
+	p.instruments[id] = &i
+	return &i, nil
+}
+
+// replayedPortfolio is the user's portfolio replayed twice: once with the
+// trade prices converted into the currency each instrument is quoted in at
+// the rate of the trade date, and once converted into the base currency,
+// which gives cost basis, proceeds and realized P/L in both. Both replays
+// open and match the same lots in the same order. A trade no FX rate is
+// known for keeps the amounts it was entered with, and the instruments it
+// ends up in are listed in fxMissing.
+type replayedPortfolio struct {
+	instruments  *positionInstruments
+	converter    *refresher.Converter
+	local        map[string]*refresher.Converter
+	holdings     map[int]*portfolio.Holding
+	baseHoldings map[int]*portfolio.Holding
+	fxMissing    map[int]bool
+}
+
+// replayPortfolio replays the user's trades and corporate actions, matching
+// sales to lots using the user's cost basis method.
+func replayPortfolio(ctx context.Context, db *sql.DB, userID int, settings models.Settings) (*replayedPortfolio, error) {
+	trades, err := loadPortfolioTrades(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := loadCorporateActions(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &replayedPortfolio{
+		instruments: &positionInstruments{db: db, instruments: make(map[int]*positionInstrument)},
+		converter:   refresher.NewConverter(ctx, db, userID, settings.BaseCurrency),
+		local:       make(map[string]*refresher.Converter),
+		fxMissing:   make(map[int]bool),
+	}
+
+	localTrades := make([]portfolio.Trade, 0, len(trades))
+	baseTrades := make([]portfolio.Trade, 0, len(trades))
+	for _, t := range trades {
+		instrument, err := p.instruments.get(t.InstrumentID)
+		if err != nil {
+			return nil, err
+		}
+		currency := quotes.NormalizeCurrency(t.currency)
+		if currency == "" {
+			currency = instrument.currency
+		}
+
+		localTrade := t.Trade
+		if instrument.currency != "" && currency != instrument.currency {
+			c, ok := p.local[instrument.currency]
+			if !ok {
+				c = refresher.NewConverter(ctx, db, userID, instrument.currency)
+				p.local[instrument.currency] = c
+			}
+			if !convertTrade(c, &localTrade, currency) {
+				p.fxMissing[t.InstrumentID] = true
+			}
+		}
+		localTrades = append(localTrades, localTrade)
+
+		baseTrade := t.Trade
+		if currency == "" {
+			currency = p.converter.Base
+		}
+		if !convertTrade(p.converter, &baseTrade, currency) {
+			p.fxMissing[t.InstrumentID] = true
+		}
+		baseTrades = append(baseTrades, baseTrade)
+	}
+
+	replayed := toPortfolioActions(actions)
+	// Symbol changes and spin-offs carry the unconverted amounts into other
+	// instruments; actions are ordered by date, so one pass follows chains.
+	for _, a := range replayed {
+		if p.fxMissing[a.InstrumentID] && a.NewInstrumentID != 0 {
+			p.fxMissing[a.NewInstrumentID] = true
+		}
+	}
+	p.holdings = portfolio.Replay(localTrades, replayed, settings.CostBasisMethod)
+	p.baseHoldings = portfolio.Replay(baseTrades, replayed, settings.CostBasisMethod)
+	return p, nil
+}
+
+// convertTrade converts the price and costs of a trade entered in currency
+// at the rate of its trade date. Without a rate the trade is left as it is.
+func convertTrade(c *refresher.Converter, t *portfolio.Trade, currency string) bool {
+	price, ok := c.Convert(t.Price, currency, t.TradedAt)
+	if !ok {
+		return false
+	}
+	costs, ok := c.Convert(t.Costs, currency, t.TradedAt)
+	if !ok {
+		return false
+	}
+	t.Price, t.Costs = price, costs
+	return true
+}
+
+// toBase converts an amount in the currency of an instrument into the base
+// currency at today's rate, reporting false when there is no rate.
+func (p *replayedPortfolio) toBase(amount float64, instrument *positionInstrument) (float64, bool) {
+	currency := instrument.currency
+	if currency == "" {
+		currency = p.converter.Base
+	}
+	return p.converter.Convert(amount, currency, time.Time{})
+}
+
+// missingRates lists the currencies any of the conversions had no rate for.
+func (p *replayedPortfolio) missingRates() []string {
+	missing := p.converter.Missing()
+	for _, c := range p.local {
+		for _, currency := range c.Missing() {
+			if !containsString(missing, currency) {
+				missing = append(missing, currency)
+			}
+		}
+	}
+	return missing
+}
+
+// loadPositions reports the open holdings of the replayed portfolio.
+func loadPositions(ctx context.Context, db *sql.DB, userID int, settings models.Settings) (models.PositionSummary, error) {
+	replayed, err := replayPortfolio(ctx, db, userID, settings)
+	if err != nil {
+		return models.PositionSummary{}, err
+	}
+	instruments, converter := replayed.instruments, replayed.converter
+	holdings, baseHoldings := replayed.holdings, replayed.baseHoldings
+
+	summary := models.PositionSummary{BaseCurrency: converter.Base, CostBasisMethod: settings.CostBasisMethod, Positions: []models.Position{}}
+	for instrumentID, h := range holdings {
+		if h.Quantity == 0 {
+			continue
+		}
+		instrument, err := instruments.get(instrumentID)
+		if err != nil {
+			return models.PositionSummary{}, err
+		}
+
+		p := models.Position{
+			InstrumentID: instrumentID,
+			AssetType:    instrument.assetType,
+			Symbol:       instrument.symbol,
+			Exchange:     instrument.exchange,
+			Name:         instrument.name,
+			Currency:     instrument.currency,
+			Quantity:     h.Quantity,
+			CostBasis:    h.Cost,
+			FXMissing:    replayed.fxMissing[instrumentID],
+		}
+		if instrument.assetType == models.InstrumentTypeCrypto {
+			p.Quantity = quotes.RoundCryptoQuantity(p.Quantity)
+		}
+		if p.Quantity > 0 {
+			p.AverageCost = h.Cost / h.Quantity
+		}
+		b := baseHoldings[instrumentID]
+		if b != nil {
+			p.CostBasisInBase = b.Cost
+		}
+		for i, l := range h.Lots {
+			lot := models.PositionLot{LotID: l.TradeID, AcquiredAt: l.AcquiredAt, Quantity: l.Quantity, Cost: l.Cost}
+			if l.Quantity > 0 {
+				lot.CostPerShare = l.Cost / l.Quantity
+			}
+			if b != nil && i < len(b.Lots) {
+				lot.CostInBase = b.Lots[i].Cost
+			}
+			p.Lots = append(p.Lots, lot)
+		}
+		if instrument.lastPriceAt.Valid {
+			p.PriceAsOf = &instrument.lastPriceAt.Time
+		}
+		refreshedAt := instrument.lastRefreshAt
+		if !refreshedAt.Valid {
+			refreshedAt = instrument.lastPriceAt
+		}
+		p.IsStale = refresher.IsStale(instrument.exchange, refreshedAt.Time)
+
+		if instrument.lastPrice.Valid {
+			price := instrument.lastPrice.Float64
+			p.LastPrice = &price
+			p.MarketValue = p.Quantity * price
+			marketValueInBase, ok := replayed.toBase(p.MarketValue, instrument)
+			if ok {
+				p.MarketValueInBase = marketValueInBase
+			} else {
+				p.FXMissing = true
+			}
+			if p.Quantity > 0 {
+				p.UnrealizedPL = p.MarketValue - p.CostBasis
+				if !p.FXMissing {
+					p.UnrealizedPLInBase = p.MarketValueInBase - p.CostBasisInBase
+				}
+				if p.CostBasis != 0 {
+					p.UnrealizedPLPercent = p.UnrealizedPL / p.CostBasis * 100
+				}
+			}
+
+			if !p.FXMissing {
+				summary.CostBasis += p.CostBasisInBase
+				summary.MarketValue += p.MarketValueInBase
+				summary.UnrealizedPL += p.UnrealizedPLInBase
+			}
+		}
+		summary.Positions = append(summary.Positions, p)
+	}
+
+	for i := range summary.Positions {
+		p := &summary.Positions[i]
+		if p.LastPrice != nil && !p.FXMissing && summary.MarketValue != 0 {
+			p.Weight = p.MarketValueInBase / summary.MarketValue * 100
+		}
+	}
+	sort.Slice(summary.Positions, func(i, j int) bool {
+		a, b := summary.Positions[i], summary.Positions[j]
+		if math.Abs(a.MarketValueInBase) != math.Abs(b.MarketValueInBase) {
+			return math.Abs(a.MarketValueInBase) > math.Abs(b.MarketValueInBase)
+		}
+		return a.InstrumentID < b.InstrumentID
+	})
+	if summary.CostBasis != 0 {
+		summary.UnrealizedPLPercent = summary.UnrealizedPL / summary.CostBasis * 100
+	}
+	summary.MissingRates = replayed.missingRates()
+	return summary, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,388 @@
+// /backend/handlers/priceStreamHandler.go
+
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"myinvestmap/events"
+	"myinvestmap/models"
+	"myinvestmap/portfolio"
+	"myinvestmap/quotes"
+	"myinvestmap/refresher"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	streamWriteWait  = 10 * time.Second
+	streamPongWait   = 60 * time.Second
+	streamPingPeriod = 50 * time.Second
+	streamMaxRequest = 4096
+)
+
+var streamUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// The token travels in the URL rather than in a cookie, so another
+	// origin cannot open a stream on the user's behalf.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+var (
+	streamsDone      = make(chan struct{})
+	closeStreamsOnce sync.Once
+)
+
+// CloseStreams ends every open price stream. http.Server.Shutdown does not
+// wait for hijacked connections, so main registers it with RegisterOnShutdown.
+func CloseStreams() {
+	closeStreamsOnce.Do(func() { close(streamsDone) })
+}
+
+// StreamPrices upgrades to a WebSocket that pushes the user's positions. The
+// client sends {"type": "subscribe"} to receive a snapshot followed by one
+// "quote" message per stored price. After reconnecting it sends the last
+// eventId it saw as "since" and gets the missed quotes replayed, or a new
+// snapshot when they are no longer kept. Subscribing again reloads the
+// positions; the stream also sends a new snapshot by itself whenever the
+// user's transactions change.
+func StreamPrices(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := streamUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an error.
+		return
+	}
+	defer conn.Close()
+
+	stream := &priceStream{ctx: r.Context(), db: db, userID: userClaims.UserID, conn: conn}
+	requests := make(chan models.StreamRequest)
+	readDone := make(chan struct{})
+	runDone := make(chan struct{})
+	go stream.readRequests(requests, readDone, runDone)
+	stream.run(requests, readDone)
+	close(runDone)
+}
+
+type priceStream struct {
+	ctx    context.Context
+	db     *sql.DB
+	userID int
+	conn   *websocket.Conn
+
+	mu        sync.Mutex
+	loading   bool
+	positions map[quotes.Symbol]*models.StreamPosition
+	// rates convert position currencies into baseCurrency at today's rate;
+	// they are looked up whenever the positions are loaded, so that quotes
+	// are converted without querying anything.
+	baseCurrency string
+	rates        map[string]float64
+	missingRates []string
+}
+
+func (s *priceStream) readRequests(requests chan<- models.StreamRequest, readDone chan<- struct{}, runDone <-chan struct{}) {
+	defer close(readDone)
+
+	s.conn.SetReadLimit(streamMaxRequest)
+	s.conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(streamPongWait))
+	})
+
+	for {
+		var req models.StreamRequest
+		if err := s.conn.ReadJSON(&req); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("Price stream for user %d closed: %v", s.userID, err)
+			}
+			return
+		}
+		select {
+		case requests <- req:
+		case <-runDone:
+			return
+		}
+	}
+}
+
+func (s *priceStream) run(requests <-chan models.StreamRequest, readDone <-chan struct{}) {
+	var (
+		sub     *events.Subscription
+		updates <-chan events.Event
+		last    uint64
+		err     error
+	)
+	defer func() {
+		if sub != nil {
+			sub.Close()
+		}
+	}()
+
+	ping := time.NewTicker(streamPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-streamsDone:
+			s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(streamWriteWait))
+			return
+		case <-readDone:
+			return
+		case req := <-requests:
+			if req.Type != "subscribe" {
+				err = s.send(models.StreamMessage{Type: "error", Message: fmt.Sprintf("unknown request type %q", req.Type)})
+				break
+			}
+			if sub == nil {
+				sub = events.Default.Subscribe(s.wants, events.DefaultBuffer)
+				updates = sub.C
+			}
+			last, err = s.subscribe(req.Since)
+		case e := <-updates:
+			if e.ID <= last {
+				continue
+			}
+			if e.ChangesTransactions() {
+				last, err = s.sendSnapshot()
+				break
+			}
+			last = e.ID
+			err = s.sendQuote(e)
+		case <-ping.C:
+			s.conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			err = s.conn.WriteMessage(websocket.PingMessage, nil)
+		}
+		if err != nil {
+			log.Printf("Price stream for user %d failed: %v", s.userID, err)
+			return
+		}
+
+		// The client could not keep up and missed quotes; the current
+		// positions supersede them.
+		if sub != nil && sub.Lagging() {
+			sub.Resync()
+			if last, err = s.sendSnapshot(); err != nil {
+				log.Printf("Price stream for user %d failed: %v", s.userID, err)
+				return
+			}
+		}
+	}
+}
+
+// subscribe (re)loads the positions and brings the client up to date, either
+// by replaying the quotes after since or with a snapshot. It returns the ID
+// of the last event the client has been sent.
+func (s *priceStream) subscribe(since *uint64) (uint64, error) {
+	if since == nil {
+		return s.sendSnapshot()
+	}
+
+	if err := s.loadPositions(); err != nil {
+		return 0, err
+	}
+	replay, ok := events.Default.Since(*since, s.wants)
+	if !ok {
+		return s.sendSnapshot()
+	}
+
+	last := *since
+	for _, e := range replay {
+		if err := s.sendQuote(e); err != nil {
+			return 0, err
+		}
+		last = e.ID
+	}
+	return last, nil
+}
+
+func (s *priceStream) sendSnapshot() (uint64, error) {
+	// Taken before loading so that quotes stored meanwhile are sent again
+	// rather than lost.
+	last := events.Default.LastID()
+	if err := s.loadPositions(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	msg := s.message("snapshot", last)
+	msg.Positions = []models.StreamPosition{}
+	for _, p := range s.positions {
+		msg.Positions = append(msg.Positions, *p)
+	}
+	s.mu.Unlock()
+	sort.Slice(msg.Positions, func(i, j int) bool {
+		if msg.Positions[i].Symbol != msg.Positions[j].Symbol {
+			return msg.Positions[i].Symbol < msg.Positions[j].Symbol
+		}
+		return msg.Positions[i].Exchange < msg.Positions[j].Exchange
+	})
+
+	return last, s.send(msg)
+}
+
+func (s *priceStream) sendQuote(e events.Event) error {
+	q, ok := e.Data.(events.Quote)
+	if !ok {
+		return nil
+	}
+
+	s.mu.Lock()
+	p, ok := s.positions[quotes.Symbol{Symbol: q.Symbol, Exchange: q.Exchange}]
+	if !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	price, priceAt := q.Price, q.PriceAt
+	p.Price, p.PriceAt, p.Source = &price, &priceAt, q.Source
+	s.value(p)
+	msg := s.message("quote", e.ID)
+	msg.Position = &models.StreamPosition{}
+	*msg.Position = *p
+	s.mu.Unlock()
+
+	return s.send(msg)
+}
+
+// value sets the value of p at its price, in its currency and in the base
+// currency. s.mu must be held.
+func (s *priceStream) value(p *models.StreamPosition) {
+	p.Value, p.ValueInBase, p.FXMissing = 0, 0, false
+	if p.Price == nil {
+		return
+	}
+	p.Value = p.Quantity * *p.Price
+	rate, ok := s.rates[p.Currency]
+	if !ok {
+		p.FXMissing = true
+		return
+	}
+	p.ValueInBase = p.Value * rate
+}
+
+// message starts a message with the total value of the positions in the base
+// currency. Positions without an FX rate are left out of it. s.mu must be
+// held.
+func (s *priceStream) message(messageType string, eventID uint64) models.StreamMessage {
+	msg := models.StreamMessage{Type: messageType, EventID: eventID, BaseCurrency: s.baseCurrency, MissingRates: s.missingRates}
+	for _, p := range s.positions {
+		msg.TotalValue += p.ValueInBase
+	}
+	return msg
+}
+
+func (s *priceStream) send(msg models.StreamMessage) error {
+	s.conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+	return s.conn.WriteJSON(msg)
+}
+
+// wants filters the bus down to the user's transaction changes and quotes of
+// instruments the user holds. While the positions are being reloaded every
+// quote is let through, so that none is lost in between; sendQuote drops
+// those that turn out not to be held.
+func (s *priceStream) wants(e events.Event) bool {
+	if e.ChangesTransactions() {
+		return e.UserID == s.userID
+	}
+	if e.Type != events.QuoteUpdated {
+		return false
+	}
+	q, ok := e.Data.(events.Quote)
+	if !ok {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loading {
+		return true
+	}
+	_, ok = s.positions[quotes.Symbol{Symbol: q.Symbol, Exchange: q.Exchange}]
+	return ok
+}
+
+func (s *priceStream) loadPositions() error {
+	s.mu.Lock()
+	s.loading = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.loading = false
+		s.mu.Unlock()
+	}()
+
+	trades, err := loadPortfolioTrades(s.db, s.userID)
+	if err != nil {
+		return err
+	}
+	actions, err := loadCorporateActions(s.db, s.userID)
+	if err != nil {
+		return err
+	}
+	replayed := make([]portfolio.Trade, 0, len(trades))
+	for _, t := range trades {
+		replayed = append(replayed, t.Trade)
+	}
+
+	// Quantities are those held today, after corporate actions.
+	instruments := &positionInstruments{db: s.db, instruments: make(map[int]*positionInstrument)}
+	positions := make(map[quotes.Symbol]*models.StreamPosition)
+	for instrumentID, h := range portfolio.Replay(replayed, toPortfolioActions(actions), portfolio.DefaultMethod) {
+		instrument, err := instruments.get(instrumentID)
+		if err != nil {
+			return err
+		}
+		p := models.StreamPosition{
+			InstrumentID: instrumentID,
+			Symbol:       instrument.symbol,
+			Exchange:     instrument.exchange,
+			Currency:     instrument.currency,
+			Quantity:     h.Quantity,
+			Source:       instrument.lastPriceSource,
+		}
+		if instrument.lastPrice.Valid {
+			price := instrument.lastPrice.Float64
+			p.Price = &price
+		}
+		if instrument.lastPriceAt.Valid {
+			p.PriceAt = &instrument.lastPriceAt.Time
+		}
+		positions[quotes.Symbol{Symbol: p.Symbol, Exchange: p.Exchange}] = &p
+	}
+
+	settings, err := loadSettings(s.db, s.userID)
+	if err != nil {
+		return fmt.Errorf("error loading settings: %v", err)
+	}
+	converter := refresher.NewConverter(s.ctx, s.db, s.userID, settings.BaseCurrency)
+	rates := make(map[string]float64)
+	for _, p := range positions {
+		if _, ok := rates[p.Currency]; ok {
+			continue
+		}
+		if rate, ok := converter.Rate(p.Currency, time.Time{}); ok {
+			rates[p.Currency] = rate.Rate
+		}
+	}
+
+	s.mu.Lock()
+	s.positions = positions
+	s.baseCurrency, s.rates, s.missingRates = converter.Base, rates, converter.Missing()
+	for _, p := range positions {
+		s.value(p)
+	}
+	s.mu.Unlock()
+	return nil
+}
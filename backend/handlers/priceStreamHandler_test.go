@@ -0,0 +1,45 @@
+// /backend/handlers/priceStreamHandler_test.go
+
+package handlers
+
+import (
+	"context"
+	"math"
+	"myinvestmap/quotes"
+	"reflect"
+	"testing"
+)
+
+func TestPriceStreamTotalsInBaseCurrency(t *testing.T) {
+	db, _ := newTestDB(t, quotes.Quote{Symbol: "EUR/USD", Exchange: quotes.FXExchange, Price: 1.1})
+	for _, instrument := range []struct {
+		symbol, exchange, currency string
+		price, quantity            float64
+	}{
+		{"SAP", "XETR", "EUR", 180, 10},
+		{"AAPL", "XNAS", "USD", 100, 1},
+		{"SHEL", "XLON", "GBX", 2550, 2},
+	} {
+		if _, err := db.Exec(`INSERT INTO instruments (symbol, exchange, currency, lastPrice) VALUES (?1, ?2, ?3, ?4) ON CONFLICT(symbol, exchange) DO UPDATE SET lastPrice = ?4`, instrument.symbol, instrument.exchange, instrument.currency, instrument.price); err != nil {
+			t.Fatalf("inserting instrument: %v", err)
+		}
+		if _, err := db.Exec(`INSERT INTO assets (user_id, instrument_id, stockTag, exchange, price, quantity, tradedAt) SELECT ?, id, symbol, exchange, 1, ?, '2025-01-10' FROM instruments WHERE symbol = ?`, testUserID, instrument.quantity, instrument.symbol); err != nil {
+			t.Fatalf("inserting asset: %v", err)
+		}
+	}
+
+	s := &priceStream{ctx: context.Background(), db: db, userID: testUserID}
+	if err := s.loadPositions(); err != nil {
+		t.Fatalf("loadPositions: %v", err)
+	}
+	msg := s.message("snapshot", 0)
+	if msg.BaseCurrency != "USD" || math.Abs(msg.TotalValue-2080) > 1e-9 {
+		t.Errorf("total = %v %s, want 2080 USD", msg.TotalValue, msg.BaseCurrency)
+	}
+	if !reflect.DeepEqual(msg.MissingRates, []string{"GBP"}) {
+		t.Errorf("missing rates = %v, want [GBP]", msg.MissingRates)
+	}
+	if p := s.positions[quotes.Symbol{Symbol: "SHEL", Exchange: "XLON"}]; !p.FXMissing || p.Value != 5100 || p.ValueInBase != 0 {
+		t.Errorf("SHEL = %+v, want a value of 5100 without FX rate", *p)
+	}
+}
@@ -0,0 +1,273 @@
+// /backend/handlers/providerHandler.go
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"myinvestmap/models"
+	"myinvestmap/quotes"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	selectProviderCredentialsSQL = `SELECT id, provider, api_key, is_primary, priority, createdAt, updatedAt FROM provider_credentials WHERE user_id = ? ORDER BY is_primary DESC, priority ASC, id ASC`
+	selectProviderCredentialSQL  = `SELECT id, provider, api_key, is_primary, priority, createdAt, updatedAt FROM provider_credentials WHERE id = ? AND user_id = ?`
+	countProviderCredentialsSQL  = `SELECT COUNT(*) FROM provider_credentials WHERE user_id = ?`
+	insertProviderCredentialSQL  = `INSERT INTO provider_credentials (user_id, provider, api_key, is_primary, priority) VALUES (?, ?, ?, ?, ?)`
+	updateProviderCredentialSQL  = `UPDATE provider_credentials SET provider = ?, api_key = ?, is_primary = ?, priority = ?, updatedAt = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?`
+	clearPrimaryCredentialSQL    = `UPDATE provider_credentials SET is_primary = false WHERE user_id = ? AND id != ?`
+	deleteProviderCredentialSQL  = `DELETE FROM provider_credentials WHERE id = ? AND user_id = ?`
+	promotePrimaryCredentialSQL  = `UPDATE provider_credentials SET is_primary = true WHERE id = (SELECT id FROM provider_credentials WHERE user_id = ? ORDER BY priority ASC, id ASC LIMIT 1)`
+)
+
+func ListProviders(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	credentials, err := loadProviderCredentials(db, userClaims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credentials)
+}
+
+func CreateProvider(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	var req models.ProviderCredentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var credential models.ProviderCredential
+	applyProviderCredentialRequest(&credential, req)
+	if err := validateProviderCredential(credential); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var count int
+	if err := db.QueryRow(countProviderCredentialsSQL, userClaims.UserID).Scan(&count); err != nil {
+		http.Error(w, "failed to count provider credentials", http.StatusInternalServerError)
+		return
+	}
+	if count == 0 {
+		credential.IsPrimary = true
+	}
+
+	id, err := saveProviderCredential(db, userClaims.UserID, credential)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := loadProviderCredential(db, id, userClaims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(saved)
+}
+
+func UpdateProvider(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid provider ID", http.StatusBadRequest)
+		return
+	}
+
+	var req models.ProviderCredentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	credential, err := loadProviderCredential(db, id, userClaims.UserID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	applyProviderCredentialRequest(&credential, req)
+	if err := validateProviderCredential(credential); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := saveProviderCredential(db, userClaims.UserID, credential); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := loadProviderCredential(db, id, userClaims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+func DeleteProvider(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid provider ID", http.StatusBadRequest)
+		return
+	}
+
+	credential, err := loadProviderCredential(db, id, userClaims.UserID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(deleteProviderCredentialSQL, id, userClaims.UserID); err != nil {
+		http.Error(w, "failed to execute SQL statement", http.StatusInternalServerError)
+		return
+	}
+	if credential.IsPrimary {
+		if _, err := tx.Exec(promotePrimaryCredentialSQL, userClaims.UserID); err != nil {
+			http.Error(w, "failed to promote fallback provider", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "failed to commit transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "Deleted")
+}
+
+func loadProviderCredentials(db *sql.DB, userID int) ([]models.ProviderCredential, error) {
+	rows, err := db.Query(selectProviderCredentialsSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching provider credentials: %v", err)
+	}
+	defer rows.Close()
+
+	credentials := []models.ProviderCredential{}
+	for rows.Next() {
+		var c models.ProviderCredential
+		if err := rows.Scan(&c.ID, &c.Provider, &c.APIKey, &c.IsPrimary, &c.Priority, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning provider credential: %v", err)
+		}
+		credentials = append(credentials, c)
+	}
+	return credentials, rows.Err()
+}
+
+func loadProviderCredential(db *sql.DB, id, userID int) (models.ProviderCredential, error) {
+	var c models.ProviderCredential
+	err := db.QueryRow(selectProviderCredentialSQL, id, userID).Scan(&c.ID, &c.Provider, &c.APIKey, &c.IsPrimary, &c.Priority, &c.CreatedAt, &c.UpdatedAt)
+	return c, err
+}
+
+// saveProviderCredential inserts the credential when it has no ID yet and
+// updates it otherwise. Making a credential primary demotes every other one.
+func saveProviderCredential(db *sql.DB, userID int, c models.ProviderCredential) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	id := c.ID
+	if id == 0 {
+		result, err := tx.Exec(insertProviderCredentialSQL, userID, c.Provider, c.APIKey, c.IsPrimary, c.Priority)
+		if err != nil {
+			return 0, fmt.Errorf("error saving provider credential: %v", err)
+		}
+		lastID, err := result.LastInsertId()
+		if err != nil {
+			return 0, fmt.Errorf("error saving provider credential: %v", err)
+		}
+		id = int(lastID)
+	} else if _, err := tx.Exec(updateProviderCredentialSQL, c.Provider, c.APIKey, c.IsPrimary, c.Priority, id, userID); err != nil {
+		return 0, fmt.Errorf("error saving provider credential: %v", err)
+	}
+
+	if c.IsPrimary {
+		if _, err := tx.Exec(clearPrimaryCredentialSQL, userID, id); err != nil {
+			return 0, fmt.Errorf("error updating primary provider: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error saving provider credential: %v", err)
+	}
+	return id, nil
+}
+
+func applyProviderCredentialRequest(c *models.ProviderCredential, req models.ProviderCredentialRequest) {
+	if req.Provider != nil {
+		c.Provider = *req.Provider
+	}
+	if req.APIKey != nil {
+		c.APIKey = *req.APIKey
+	}
+	if req.IsPrimary != nil {
+		c.IsPrimary = *req.IsPrimary
+	}
+	if req.Priority != nil {
+		c.Priority = *req.Priority
+	}
+}
+
+func validateProviderCredential(c models.ProviderCredential) error {
+	if !quotes.IsRegistered(c.Provider) {
+		return fmt.Errorf("unknown provider %q", c.Provider)
+	}
+	if c.APIKey == "" {
+		return fmt.Errorf("api_key is required")
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+// /backend/handlers/quoteCacheHandler.go
+
+package handlers
+
+import (
+	"encoding/json"
+	"myinvestmap/refresher"
+	"net/http"
+)
+
+func GetQuoteCacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(refresher.Cache.Stats())
+}
@@ -0,0 +1,113 @@
+// /backend/handlers/settingsHandler.go
+
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"myinvestmap/models"
+	"myinvestmap/portfolio"
+	"myinvestmap/quotes"
+	"net/http"
+	"strings"
+)
+
+const (
+	selectSettingsSQL = `SELECT baseCurrency, costBasisMethod, allowShortSelling FROM users WHERE id = ?`
+	updateSettingsSQL = `UPDATE users SET baseCurrency = ?, costBasisMethod = ?, allowShortSelling = ? WHERE id = ?`
+)
+
+func GetSettings(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	settings, err := loadSettings(db, userClaims.UserID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func UpdateSettings(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	var req models.SettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := loadSettings(db, userClaims.UserID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if req.BaseCurrency != nil {
+		settings.BaseCurrency = quotes.NormalizeCurrency(*req.BaseCurrency)
+	}
+	if req.CostBasisMethod != nil {
+		settings.CostBasisMethod = strings.ToLower(strings.TrimSpace(*req.CostBasisMethod))
+	}
+	if req.AllowShortSelling != nil {
+		settings.AllowShortSelling = *req.AllowShortSelling
+	}
+	if err := validateSettings(settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.Exec(updateSettingsSQL, settings.BaseCurrency, settings.CostBasisMethod, settings.AllowShortSelling, userClaims.UserID); err != nil {
+		http.Error(w, "failed to save settings", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+func loadSettings(db *sql.DB, userID int) (models.Settings, error) {
+	var settings models.Settings
+	err := db.QueryRow(selectSettingsSQL, userID).Scan(&settings.BaseCurrency, &settings.CostBasisMethod, &settings.AllowShortSelling)
+	if err == sql.ErrNoRows {
+		return settings, err
+	}
+	if err != nil {
+		return settings, fmt.Errorf("error fetching settings: %v", err)
+	}
+	return settings, nil
+}
+
+// validateSettings only accepts major currencies as the base currency, so
+// that totals are never reported in pence, and known cost basis methods.
+func validateSettings(settings models.Settings) error {
+	if !quotes.IsCurrencyCode(settings.BaseCurrency) {
+		return fmt.Errorf("invalid baseCurrency %q", settings.BaseCurrency)
+	}
+	if major, factor := quotes.MajorCurrency(settings.BaseCurrency); factor != 1 {
+		return fmt.Errorf("invalid baseCurrency %q, use %s", settings.BaseCurrency, major)
+	}
+	if !portfolio.IsMethod(settings.CostBasisMethod) {
+		return fmt.Errorf("invalid costBasisMethod %q, use %s, %s, %s or %s", settings.CostBasisMethod, portfolio.MethodFIFO, portfolio.MethodLIFO, portfolio.MethodAverage, portfolio.MethodSpecificLot)
+	}
+	return nil
+}
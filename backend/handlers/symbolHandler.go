@@ -0,0 +1,136 @@
+// /backend/handlers/symbolHandler.go
+
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"myinvestmap/models"
+	"myinvestmap/quotes"
+	"myinvestmap/refresher"
+	"net/http"
+	"strings"
+)
+
+func SearchSymbols(db *sql.DB, w http.ResponseWriter, r *http.Request) {
+	userClaims, ok := r.Context().Value("userClaims").(*models.Claims)
+	if !ok {
+		http.Error(w, "invalid user claims", http.StatusInternalServerError)
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	listings, err := refresher.SearchSymbols(r.Context(), db, query, userClaims.UserID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]models.SymbolListing, 0, len(listings))
+	for _, l := range listings {
+		response = append(response, models.SymbolListing{
+			Symbol:   l.Symbol,
+			Exchange: l.Exchange,
+			MIC:      l.MIC,
+			Name:     l.Name,
+			Currency: l.Currency,
+			Type:     l.Type,
+			Country:  l.Country,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// errSymbolUnchecked is returned by validateAssetSymbol when the listings
+// could not be checked, e.g. without an API key or credits left.
+var errSymbolUnchecked = errors.New("symbol could not be validated, try again later")
+
+// assetSymbolStatus is the status to answer a validateAssetSymbol error with.
+func assetSymbolStatus(err error) int {
+	if errors.Is(err, errSymbolUnchecked) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadRequest
+}
+
+// validateAssetSymbol rejects symbol and exchange combinations that no
+// provider lists, and adopts the symbol and exchange of the listing found.
+// Listings already in the catalog are checked without asking a provider;
+// others fail with errSymbolUnchecked while no provider can be asked. Crypto
+// pairs are only checked for their notation, as providers do not list them
+// the way they list securities. A trade without a currency takes the one the
+// listing is quoted in.
+func validateAssetSymbol(ctx context.Context, db *sql.DB, asset *models.Asset, userID int) error {
+	asset.StockTag = strings.TrimSpace(asset.StockTag)
+	asset.Exchange = strings.TrimSpace(asset.Exchange)
+	asset.Account = strings.TrimSpace(asset.Account)
+	asset.Currency = quotes.NormalizeCurrency(asset.Currency)
+	if asset.StockTag == "" {
+		return errors.New("stockTag is required")
+	}
+	if asset.Currency != "" && !quotes.IsCurrencyCode(asset.Currency) {
+		return fmt.Errorf("invalid currency %q", asset.Currency)
+	}
+
+	switch asset.AssetType {
+	case models.InstrumentTypeCrypto:
+		return normalizeCryptoAsset(asset)
+	case "", models.InstrumentTypeStock:
+		if (quotes.Symbol{Exchange: asset.Exchange}).IsCrypto() {
+			return normalizeCryptoAsset(asset)
+		}
+		asset.AssetType = models.InstrumentTypeStock
+	default:
+		return fmt.Errorf("unknown assetType %q", asset.AssetType)
+	}
+
+	// Stored the way ensureInstrument stores the instrument it refers to.
+	symbol := quotes.Symbol{Symbol: asset.StockTag, Exchange: asset.Exchange}.Normalize()
+	asset.StockTag, asset.Exchange = symbol.Symbol, symbol.Exchange
+	listing, err := refresher.ValidateSymbol(ctx, db, symbol, userID)
+	if errors.Is(err, refresher.ErrUnknownSymbol) {
+		return err
+	}
+	if err != nil {
+		log.Printf("Could not validate %s: %v", symbol, err)
+		return fmt.Errorf("%w: %v", errSymbolUnchecked, err)
+	}
+
+	canonical := quotes.Symbol{Symbol: listing.Symbol, Exchange: listing.Exchange}.Normalize()
+	asset.StockTag = canonical.Symbol
+	if canonical.Exchange != "" {
+		asset.Exchange = canonical.Exchange
+	}
+	if asset.Currency == "" {
+		asset.Currency = quotes.NormalizeCurrency(listing.Currency)
+	}
+	return nil
+}
+
+// normalizeCryptoAsset writes the pair as BASE/QUOTE on quotes.CryptoExchange
+// and rounds the quantity to the precision crypto is kept at.
+func normalizeCryptoAsset(asset *models.Asset) error {
+	base, quote, err := quotes.ParsePair(asset.StockTag)
+	if err != nil {
+		return err
+	}
+	asset.AssetType = models.InstrumentTypeCrypto
+	asset.StockTag = base + "/" + quote
+	asset.Exchange = quotes.CryptoExchange
+	asset.Quantity = quotes.RoundCryptoQuantity(asset.Quantity)
+	if asset.Currency == "" {
+		asset.Currency = quote
+	}
+	return nil
+}
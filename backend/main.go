@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"log"
+	"myinvestmap/calendar"
 	"myinvestmap/database"
 	"myinvestmap/handlers"
 	"myinvestmap/middleware"
+	"myinvestmap/quotes"
+	"myinvestmap/refresher"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
@@ -19,6 +28,50 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
+	if ttl := os.Getenv("QUOTE_CACHE_TTL"); ttl != "" {
+		duration, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("Invalid QUOTE_CACHE_TTL: %v", err)
+		}
+		refresher.Cache = quotes.NewCache(duration)
+	}
+
+	if staleAfter := os.Getenv("STALE_PRICE_AFTER"); staleAfter != "" {
+		duration, err := time.ParseDuration(staleAfter)
+		if err != nil {
+			log.Fatalf("Invalid STALE_PRICE_AFTER: %v", err)
+		}
+		refresher.StaleAfter = duration
+	}
+
+	if dir := os.Getenv("MARKET_CALENDAR_DIR"); dir != "" {
+		if err := calendar.Load(os.DirFS(dir)); err != nil {
+			log.Fatalf("Invalid MARKET_CALENDAR_DIR: %v", err)
+		}
+	}
+
+	if path := os.Getenv("OFFLINE_QUOTES_FILE"); path != "" {
+		offline := quotes.NewFile(path)
+		refresher.ProviderFor = func(db *sql.DB, userID int) (quotes.QuoteProvider, error) {
+			return offline, nil
+		}
+		log.Println("Serving quotes from", path)
+	}
+
+	if path := os.Getenv("FX_RATES_FILE"); path != "" {
+		refresher.FXOffline = quotes.NewFile(path)
+		log.Println("Falling back to FX rates from", path)
+	}
+
+	refreshInterval := refresher.DefaultInterval
+	if interval := os.Getenv("REFRESH_INTERVAL"); interval != "" {
+		duration, err := time.ParseDuration(interval)
+		if err != nil {
+			log.Fatalf("Invalid REFRESH_INTERVAL: %v", err)
+		}
+		refreshInterval = duration
+	}
+
 	r := mux.NewRouter()
 
 	api := r.PathPrefix("/api").Subrouter()
@@ -46,6 +99,54 @@ func main() {
 		handlers.SaveAPIKey(db, w, r)
 	}).Methods(http.MethodPost)
 
+	secureApi.HandleFunc("/providers", func(w http.ResponseWriter, r *http.Request) {
+		handlers.ListProviders(db, w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/providers", func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreateProvider(db, w, r)
+	}).Methods(http.MethodPost)
+
+	secureApi.HandleFunc("/providers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateProvider(db, w, r)
+	}).Methods(http.MethodPut)
+
+	secureApi.HandleFunc("/providers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteProvider(db, w, r)
+	}).Methods(http.MethodDelete)
+
+	secureApi.HandleFunc("/settings", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetSettings(db, w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/settings", func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateSettings(db, w, r)
+	}).Methods(http.MethodPut)
+
+	secureApi.HandleFunc("/quote-cache/stats", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetQuoteCacheStats(w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/symbols/search", func(w http.ResponseWriter, r *http.Request) {
+		handlers.SearchSymbols(db, w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/instruments/{symbol}/prices", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetInstrumentPrices(db, w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/instruments/{symbol}/candles", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetInstrumentCandles(db, w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/instruments/backfill", func(w http.ResponseWriter, r *http.Request) {
+		handlers.BackfillInstruments(db, w, r)
+	}).Methods(http.MethodPost)
+
+	secureApi.HandleFunc("/instruments/{symbol}/backfill", func(w http.ResponseWriter, r *http.Request) {
+		handlers.BackfillInstrument(db, w, r)
+	}).Methods(http.MethodPost)
+
 	secureApi.HandleFunc("/refresh-assets", func(w http.ResponseWriter, r *http.Request) {
 		handlers.UpdateSelectedAssets(db, w, r)
 	}).Methods(http.MethodPost)
@@ -70,6 +171,74 @@ func main() {
 		handlers.DeleteAsset(db, w, r)
 	}).Methods(http.MethodDelete)
 
+	secureApi.HandleFunc("/income", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetIncome(db, w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/income", func(w http.ResponseWriter, r *http.Request) {
+		handlers.AddIncome(db, w, r)
+	}).Methods(http.MethodPost)
+
+	secureApi.HandleFunc("/income/by-period", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetIncomeByPeriod(db, w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/income/by-instrument", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetIncomeByInstrument(db, w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/income/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateIncome(db, w, r)
+	}).Methods(http.MethodPut)
+
+	secureApi.HandleFunc("/income/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteIncome(db, w, r)
+	}).Methods(http.MethodDelete)
+
+	secureApi.HandleFunc("/corporate-actions", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetCorporateActions(db, w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/corporate-actions", func(w http.ResponseWriter, r *http.Request) {
+		handlers.AddCorporateAction(db, w, r)
+	}).Methods(http.MethodPost)
+
+	secureApi.HandleFunc("/corporate-actions/fetch", func(w http.ResponseWriter, r *http.Request) {
+		handlers.FetchCorporateActions(db, w, r)
+	}).Methods(http.MethodPost)
+
+	secureApi.HandleFunc("/corporate-actions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteCorporateAction(db, w, r)
+	}).Methods(http.MethodDelete)
+
+	secureApi.HandleFunc("/positions", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetPositions(db, w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/performance/pnl", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetPnL(db, w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/performance/fees", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetFees(db, w, r)
+	}).Methods(http.MethodGet)
+
+	secureApi.HandleFunc("/portfolio/totals", func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetPortfolioTotals(db, w, r)
+	}).Methods(http.MethodGet)
+
+	// Only the streams take the token as a query parameter.
+	streamApi := r.PathPrefix("/api").Subrouter()
+	streamApi.Use(middleware.StreamJWTMiddleware(db))
+
+	streamApi.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		handlers.StreamEvents(db, w, r)
+	}).Methods(http.MethodGet)
+
+	streamApi.HandleFunc("/ws/prices", func(w http.ResponseWriter, r *http.Request) {
+		handlers.StreamPrices(db, w, r)
+	}).Methods(http.MethodGet)
+
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://myinvestmap.local:3000"},
 		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
@@ -80,6 +249,29 @@ func main() {
 
 	handler := corsHandler.Handler(r)
 
+	scheduler := refresher.NewScheduler(db, refreshInterval)
+	scheduler.Start()
+
+	server := &http.Server{Addr: ":8080", Handler: handler}
+	server.RegisterOnShutdown(handlers.CloseStreams)
+	idle := make(chan struct{})
+	go func() {
+		defer close(idle)
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+
+		scheduler.Stop()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
 	log.Println("Server is running on http://myinvestmap.local:8080")
-	log.Fatal(http.ListenAndServe(":8080", handler))
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-idle
 }
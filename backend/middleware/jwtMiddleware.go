@@ -20,10 +20,24 @@ const (
 
 var jwtKey = []byte(os.Getenv("AUTH_SECRET_KEY"))
 
+// JWTMiddleware authenticates requests by the bearer token in their
+// Authorization header.
 func JWTMiddleware(db *sql.DB) func(http.Handler) http.Handler {
+	return authenticate(db, false)
+}
+
+// StreamJWTMiddleware authenticates WebSocket and EventSource routes. Browsers
+// cannot set headers when opening those, so the token may also be passed as
+// the token query parameter, which ends up in logs and browser history and is
+// therefore accepted on no other route.
+func StreamJWTMiddleware(db *sql.DB) func(http.Handler) http.Handler {
+	return authenticate(db, true)
+}
+
+func authenticate(db *sql.DB, allowQueryToken bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			tokenString := extractToken(r)
+			tokenString := extractToken(r, allowQueryToken)
 			if tokenString == "" {
 				unauthorized(w, "Authorization header is missing")
 				return
@@ -47,8 +61,11 @@ func JWTMiddleware(db *sql.DB) func(http.Handler) http.Handler {
 	}
 }
 
-func extractToken(r *http.Request) string {
+func extractToken(r *http.Request, allowQueryToken bool) string {
 	tokenString := r.Header.Get("Authorization")
+	if tokenString == "" && allowQueryToken {
+		return r.URL.Query().Get("token")
+	}
 	return strings.TrimPrefix(tokenString, "Bearer ")
 }
 
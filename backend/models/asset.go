@@ -7,17 +7,58 @@ import (
 	"time"
 )
 
+// Asset is a single buy or sell transaction. AssetType, Name, CurrentPrice
+// and the price freshness fields are read from the referenced instrument.
+// Currency is the one Price is in, the instrument's unless the trade says
+// otherwise. For crypto, StockTag holds the pair, e.g. BTC/USD, and Account
+// the wallet or exchange account the coins are held in. Adjustment is set
+// when corporate actions changed the shares the transaction stands for.
+// Lots are the purchases a sale disposes of under the specific lot method.
+// Fee is the commission and Tax any transaction tax such as stamp duty, both
+// in Currency and for the whole trade; Account names the broker they were
+// paid to.
 type Asset struct {
-	ID           int             `json:"id"`
-	StockTag     string          `json:"stockTag"`
-	Exchange     string          `json:"exchange"`
-	Name         sql.NullString  `json:"name"`
-	Price        float64         `json:"price"`
-	Quantity     float64         `json:"quantity"`
-	CurrentPrice sql.NullFloat64 `json:"currentPrice"`
-	IsPurchase   bool            `json:"isPurchase"`
-	CreatedAt    time.Time       `json:"createdAt"`
-	UpdatedAt    time.Time       `json:"updatedAt"`
+	ID             int              `json:"id"`
+	InstrumentID   int              `json:"instrumentId"`
+	AssetType      string           `json:"assetType"`
+	StockTag       string           `json:"stockTag"`
+	Exchange       string           `json:"exchange"`
+	Account        string           `json:"account,omitempty"`
+	Name           sql.NullString   `json:"name"`
+	Currency       string           `json:"currency"`
+	Price          float64          `json:"price"`
+	Quantity       float64          `json:"quantity"`
+	Fee            float64          `json:"fee"`
+	Tax            float64          `json:"tax"`
+	CurrentPrice   sql.NullFloat64  `json:"currentPrice"`
+	PriceAsOf      *time.Time       `json:"priceAsOf"`
+	PriceSource    string           `json:"priceSource,omitempty"`
+	IsStale        bool             `json:"isStale"`
+	RefreshError   string           `json:"refreshError,omitempty"`
+	RefreshErrorAt *time.Time       `json:"refreshErrorAt,omitempty"`
+	IsPurchase     bool             `json:"isPurchase"`
+	Lots           []LotSelection   `json:"lots,omitempty"`
+	Adjustment     *AssetAdjustment `json:"adjustment,omitempty"`
+	TradedAt       time.Time        `json:"tradedAt"`
+	CreatedAt      time.Time        `json:"createdAt"`
+	UpdatedAt      time.Time        `json:"updatedAt"`
+}
+
+// LotSelection names a purchase, by its asset ID, and how many of its
+// shares a sale disposes of.
+type LotSelection struct {
+	LotID    int     `json:"lotId"`
+	Quantity float64 `json:"quantity"`
+}
+
+// OversellError is returned instead of a sale of more than the user holds.
+// Available is how much of StockTag on Exchange could be sold instead.
+type OversellError struct {
+	Error     string  `json:"error"`
+	StockTag  string  `json:"stockTag"`
+	Exchange  string  `json:"exchange"`
+	Requested float64 `json:"requested"`
+	Available float64 `json:"available"`
 }
 
 type AssetResponce struct {
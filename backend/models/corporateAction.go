@@ -0,0 +1,51 @@
+// /backend/models/corporateAction.go
+
+package models
+
+import "time"
+
+// CorporateAction is a split, reverse split, symbol change or spin-off of an
+// instrument, effective from the open of EffectiveDate. It holds NewShares
+// for every OldShares held before, e.g. 4 for 1 in a 4-for-1 split or 1 for
+// 10 in a 1-for-10 reverse split. Symbol changes and spin-offs name the
+// instrument the shares become or are spun off into; a spin-off moves
+// CostFraction of the cost basis over to it. Actions fetched from a provider
+// apply to every user holding the instrument and have Source set to the
+// provider; the others only apply to the user who entered them.
+type CorporateAction struct {
+	ID              int       `json:"id"`
+	Type            string    `json:"type"`
+	InstrumentID    int       `json:"instrumentId"`
+	StockTag        string    `json:"stockTag"`
+	Exchange        string    `json:"exchange"`
+	EffectiveDate   Date      `json:"effectiveDate"`
+	NewShares       float64   `json:"newShares"`
+	OldShares       float64   `json:"oldShares"`
+	NewInstrumentID int       `json:"newInstrumentId,omitempty"`
+	NewStockTag     string    `json:"newStockTag,omitempty"`
+	NewExchange     string    `json:"newExchange,omitempty"`
+	CostFraction    float64   `json:"costFraction,omitempty"`
+	Source          string    `json:"source"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// AssetAdjustment restates a transaction in the shares held today after the
+// corporate actions listed in Actions. The recorded transaction is left as
+// entered. CurrentPrice is set when the shares became another instrument.
+type AssetAdjustment struct {
+	StockTag     string   `json:"stockTag"`
+	Exchange     string   `json:"exchange"`
+	Price        float64  `json:"price"`
+	Quantity     float64  `json:"quantity"`
+	CurrentPrice *float64 `json:"currentPrice,omitempty"`
+	Actions      []int    `json:"actions"`
+}
+
+// SplitFetchResult reports the splits a provider returned for one
+// instrument.
+type SplitFetchResult struct {
+	Symbol   string `json:"symbol"`
+	Exchange string `json:"exchange"`
+	Splits   int    `json:"splits"`
+	Error    string `json:"error,omitempty"`
+}
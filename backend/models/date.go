@@ -0,0 +1,46 @@
+// /backend/models/date.go
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Date is a calendar day, such as the pay date of a dividend. It is written
+// as a plain date and read from either a plain date or an RFC 3339
+// timestamp, the same forms the date query parameters accept.
+type Date struct {
+	time.Time
+}
+
+func (d Date) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.Format(dateLayout))
+}
+
+func (d *Date) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*d = Date{}
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("date must be a string: %v", err)
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		d.Time = t.UTC()
+		return nil
+	}
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return fmt.Errorf("invalid date %q, want YYYY-MM-DD", value)
+	}
+	d.Time = t
+	return nil
+}
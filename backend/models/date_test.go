@@ -0,0 +1,52 @@
+// /backend/models/date_test.go
+
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDateUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Time
+		wantErr bool
+	}{
+		{input: `"2024-03-15"`, want: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{input: `"2024-03-15T22:30:00-05:00"`, want: time.Date(2024, 3, 16, 3, 30, 0, 0, time.UTC)},
+		{input: `null`},
+		{input: `"15/03/2024"`, wantErr: true},
+		{input: `20240315`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		var d Date
+		err := json.Unmarshal([]byte(tt.input), &d)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Unmarshal(%s) error = %v, want error %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !d.Equal(tt.want) {
+			t.Errorf("Unmarshal(%s) = %v, want %v", tt.input, d.Time, tt.want)
+		}
+	}
+}
+
+func TestIncomeDatesRoundTrip(t *testing.T) {
+	var income Income
+	if err := json.Unmarshal([]byte(`{"payDate": "2024-03-15", "exDate": "2024-02-28"}`), &income); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	data, err := json.Marshal(struct {
+		ExDate  *Date `json:"exDate"`
+		PayDate Date  `json:"payDate"`
+	}{income.ExDate, income.PayDate})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if want := `{"exDate":"2024-02-28","payDate":"2024-03-15"}`; string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+}
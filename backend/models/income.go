@@ -0,0 +1,57 @@
+// /backend/models/income.go
+
+package models
+
+import "time"
+
+// Income types. Dividends are paid to whoever held the instrument on the day
+// before ExDate; interest has no ex-date.
+const (
+	IncomeTypeDividend = "dividend"
+	IncomeTypeInterest = "interest"
+)
+
+// Income is a dividend or interest payment received on an instrument.
+// GrossAmount is the total paid before WithholdingTax, both in Currency,
+// which defaults to the instrument's. NetAmount is what was received.
+type Income struct {
+	ID             int       `json:"id"`
+	InstrumentID   int       `json:"instrumentId"`
+	Type           string    `json:"type"`
+	StockTag       string    `json:"stockTag"`
+	Exchange       string    `json:"exchange"`
+	Account        string    `json:"account,omitempty"`
+	Currency       string    `json:"currency"`
+	GrossAmount    float64   `json:"grossAmount"`
+	WithholdingTax float64   `json:"withholdingTax"`
+	NetAmount      float64   `json:"netAmount"`
+	ExDate         *Date     `json:"exDate,omitempty"`
+	PayDate        Date      `json:"payDate"`
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// IncomeSummary totals the user's income in their base currency, each
+// payment converted at the FX rate of its pay date. Currencies without any
+// known rate are listed in MissingRates and left out.
+type IncomeSummary struct {
+	BaseCurrency   string        `json:"baseCurrency"`
+	GroupBy        string        `json:"groupBy"`
+	GrossAmount    float64       `json:"grossAmount"`
+	WithholdingTax float64       `json:"withholdingTax"`
+	NetAmount      float64       `json:"netAmount"`
+	Groups         []IncomeGroup `json:"groups"`
+	MissingRates   []string      `json:"missingRates,omitempty"`
+}
+
+// IncomeGroup is the income of one month (2024-03), year (2024) or
+// instrument. Symbol and Exchange are only set for instruments.
+type IncomeGroup struct {
+	Key            string  `json:"key"`
+	Symbol         string  `json:"symbol,omitempty"`
+	Exchange       string  `json:"exchange,omitempty"`
+	GrossAmount    float64 `json:"grossAmount"`
+	WithholdingTax float64 `json:"withholdingTax"`
+	NetAmount      float64 `json:"netAmount"`
+	Payments       int     `json:"payments"`
+}
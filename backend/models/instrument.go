@@ -0,0 +1,74 @@
+// /backend/models/instrument.go
+
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Instrument types. Stocks are listed securities identified by symbol and
+// exchange; crypto instruments are pairs such as BTC/USD on
+// quotes.CryptoExchange.
+const (
+	InstrumentTypeStock  = "stock"
+	InstrumentTypeCrypto = "crypto"
+)
+
+type Instrument struct {
+	ID          int             `json:"id"`
+	Symbol      string          `json:"symbol"`
+	Exchange    string          `json:"exchange"`
+	Name        sql.NullString  `json:"name"`
+	Currency    sql.NullString  `json:"currency"`
+	Type        string          `json:"type"`
+	ISIN        sql.NullString  `json:"isin"`
+	LastPrice   sql.NullFloat64 `json:"lastPrice"`
+	LastPriceAt sql.NullTime    `json:"lastPriceAt"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+type SymbolListing struct {
+	Symbol   string `json:"symbol"`
+	Exchange string `json:"exchange"`
+	MIC      string `json:"mic,omitempty"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+	Type     string `json:"type,omitempty"`
+	Country  string `json:"country,omitempty"`
+}
+
+type PricePoint struct {
+	Time   time.Time `json:"time"`
+	Price  float64   `json:"price"`
+	Source string    `json:"source"`
+}
+
+type PriceSeries struct {
+	Symbol   string       `json:"symbol"`
+	Exchange string       `json:"exchange"`
+	Interval string       `json:"interval"`
+	From     time.Time    `json:"from"`
+	To       time.Time    `json:"to"`
+	Prices   []PricePoint `json:"prices"`
+}
+
+type Candle struct {
+	Day    string  `json:"day"`
+	Open   float64 `json:"open"`
+	High   float64 `json:"high"`
+	Low    float64 `json:"low"`
+	Close  float64 `json:"close"`
+	Volume float64 `json:"volume"`
+	Source string  `json:"source"`
+}
+
+type BackfillResult struct {
+	Symbol   string `json:"symbol"`
+	Exchange string `json:"exchange"`
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Candles  int    `json:"candles"`
+	Error    string `json:"error,omitempty"`
+}
@@ -3,6 +3,8 @@
 package models
 
 import (
+	"time"
+
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
@@ -19,11 +21,50 @@ type Claims struct {
 }
 
 type APIKeyRequest struct {
-	APIKey string `json:"api_key"`
+	APIKey   string `json:"api_key"`
+	Provider string `json:"provider"`
 }
 
 type APIKeyResponse struct {
-	APIKey string `json:"api_key"`
+	APIKey   string `json:"api_key"`
+	Provider string `json:"provider"`
+}
+
+type ProviderCredential struct {
+	ID        int       `json:"id"`
+	Provider  string    `json:"provider"`
+	APIKey    string    `json:"api_key"`
+	IsPrimary bool      `json:"is_primary"`
+	Priority  int       `json:"priority"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ProviderCredentialRequest uses pointers so that an update can leave
+// fields it does not mention untouched.
+type ProviderCredentialRequest struct {
+	Provider  *string `json:"provider"`
+	APIKey    *string `json:"api_key"`
+	IsPrimary *bool   `json:"is_primary"`
+	Priority  *int    `json:"priority"`
+}
+
+// Settings are the user's portfolio preferences. CostBasisMethod decides
+// which purchases a sale is matched against: fifo, lifo, average or
+// specific_lot. Sales of more than the user holds are rejected unless
+// AllowShortSelling is set.
+type Settings struct {
+	BaseCurrency      string `json:"baseCurrency"`
+	CostBasisMethod   string `json:"costBasisMethod"`
+	AllowShortSelling bool   `json:"allowShortSelling"`
+}
+
+// SettingsRequest uses pointers so that an update can leave settings it does
+// not mention untouched.
+type SettingsRequest struct {
+	BaseCurrency      *string `json:"baseCurrency"`
+	CostBasisMethod   *string `json:"costBasisMethod"`
+	AllowShortSelling *bool   `json:"allowShortSelling"`
 }
 
 type TokenResponse struct {
@@ -35,18 +76,27 @@ type Credentials struct {
 	Password string `json:"password"`
 }
 
+// UpdateStockRequest lists the listings to refresh. Bare Symbols refresh
+// every exchange the user holds that symbol on.
 type UpdateStockRequest struct {
-	Symbols []string `json:"symbols"`
+	Symbols []string    `json:"symbols"`
+	Assets  []SymbolRef `json:"assets"`
+}
+
+type SymbolRef struct {
+	Symbol   string `json:"symbol"`
+	Exchange string `json:"exchange"`
 }
 
-type StockQuote struct {
-	Symbol string `json:"symbol"`
-	Name   string `json:"name"`
-	Price  string `json:"price"`
+type DeferredSymbol struct {
+	Symbol    string    `json:"symbol"`
+	Exchange  string    `json:"exchange"`
+	RefreshAt time.Time `json:"refreshAt"`
 }
 
-type StockQuoteApiResponce struct {
-	Symbol string `json:"symbol"`
-	Name   string `json:"name"`
-	Price  string `json:"close"`
+type UpdateStockResponse struct {
+	Status    string           `json:"status"`
+	Refreshed []SymbolRef      `json:"refreshed"`
+	Deferred  []DeferredSymbol `json:"deferred"`
+	Error     string           `json:"error,omitempty"`
 }
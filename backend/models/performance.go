@@ -0,0 +1,121 @@
+// /backend/models/performance.go
+
+package models
+
+import "time"
+
+// PnLReport separates the P/L the user has realized by selling from the P/L
+// still open in the lots they hold, both according to their cost basis
+// method. Totals are in the base currency: realized P/L at the FX rates of
+// the purchase and sale dates, unrealized at today's market value.
+// RealizedLots, Periods and Years only cover sales within the requested
+// range; the unrealized part is always as of now.
+type PnLReport struct {
+	BaseCurrency    string          `json:"baseCurrency"`
+	CostBasisMethod string          `json:"costBasisMethod"`
+	GroupBy         string          `json:"groupBy"`
+	RealizedPL      float64         `json:"realizedPL"`
+	UnrealizedPL    float64         `json:"unrealizedPL"`
+	TotalPL         float64         `json:"totalPL"`
+	RealizedLots    []RealizedLot   `json:"realizedLots"`
+	OpenLots        []UnrealizedLot `json:"openLots"`
+	Instruments     []InstrumentPnL `json:"instruments"`
+	Periods         []PeriodPnL     `json:"periods"`
+	Years           []PeriodPnL     `json:"years"`
+	MissingRates    []string        `json:"missingRates,omitempty"`
+}
+
+// RealizedLot is the part of a purchase, LotID, closed by a sale, SaleID.
+// Quantity is in the shares of the sale; Proceeds, Cost and RealizedPL are
+// in Currency, the one the instrument is quoted in. FXMissing marks lots of
+// instruments with trades no FX rate is known for; they count towards none
+// of the totals.
+type RealizedLot struct {
+	SaleID           int       `json:"saleId"`
+	LotID            int       `json:"lotId"`
+	InstrumentID     int       `json:"instrumentId"`
+	Symbol           string    `json:"symbol"`
+	Exchange         string    `json:"exchange"`
+	Currency         string    `json:"currency"`
+	AcquiredAt       time.Time `json:"acquiredAt"`
+	SoldAt           time.Time `json:"soldAt"`
+	Quantity         float64   `json:"quantity"`
+	Proceeds         float64   `json:"proceeds"`
+	Cost             float64   `json:"cost"`
+	RealizedPL       float64   `json:"realizedPL"`
+	ProceedsInBase   float64   `json:"proceedsInBase"`
+	CostInBase       float64   `json:"costInBase"`
+	RealizedPLInBase float64   `json:"realizedPLInBase"`
+	FXMissing        bool      `json:"fxMissing,omitempty"`
+}
+
+// UnrealizedLot is a purchase still held, valued at the instrument's last
+// price. Lots of instruments without a price have no market value and count
+// towards none of the unrealized totals, nor do lots marked FXMissing.
+type UnrealizedLot struct {
+	LotID              int       `json:"lotId"`
+	InstrumentID       int       `json:"instrumentId"`
+	Symbol             string    `json:"symbol"`
+	Exchange           string    `json:"exchange"`
+	Currency           string    `json:"currency"`
+	AcquiredAt         time.Time `json:"acquiredAt"`
+	Quantity           float64   `json:"quantity"`
+	Cost               float64   `json:"cost"`
+	LastPrice          *float64  `json:"lastPrice"`
+	MarketValue        float64   `json:"marketValue"`
+	UnrealizedPL       float64   `json:"unrealizedPL"`
+	CostInBase         float64   `json:"costInBase"`
+	MarketValueInBase  float64   `json:"marketValueInBase"`
+	UnrealizedPLInBase float64   `json:"unrealizedPLInBase"`
+	FXMissing          bool      `json:"fxMissing,omitempty"`
+}
+
+// InstrumentPnL totals the realized and unrealized P/L of one instrument in
+// the base currency. FXMissing marks instruments whose amounts could not all
+// be converted; those are left out of their P/L.
+type InstrumentPnL struct {
+	InstrumentID int     `json:"instrumentId"`
+	Symbol       string  `json:"symbol"`
+	Exchange     string  `json:"exchange"`
+	RealizedPL   float64 `json:"realizedPL"`
+	UnrealizedPL float64 `json:"unrealizedPL"`
+	TotalPL      float64 `json:"totalPL"`
+	FXMissing    bool    `json:"fxMissing,omitempty"`
+}
+
+// PeriodPnL totals the realized P/L of the sales in one month (2024-03) or
+// year (2024) in the base currency.
+type PeriodPnL struct {
+	Key        string  `json:"key"`
+	Proceeds   float64 `json:"proceeds"`
+	Cost       float64 `json:"cost"`
+	RealizedPL float64 `json:"realizedPL"`
+	Sales      int     `json:"sales"`
+}
+
+// FeeReport totals the fees and taxes the user paid on their trades in the
+// base currency, each converted at the FX rate of its trade date, per
+// broker and year. Trades without an account count towards the broker "".
+// CostPercent is the share of the traded volume paid in fees and taxes.
+type FeeReport struct {
+	BaseCurrency string     `json:"baseCurrency"`
+	Fees         float64    `json:"fees"`
+	Taxes        float64    `json:"taxes"`
+	Total        float64    `json:"total"`
+	Volume       float64    `json:"volume"`
+	CostPercent  float64    `json:"costPercent"`
+	Groups       []FeeGroup `json:"groups"`
+	MissingRates []string   `json:"missingRates,omitempty"`
+}
+
+// FeeGroup is what was paid to one broker in one year.
+type FeeGroup struct {
+	Broker      string  `json:"broker"`
+	Year        string  `json:"year"`
+	Fees        float64 `json:"fees"`
+	Taxes       float64 `json:"taxes"`
+	Total       float64 `json:"total"`
+	Volume      float64 `json:"volume"`
+	CostPercent float64 `json:"costPercent"`
+	Trades      int     `json:"trades"`
+}
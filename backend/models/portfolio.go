@@ -0,0 +1,35 @@
+// /backend/models/portfolio.go
+
+package models
+
+// PortfolioTotals sums the user's transactions in their base currency. The
+// investment converts every trade at the FX rate of its trade date, the
+// market value converts the current holdings at today's rate. Income is the
+// net dividends and interest received, converted at their pay dates, and
+// TotalReturn adds it to ProfitLoss. Currencies without any known rate are
+// listed in MissingRates and left out.
+type PortfolioTotals struct {
+	BaseCurrency       string          `json:"baseCurrency"`
+	Investment         float64         `json:"investment"`
+	MarketValue        float64         `json:"marketValue"`
+	ProfitLoss         float64         `json:"profitLoss"`
+	ProfitLossPercent  float64         `json:"profitLossPercent"`
+	Income             float64         `json:"income"`
+	TotalReturn        float64         `json:"totalReturn"`
+	TotalReturnPercent float64         `json:"totalReturnPercent"`
+	Currencies         []CurrencyTotal `json:"currencies"`
+	MissingRates       []string        `json:"missingRates,omitempty"`
+}
+
+// CurrencyTotal breaks the totals down by the currency amounts were paid or
+// are quoted in. Rate is today's rate into the base currency.
+type CurrencyTotal struct {
+	Currency          string  `json:"currency"`
+	Investment        float64 `json:"investment"`
+	MarketValue       float64 `json:"marketValue"`
+	Rate              float64 `json:"rate"`
+	InvestmentInBase  float64 `json:"investmentInBase"`
+	MarketValueInBase float64 `json:"marketValueInBase"`
+	Income            float64 `json:"income"`
+	IncomeInBase      float64 `json:"incomeInBase"`
+}
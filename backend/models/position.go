@@ -0,0 +1,66 @@
+// /backend/models/position.go
+
+package models
+
+import "time"
+
+// Position aggregates the user's transactions in one instrument, restated
+// for corporate actions. Quantity, AverageCost, CostBasis, MarketValue and
+// UnrealizedPL are in Currency, the one the instrument is quoted in; the
+// InBase fields convert them into the user's base currency, the cost basis
+// at the FX rates of the trade dates and the market value at today's.
+// Weight is the share of the portfolio's market value in percent. Short
+// positions carry no cost basis, so they report no unrealized P/L. Lots are
+// the purchases still held, oldest first, as left by the cost basis method.
+// FXMissing is set when a trade or the market value could not be converted
+// for lack of an FX rate; the figures then keep the amounts as entered and
+// the position counts towards none of the totals.
+type Position struct {
+	InstrumentID        int           `json:"instrumentId"`
+	AssetType           string        `json:"assetType"`
+	Symbol              string        `json:"symbol"`
+	Exchange            string        `json:"exchange"`
+	Name                string        `json:"name,omitempty"`
+	Currency            string        `json:"currency"`
+	Quantity            float64       `json:"quantity"`
+	AverageCost         float64       `json:"averageCost"`
+	CostBasis           float64       `json:"costBasis"`
+	LastPrice           *float64      `json:"lastPrice"`
+	PriceAsOf           *time.Time    `json:"priceAsOf"`
+	IsStale             bool          `json:"isStale"`
+	MarketValue         float64       `json:"marketValue"`
+	UnrealizedPL        float64       `json:"unrealizedPL"`
+	UnrealizedPLPercent float64       `json:"unrealizedPLPercent"`
+	CostBasisInBase     float64       `json:"costBasisInBase"`
+	MarketValueInBase   float64       `json:"marketValueInBase"`
+	UnrealizedPLInBase  float64       `json:"unrealizedPLInBase"`
+	Weight              float64       `json:"weight"`
+	FXMissing           bool          `json:"fxMissing,omitempty"`
+	Lots                []PositionLot `json:"lots,omitempty"`
+}
+
+// PositionLot is what remains of one purchase, identified by its asset ID.
+// Quantity is in today's shares; Cost and CostPerShare are in the
+// position's currency.
+type PositionLot struct {
+	LotID        int       `json:"lotId"`
+	AcquiredAt   time.Time `json:"acquiredAt"`
+	Quantity     float64   `json:"quantity"`
+	Cost         float64   `json:"cost"`
+	CostPerShare float64   `json:"costPerShare"`
+	CostInBase   float64   `json:"costInBase"`
+}
+
+// PositionSummary lists the user's open positions, largest first, with
+// their totals in the base currency. Positions without a price or an FX
+// rate count towards none of the totals and have no weight.
+type PositionSummary struct {
+	BaseCurrency        string     `json:"baseCurrency"`
+	CostBasisMethod     string     `json:"costBasisMethod"`
+	CostBasis           float64    `json:"costBasis"`
+	MarketValue         float64    `json:"marketValue"`
+	UnrealizedPL        float64    `json:"unrealizedPL"`
+	UnrealizedPLPercent float64    `json:"unrealizedPLPercent"`
+	Positions           []Position `json:"positions"`
+	MissingRates        []string   `json:"missingRates,omitempty"`
+}
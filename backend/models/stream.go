@@ -0,0 +1,44 @@
+// /backend/models/stream.go
+
+package models
+
+import "time"
+
+// StreamRequest is sent by price stream clients. Since is the last eventId
+// the client saw before reconnecting.
+type StreamRequest struct {
+	Type  string  `json:"type"`
+	Since *uint64 `json:"since"`
+}
+
+// StreamPosition is one holding at its last price. Value is in Currency and
+// ValueInBase in the message's BaseCurrency; FXMissing marks positions whose
+// currency has no FX rate.
+type StreamPosition struct {
+	InstrumentID int        `json:"instrumentId"`
+	Symbol       string     `json:"symbol"`
+	Exchange     string     `json:"exchange"`
+	Currency     string     `json:"currency"`
+	Quantity     float64    `json:"quantity"`
+	Price        *float64   `json:"price"`
+	PriceAt      *time.Time `json:"priceAt"`
+	Source       string     `json:"source,omitempty"`
+	Value        float64    `json:"value"`
+	ValueInBase  float64    `json:"valueInBase"`
+	FXMissing    bool       `json:"fxMissing,omitempty"`
+}
+
+// StreamMessage is sent to price stream clients. Snapshots carry every
+// position, quote updates only the one that changed. TotalValue is the value
+// of all positions in BaseCurrency; positions in a currency listed in
+// MissingRates have no FX rate and count towards none of it.
+type StreamMessage struct {
+	Type         string           `json:"type"`
+	EventID      uint64           `json:"eventId,omitempty"`
+	Positions    []StreamPosition `json:"positions,omitempty"`
+	Position     *StreamPosition  `json:"position,omitempty"`
+	BaseCurrency string           `json:"baseCurrency,omitempty"`
+	TotalValue   float64          `json:"totalValue"`
+	MissingRates []string         `json:"missingRates,omitempty"`
+	Message      string           `json:"message,omitempty"`
+}
@@ -0,0 +1,157 @@
+// /backend/portfolio/actions.go
+
+package portfolio
+
+import (
+	"sort"
+	"time"
+)
+
+// Corporate action types. A reverse split is a split with a Ratio below one.
+const (
+	ActionSplit        = "split"
+	ActionReverseSplit = "reverse_split"
+	ActionSymbolChange = "symbol_change"
+	ActionSpinOff      = "spin_off"
+)
+
+// Trade is a recorded buy or sell as the user entered it, in the shares of
+// the instrument at the time. Price is per share and Costs the fees and
+// taxes paid on the whole trade, which add to the cost basis of a purchase
+// and are deducted from the proceeds of a sale. Lots names the purchases a
+// sale disposes of under MethodSpecificLot.
+type Trade struct {
+	ID           int
+	InstrumentID int
+	IsPurchase   bool
+	Quantity     float64
+	Price        float64
+	Costs        float64
+	TradedAt     time.Time
+	Lots         []LotSelection
+}
+
+// Action is a corporate action taking effect at the open of EffectiveDate,
+// midnight UTC; trades from that moment on are in post-action shares.
+//
+// Ratio is the number of new shares per old share. Splits multiply the
+// holding by it. A symbol change moves the holding to NewInstrumentID,
+// converting it at Ratio for mergers that swap shares. A spin-off leaves the
+// holding in place and adds Ratio shares of NewInstrumentID per share held,
+// moving CostFraction of the cost basis over to them.
+type Action struct {
+	ID              int
+	Type            string
+	InstrumentID    int
+	EffectiveDate   time.Time
+	Ratio           float64
+	NewInstrumentID int
+	CostFraction    float64
+}
+
+// Adjusted is a trade restated in the shares held today. Factor is the
+// product of the split ratios applied to its quantity.
+type Adjusted struct {
+	Trade
+	Factor  float64
+	Actions []int
+}
+
+// AdjustTrade restates t in today's shares: every split or symbol change of
+// its instrument after the trade scales its quantity and price and moves it
+// to the instrument the shares became. Spin-offs after a purchase lower its
+// price by the cost basis moved to the spun-off shares. The value of the
+// trade, price times quantity, only changes through spin-offs.
+func AdjustTrade(t Trade, actions []Action) Adjusted {
+	adjusted := Adjusted{Trade: t, Factor: 1}
+	for _, a := range sortedActions(actions) {
+		if a.InstrumentID != adjusted.InstrumentID || !a.EffectiveDate.After(t.TradedAt) {
+			continue
+		}
+		switch a.Type {
+		case ActionSplit, ActionReverseSplit:
+			adjusted.scale(a.Ratio)
+		case ActionSymbolChange:
+			adjusted.scale(a.Ratio)
+			adjusted.InstrumentID = a.NewInstrumentID
+		case ActionSpinOff:
+			if !t.IsPurchase {
+				continue
+			}
+			adjusted.Price *= 1 - a.CostFraction
+		default:
+			continue
+		}
+		adjusted.Actions = append(adjusted.Actions, a.ID)
+	}
+	return adjusted
+}
+
+func (a *Adjusted) scale(ratio float64) {
+	if ratio <= 0 {
+		return
+	}
+	a.Quantity *= ratio
+	a.Price /= ratio
+	a.Factor *= ratio
+}
+
+func applyAction(a Action, holding func(int) *Holding, current func(int) int, renamed map[int]int) {
+	if a.Ratio <= 0 {
+		return
+	}
+	h := holding(current(a.InstrumentID))
+	switch a.Type {
+	case ActionSplit, ActionReverseSplit:
+		h.Quantity *= a.Ratio
+		for i := range h.Lots {
+			h.Lots[i].Quantity *= a.Ratio
+		}
+	case ActionSymbolChange:
+		if a.NewInstrumentID == 0 || a.NewInstrumentID == h.InstrumentID {
+			return
+		}
+		target := holding(current(a.NewInstrumentID))
+		if h.Quantity < 0 {
+			target.Quantity += h.Quantity * a.Ratio
+		}
+		for _, l := range h.Lots {
+			l.InstrumentID = target.InstrumentID
+			l.Quantity *= a.Ratio
+			target.buy(l)
+		}
+		h.Quantity, h.Cost, h.Lots = 0, 0, nil
+		renamed[h.InstrumentID] = target.InstrumentID
+	case ActionSpinOff:
+		if a.NewInstrumentID == 0 || h.Quantity <= 0 {
+			return
+		}
+		// Spun-off shares keep the acquisition date of the lot they came
+		// from, each taking its share of that lot's cost.
+		target := holding(current(a.NewInstrumentID))
+		for i := range h.Lots {
+			l := &h.Lots[i]
+			moved := l.Cost * a.CostFraction
+			target.buy(Lot{
+				TradeID:      l.TradeID,
+				InstrumentID: target.InstrumentID,
+				AcquiredAt:   l.AcquiredAt,
+				Quantity:     l.Quantity * a.Ratio,
+				Cost:         moved,
+			})
+			l.Cost -= moved
+			h.Cost -= moved
+		}
+	}
+}
+
+func sortedActions(actions []Action) []Action {
+	actions = append([]Action(nil), actions...)
+	sort.SliceStable(actions, func(i, j int) bool {
+		if !actions[i].EffectiveDate.Equal(actions[j].EffectiveDate) {
+			return actions[i].EffectiveDate.Before(actions[j].EffectiveDate)
+		}
+		return actions[i].ID < actions[j].ID
+	})
+	return actions
+}
@@ -0,0 +1,223 @@
+// /backend/portfolio/actions_test.go
+
+package portfolio
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestAdjustTrade(t *testing.T) {
+	buy := Trade{ID: 1, InstrumentID: 10, IsPurchase: true, Quantity: 10, Price: 400, TradedAt: day(2020, 1, 15)}
+	sell := Trade{ID: 2, InstrumentID: 10, Quantity: 10, Price: 400, TradedAt: day(2020, 1, 15)}
+
+	tests := []struct {
+		name        string
+		trade       Trade
+		actions     []Action
+		want        Adjusted
+		wantActions []int
+	}{
+		{
+			name:  "no actions",
+			trade: buy,
+			want:  Adjusted{Trade: buy, Factor: 1},
+		},
+		{
+			name:    "split after the trade",
+			trade:   buy,
+			actions: []Action{{ID: 1, Type: ActionSplit, InstrumentID: 10, EffectiveDate: day(2020, 8, 31), Ratio: 4}},
+			want:    Adjusted{Trade: Trade{ID: 1, InstrumentID: 10, IsPurchase: true, Quantity: 40, Price: 100, TradedAt: day(2020, 1, 15)}, Factor: 4, Actions: []int{1}},
+		},
+		{
+			name:  "split before the trade and of another instrument",
+			trade: buy,
+			actions: []Action{
+				{ID: 1, Type: ActionSplit, InstrumentID: 10, EffectiveDate: day(2019, 6, 1), Ratio: 2},
+				{ID: 2, Type: ActionSplit, InstrumentID: 11, EffectiveDate: day(2020, 8, 31), Ratio: 4},
+			},
+			want: Adjusted{Trade: buy, Factor: 1},
+		},
+		{
+			name:  "split effective on the trade day",
+			trade: buy,
+			actions: []Action{
+				{ID: 1, Type: ActionSplit, InstrumentID: 10, EffectiveDate: day(2020, 1, 15), Ratio: 2},
+			},
+			want: Adjusted{Trade: buy, Factor: 1},
+		},
+		{
+			name:  "split then reverse split",
+			trade: buy,
+			actions: []Action{
+				{ID: 2, Type: ActionReverseSplit, InstrumentID: 10, EffectiveDate: day(2022, 1, 3), Ratio: 0.1},
+				{ID: 1, Type: ActionSplit, InstrumentID: 10, EffectiveDate: day(2020, 8, 31), Ratio: 4},
+			},
+			want: Adjusted{Trade: Trade{ID: 1, InstrumentID: 10, IsPurchase: true, Quantity: 4, Price: 1000, TradedAt: day(2020, 1, 15)}, Factor: 0.4, Actions: []int{1, 2}},
+		},
+		{
+			name:  "symbol change moves the trade",
+			trade: buy,
+			actions: []Action{
+				{ID: 1, Type: ActionSymbolChange, InstrumentID: 10, EffectiveDate: day(2021, 3, 1), Ratio: 2, NewInstrumentID: 20},
+				{ID: 2, Type: ActionSplit, InstrumentID: 20, EffectiveDate: day(2021, 6, 1), Ratio: 3},
+			},
+			want: Adjusted{Trade: Trade{ID: 1, InstrumentID: 20, IsPurchase: true, Quantity: 60, Price: 400.0 / 6, TradedAt: day(2020, 1, 15)}, Factor: 6, Actions: []int{1, 2}},
+		},
+		{
+			name:    "spin-off lowers a purchase price",
+			trade:   buy,
+			actions: []Action{{ID: 1, Type: ActionSpinOff, InstrumentID: 10, EffectiveDate: day(2021, 3, 1), Ratio: 0.5, NewInstrumentID: 30, CostFraction: 0.25}},
+			want:    Adjusted{Trade: Trade{ID: 1, InstrumentID: 10, IsPurchase: true, Quantity: 10, Price: 300, TradedAt: day(2020, 1, 15)}, Factor: 1, Actions: []int{1}},
+		},
+		{
+			name:    "spin-off leaves a sale alone",
+			trade:   sell,
+			actions: []Action{{ID: 1, Type: ActionSpinOff, InstrumentID: 10, EffectiveDate: day(2021, 3, 1), Ratio: 0.5, NewInstrumentID: 30, CostFraction: 0.25}},
+			want:    Adjusted{Trade: sell, Factor: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := AdjustTrade(tt.trade, tt.actions)
+			if got.InstrumentID != tt.want.InstrumentID || !almostEqual(got.Quantity, tt.want.Quantity) || !almostEqual(got.Price, tt.want.Price) || !almostEqual(got.Factor, tt.want.Factor) {
+				t.Errorf("AdjustTrade = %+v, want %+v", got, tt.want)
+			}
+			if !reflect.DeepEqual(got.Actions, tt.want.Actions) {
+				t.Errorf("actions = %v, want %v", got.Actions, tt.want.Actions)
+			}
+		})
+	}
+}
+
+func TestReplaySplits(t *testing.T) {
+	tests := []struct {
+		name         string
+		trades       []Trade
+		actions      []Action
+		wantQuantity float64
+		wantCost     float64
+		wantLots     []Lot
+		wantSales    []Sale
+	}{
+		{
+			name: "split between buy and sell",
+			trades: []Trade{
+				{ID: 1, InstrumentID: 10, IsPurchase: true, Quantity: 10, Price: 400, TradedAt: day(2020, 1, 15)},
+				{ID: 2, InstrumentID: 10, Quantity: 20, Price: 120, TradedAt: day(2020, 9, 15)},
+			},
+			actions:      []Action{{ID: 1, Type: ActionSplit, InstrumentID: 10, EffectiveDate: day(2020, 8, 31), Ratio: 4}},
+			wantQuantity: 20,
+			wantCost:     2000,
+			wantLots:     []Lot{{TradeID: 1, InstrumentID: 10, AcquiredAt: day(2020, 1, 15), Quantity: 20, Cost: 2000}},
+			wantSales: []Sale{{
+				TradeID: 2, InstrumentID: 10, SoldAt: day(2020, 9, 15), Quantity: 20,
+				Proceeds: 2400, Cost: 2000, RealizedPL: 400,
+				Matches: []Match{{TradeID: 1, AcquiredAt: day(2020, 1, 15), Quantity: 20, Cost: 2000, Proceeds: 2400}},
+			}},
+		},
+		{
+			name: "sale on the split day is in new shares",
+			trades: []Trade{
+				{ID: 1, InstrumentID: 10, IsPurchase: true, Quantity: 10, Price: 400, TradedAt: day(2020, 1, 15)},
+				{ID: 2, InstrumentID: 10, Quantity: 40, Price: 110, TradedAt: day(2020, 8, 31).Add(15 * time.Hour)},
+			},
+			actions:      []Action{{ID: 1, Type: ActionSplit, InstrumentID: 10, EffectiveDate: day(2020, 8, 31), Ratio: 4}},
+			wantQuantity: 0,
+			wantSales: []Sale{{
+				TradeID: 2, InstrumentID: 10, SoldAt: day(2020, 8, 31).Add(15 * time.Hour), Quantity: 40,
+				Proceeds: 4400, Cost: 4000, RealizedPL: 400,
+				Matches: []Match{{TradeID: 1, AcquiredAt: day(2020, 1, 15), Quantity: 40, Cost: 4000, Proceeds: 4400}},
+			}},
+		},
+		{
+			name: "reverse split leaves a fractional share",
+			trades: []Trade{
+				{ID: 1, InstrumentID: 10, IsPurchase: true, Quantity: 15, Price: 2, TradedAt: day(2021, 2, 1)},
+			},
+			actions:      []Action{{ID: 1, Type: ActionReverseSplit, InstrumentID: 10, EffectiveDate: day(2022, 5, 2), Ratio: 0.1}},
+			wantQuantity: 1.5,
+			wantCost:     30,
+			wantLots:     []Lot{{TradeID: 1, InstrumentID: 10, AcquiredAt: day(2021, 2, 1), Quantity: 1.5, Cost: 30}},
+		},
+		{
+			name: "selling the rounded remainder of a reverse split closes the lot",
+			trades: []Trade{
+				{ID: 1, InstrumentID: 10, IsPurchase: true, Quantity: 10, Price: 3, TradedAt: day(2021, 2, 1)},
+				{ID: 2, InstrumentID: 10, Quantity: 3.3333333333, Price: 12, TradedAt: day(2022, 6, 1)},
+			},
+			actions:      []Action{{ID: 1, Type: ActionReverseSplit, InstrumentID: 10, EffectiveDate: day(2022, 5, 2), Ratio: 1.0 / 3}},
+			wantQuantity: 0,
+			wantSales: []Sale{{
+				TradeID: 2, InstrumentID: 10, SoldAt: day(2022, 6, 1), Quantity: 3.3333333333,
+				Proceeds: 40, Cost: 30, RealizedPL: 10,
+				Matches: []Match{{TradeID: 1, AcquiredAt: day(2021, 2, 1), Quantity: 3.3333333333, Cost: 30, Proceeds: 40}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Replay(tt.trades, tt.actions, MethodFIFO)[10]
+			if h == nil {
+				t.Fatal("no holding for the instrument")
+			}
+			if !almostEqual(h.Quantity, tt.wantQuantity) || !almostEqual(h.Cost, tt.wantCost) {
+				t.Errorf("holding = %v shares at %v, want %v at %v", h.Quantity, h.Cost, tt.wantQuantity, tt.wantCost)
+			}
+			assertLots(t, h.Lots, tt.wantLots)
+			assertSales(t, h.Sales, tt.wantSales)
+		})
+	}
+}
+
+func assertLots(t *testing.T, got, want []Lot) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("lots = %+v, want %+v", got, want)
+		return
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.TradeID != w.TradeID || g.InstrumentID != w.InstrumentID || !g.AcquiredAt.Equal(w.AcquiredAt) || !almostEqual(g.Quantity, w.Quantity) || !almostEqual(g.Cost, w.Cost) {
+			t.Errorf("lot %d = %+v, want %+v", i, g, w)
+		}
+	}
+}
+
+func assertSales(t *testing.T, got, want []Sale) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("sales = %+v, want %+v", got, want)
+		return
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.TradeID != w.TradeID || g.InstrumentID != w.InstrumentID || !g.SoldAt.Equal(w.SoldAt) || !almostEqual(g.Quantity, w.Quantity) || !almostEqual(g.Short, w.Short) ||
+			!almostEqual(g.Proceeds, w.Proceeds) || !almostEqual(g.Cost, w.Cost) || !almostEqual(g.RealizedPL, w.RealizedPL) {
+			t.Errorf("sale %d = %+v, want %+v", i, g, w)
+			continue
+		}
+		if len(g.Matches) != len(w.Matches) {
+			t.Errorf("sale %d matches = %+v, want %+v", i, g.Matches, w.Matches)
+			continue
+		}
+		for j := range w.Matches {
+			gm, wm := g.Matches[j], w.Matches[j]
+			if gm.TradeID != wm.TradeID || !gm.AcquiredAt.Equal(wm.AcquiredAt) || !almostEqual(gm.Quantity, wm.Quantity) || !almostEqual(gm.Cost, wm.Cost) || !almostEqual(gm.Proceeds, wm.Proceeds) {
+				t.Errorf("sale %d match %d = %+v, want %+v", i, j, gm, wm)
+			}
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// /backend/portfolio/lots.go
+
+package portfolio
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Cost basis methods, deciding which lots a sale disposes of. FIFO sells
+// the oldest shares first and LIFO the newest. MethodAverage sells from every
+// lot in proportion, so that each share sold carries the average cost.
+// MethodSpecificLot sells the lots the sale names and falls back to FIFO for
+// any shares it leaves unnamed.
+const (
+	MethodFIFO        = "fifo"
+	MethodLIFO        = "lifo"
+	MethodAverage     = "average"
+	MethodSpecificLot = "specific_lot"
+)
+
+// DefaultMethod is used for users who never chose one.
+const DefaultMethod = MethodAverage
+
+// lotEpsilon is the quantity below which a lot counts as sold out, so that
+// rounding left over from splits and proportional sales does not linger as
+// lots of a billionth of a share.
+const lotEpsilon = 1e-9
+
+// IsMethod reports whether method is a known cost basis method.
+func IsMethod(method string) bool {
+	switch method {
+	case MethodFIFO, MethodLIFO, MethodAverage, MethodSpecificLot:
+		return true
+	}
+	return false
+}
+
+// Lot is what remains of one purchase. TradeID is the purchase; shares
+// received in a spin-off keep the purchase and acquisition date of the lot
+// they were spun off from. Quantity is in today's shares and Cost is the
+// cost basis of all of them.
+type Lot struct {
+	TradeID      int
+	InstrumentID int
+	AcquiredAt   time.Time
+	Quantity     float64
+	Cost         float64
+}
+
+// LotSelection names a purchase and the number of its shares a sale
+// disposes of, in the shares at the time of the sale.
+type LotSelection struct {
+	TradeID  int
+	Quantity float64
+}
+
+// Match is the part of a lot disposed of by a sale, and what it sold for.
+type Match struct {
+	TradeID    int
+	AcquiredAt time.Time
+	Quantity   float64
+	Cost       float64
+	Proceeds   float64
+}
+
+// Sale is a sell trade matched against the lots held at the time. Short is
+// the quantity sold beyond the holding, which matches no lot; Proceeds, net
+// of the costs of the sale, and RealizedPL only cover the matched shares.
+type Sale struct {
+	TradeID      int
+	InstrumentID int
+	SoldAt       time.Time
+	Quantity     float64
+	Short        float64
+	Proceeds     float64
+	Cost         float64
+	RealizedPL   float64
+	Matches      []Match
+}
+
+// Holding is what the user holds of one instrument after replaying their
+// trades and the corporate actions. Lots are the open purchases, oldest
+// first, and Cost their combined cost basis in the currency the trades were
+// made in. Sales are the sell trades made while the shares were held in this
+// instrument. A negative Quantity is a short position, which has no lots.
+type Holding struct {
+	InstrumentID int
+	Quantity     float64
+	Cost         float64
+	Lots         []Lot
+	Sales        []Sale
+}
+
+// Replay applies the trades and corporate actions in the order they happened
+// and returns the resulting holdings by instrument, including instruments
+// sold down to zero. Actions take effect before trades on the same day.
+// Sales are matched to lots using method, DefaultMethod when empty.
+func Replay(trades []Trade, actions []Action, method string) map[int]*Holding {
+	if !IsMethod(method) {
+		method = DefaultMethod
+	}
+	trades = append([]Trade(nil), trades...)
+	sort.SliceStable(trades, func(i, j int) bool {
+		if !trades[i].TradedAt.Equal(trades[j].TradedAt) {
+			return trades[i].TradedAt.Before(trades[j].TradedAt)
+		}
+		return trades[i].ID < trades[j].ID
+	})
+	actions = sortedActions(actions)
+
+	holdings := make(map[int]*Holding)
+	holding := func(instrumentID int) *Holding {
+		h, ok := holdings[instrumentID]
+		if !ok {
+			h = &Holding{InstrumentID: instrumentID}
+			holdings[instrumentID] = h
+		}
+		return h
+	}
+	// Trades recorded on an instrument after it changed symbol belong to the
+	// instrument it became.
+	renamed := make(map[int]int)
+	current := func(instrumentID int) int {
+		for {
+			next, ok := renamed[instrumentID]
+			if !ok {
+				return instrumentID
+			}
+			instrumentID = next
+		}
+	}
+
+	next := 0
+	for _, t := range trades {
+		for ; next < len(actions) && !actions[next].EffectiveDate.After(t.TradedAt); next++ {
+			applyAction(actions[next], holding, current, renamed)
+		}
+		h := holding(current(t.InstrumentID))
+		if t.IsPurchase {
+			h.buy(Lot{
+				TradeID:      t.ID,
+				InstrumentID: h.InstrumentID,
+				AcquiredAt:   t.TradedAt,
+				Quantity:     t.Quantity,
+				Cost:         t.Quantity*t.Price + t.Costs,
+			})
+		} else {
+			h.sell(t, method)
+		}
+	}
+	for ; next < len(actions); next++ {
+		applyAction(actions[next], holding, current, renamed)
+	}
+	return holdings
+}
+
+// buy adds l to the holding, keeping the lots in order of acquisition.
+func (h *Holding) buy(l Lot) {
+	if h.Quantity < 0 {
+		// Covering a short first; only the shares beyond it carry cost.
+		covered := l.Quantity
+		if covered > -h.Quantity {
+			covered = -h.Quantity
+		}
+		h.Quantity += covered
+		if l.Quantity == 0 {
+			return
+		}
+		l.Cost *= (l.Quantity - covered) / l.Quantity
+		l.Quantity -= covered
+	}
+	if l.Quantity <= lotEpsilon {
+		return
+	}
+	h.Quantity += l.Quantity
+	h.Cost += l.Cost
+
+	i := sort.Search(len(h.Lots), func(i int) bool {
+		if !h.Lots[i].AcquiredAt.Equal(l.AcquiredAt) {
+			return h.Lots[i].AcquiredAt.After(l.AcquiredAt)
+		}
+		return h.Lots[i].TradeID > l.TradeID
+	})
+	h.Lots = append(h.Lots, Lot{})
+	copy(h.Lots[i+1:], h.Lots[i:])
+	h.Lots[i] = l
+}
+
+// sell matches t against the open lots and records the sale.
+func (h *Holding) sell(t Trade, method string) {
+	sale := Sale{TradeID: t.ID, InstrumentID: h.InstrumentID, SoldAt: t.TradedAt, Quantity: t.Quantity}
+
+	matched := 0.0
+	if h.Quantity > 0 {
+		matched = t.Quantity
+		if matched > h.Quantity {
+			matched = h.Quantity
+		}
+	}
+	if matched > 0 {
+		switch method {
+		case MethodAverage:
+			fraction := matched / h.Quantity
+			for i := range h.Lots {
+				h.take(&sale, i, h.Lots[i].Quantity*fraction)
+			}
+		case MethodLIFO:
+			remaining := matched
+			for i := len(h.Lots) - 1; i >= 0 && remaining > lotEpsilon; i-- {
+				remaining -= h.take(&sale, i, remaining)
+			}
+		case MethodSpecificLot:
+			remaining := matched
+			for _, s := range t.Lots {
+				for i := range h.Lots {
+					if h.Lots[i].TradeID != s.TradeID || remaining <= lotEpsilon {
+						continue
+					}
+					want := s.Quantity
+					if want > remaining {
+						want = remaining
+					}
+					taken := h.take(&sale, i, want)
+					remaining -= taken
+					s.Quantity -= taken
+				}
+			}
+			h.takeFIFO(&sale, remaining)
+		default:
+			h.takeFIFO(&sale, matched)
+		}
+		// The costs of the sale are shared by every share sold, including
+		// any sold short.
+		net := t.Price - t.Costs/t.Quantity
+		for i := range sale.Matches {
+			sale.Matches[i].Proceeds = sale.Matches[i].Quantity * net
+		}
+		sale.Proceeds = matched * net
+		sale.RealizedPL = sale.Proceeds - sale.Cost
+	}
+	if sale.Short = t.Quantity - matched; sale.Short <= lotEpsilon {
+		sale.Short = 0
+	}
+
+	h.Quantity -= t.Quantity
+	if math.Abs(h.Quantity) <= lotEpsilon {
+		h.Quantity = 0
+	}
+	open := h.Lots[:0]
+	h.Cost = 0
+	for _, l := range h.Lots {
+		if l.Quantity > lotEpsilon {
+			open = append(open, l)
+			h.Cost += l.Cost
+		}
+	}
+	h.Lots = open
+	if h.Quantity <= lotEpsilon {
+		h.Lots, h.Cost = nil, 0
+	}
+	h.Sales = append(h.Sales, sale)
+}
+
+func (h *Holding) takeFIFO(sale *Sale, quantity float64) {
+	for i := 0; i < len(h.Lots) && quantity > lotEpsilon; i++ {
+		quantity -= h.take(sale, i, quantity)
+	}
+}
+
+// take sells up to quantity shares of lot i at their share of its cost and
+// returns the number of shares taken.
+func (h *Holding) take(sale *Sale, i int, quantity float64) float64 {
+	l := &h.Lots[i]
+	if quantity > l.Quantity {
+		quantity = l.Quantity
+	}
+	if quantity <= 0 {
+		return 0
+	}
+	cost := l.Cost * quantity / l.Quantity
+	l.Quantity -= quantity
+	l.Cost -= cost
+	sale.Cost += cost
+	for j := range sale.Matches {
+		if m := &sale.Matches[j]; m.TradeID == l.TradeID {
+			m.Quantity += quantity
+			m.Cost += cost
+			return quantity
+		}
+	}
+	sale.Matches = append(sale.Matches, Match{TradeID: l.TradeID, AcquiredAt: l.AcquiredAt, Quantity: quantity, Cost: cost})
+	return quantity
+}
@@ -0,0 +1,172 @@
+// /backend/portfolio/lots_test.go
+
+package portfolio
+
+import "testing"
+
+func TestReplayMethods(t *testing.T) {
+	buys := []Trade{
+		{ID: 1, InstrumentID: 10, IsPurchase: true, Quantity: 10, Price: 100, TradedAt: day(2021, 1, 4)},
+		{ID: 2, InstrumentID: 10, IsPurchase: true, Quantity: 10, Price: 200, TradedAt: day(2021, 2, 1)},
+	}
+	sell := func(lots ...LotSelection) Trade {
+		return Trade{ID: 3, InstrumentID: 10, Quantity: 15, Price: 300, TradedAt: day(2021, 3, 1), Lots: lots}
+	}
+	withSale := func(sale Trade) []Trade {
+		return append(append([]Trade(nil), buys...), sale)
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		trades       []Trade
+		actions      []Action
+		wantQuantity float64
+		wantCost     float64
+		wantLots     []Lot
+		wantSales    []Sale
+	}{
+		{
+			name:         "fifo sale partially consumes several lots",
+			method:       MethodFIFO,
+			trades:       withSale(sell()),
+			wantQuantity: 5,
+			wantCost:     1000,
+			wantLots:     []Lot{{TradeID: 2, InstrumentID: 10, AcquiredAt: day(2021, 2, 1), Quantity: 5, Cost: 1000}},
+			wantSales: []Sale{{
+				TradeID: 3, InstrumentID: 10, SoldAt: day(2021, 3, 1), Quantity: 15,
+				Proceeds: 4500, Cost: 2000, RealizedPL: 2500,
+				Matches: []Match{
+					{TradeID: 1, AcquiredAt: day(2021, 1, 4), Quantity: 10, Cost: 1000, Proceeds: 3000},
+					{TradeID: 2, AcquiredAt: day(2021, 2, 1), Quantity: 5, Cost: 1000, Proceeds: 1500},
+				},
+			}},
+		},
+		{
+			name:         "lifo sells the newest lot first",
+			method:       MethodLIFO,
+			trades:       withSale(sell()),
+			wantQuantity: 5,
+			wantCost:     500,
+			wantLots:     []Lot{{TradeID: 1, InstrumentID: 10, AcquiredAt: day(2021, 1, 4), Quantity: 5, Cost: 500}},
+			wantSales: []Sale{{
+				TradeID: 3, InstrumentID: 10, SoldAt: day(2021, 3, 1), Quantity: 15,
+				Proceeds: 4500, Cost: 2500, RealizedPL: 2000,
+				Matches: []Match{
+					{TradeID: 2, AcquiredAt: day(2021, 2, 1), Quantity: 10, Cost: 2000, Proceeds: 3000},
+					{TradeID: 1, AcquiredAt: day(2021, 1, 4), Quantity: 5, Cost: 500, Proceeds: 1500},
+				},
+			}},
+		},
+		{
+			name:         "average sells every lot in proportion",
+			method:       MethodAverage,
+			trades:       withSale(sell()),
+			wantQuantity: 5,
+			wantCost:     750,
+			wantLots: []Lot{
+				{TradeID: 1, InstrumentID: 10, AcquiredAt: day(2021, 1, 4), Quantity: 2.5, Cost: 250},
+				{TradeID: 2, InstrumentID: 10, AcquiredAt: day(2021, 2, 1), Quantity: 2.5, Cost: 500},
+			},
+			wantSales: []Sale{{
+				TradeID: 3, InstrumentID: 10, SoldAt: day(2021, 3, 1), Quantity: 15,
+				Proceeds: 4500, Cost: 2250, RealizedPL: 2250,
+				Matches: []Match{
+					{TradeID: 1, AcquiredAt: day(2021, 1, 4), Quantity: 7.5, Cost: 750, Proceeds: 2250},
+					{TradeID: 2, AcquiredAt: day(2021, 2, 1), Quantity: 7.5, Cost: 1500, Proceeds: 2250},
+				},
+			}},
+		},
+		{
+			name:         "specific lot sells the named lot and the rest fifo",
+			method:       MethodSpecificLot,
+			trades:       withSale(sell(LotSelection{TradeID: 2, Quantity: 10})),
+			wantQuantity: 5,
+			wantCost:     500,
+			wantLots:     []Lot{{TradeID: 1, InstrumentID: 10, AcquiredAt: day(2021, 1, 4), Quantity: 5, Cost: 500}},
+			wantSales: []Sale{{
+				TradeID: 3, InstrumentID: 10, SoldAt: day(2021, 3, 1), Quantity: 15,
+				Proceeds: 4500, Cost: 2500, RealizedPL: 2000,
+				Matches: []Match{
+					{TradeID: 2, AcquiredAt: day(2021, 2, 1), Quantity: 10, Cost: 2000, Proceeds: 3000},
+					{TradeID: 1, AcquiredAt: day(2021, 1, 4), Quantity: 5, Cost: 500, Proceeds: 1500},
+				},
+			}},
+		},
+		{
+			name:         "specific lot selection beyond what the lot holds",
+			method:       MethodSpecificLot,
+			trades:       withSale(sell(LotSelection{TradeID: 1, Quantity: 12})),
+			wantQuantity: 5,
+			wantCost:     1000,
+			wantLots:     []Lot{{TradeID: 2, InstrumentID: 10, AcquiredAt: day(2021, 2, 1), Quantity: 5, Cost: 1000}},
+			wantSales: []Sale{{
+				TradeID: 3, InstrumentID: 10, SoldAt: day(2021, 3, 1), Quantity: 15,
+				Proceeds: 4500, Cost: 2000, RealizedPL: 2500,
+				Matches: []Match{
+					{TradeID: 1, AcquiredAt: day(2021, 1, 4), Quantity: 10, Cost: 1000, Proceeds: 3000},
+					{TradeID: 2, AcquiredAt: day(2021, 2, 1), Quantity: 5, Cost: 1000, Proceeds: 1500},
+				},
+			}},
+		},
+		{
+			name:   "sale costs reduce the proceeds",
+			method: MethodFIFO,
+			trades: []Trade{
+				buys[0],
+				{ID: 3, InstrumentID: 10, Quantity: 5, Price: 300, Costs: 15, TradedAt: day(2021, 3, 1)},
+			},
+			wantQuantity: 5,
+			wantCost:     500,
+			wantLots:     []Lot{{TradeID: 1, InstrumentID: 10, AcquiredAt: day(2021, 1, 4), Quantity: 5, Cost: 500}},
+			wantSales: []Sale{{
+				TradeID: 3, InstrumentID: 10, SoldAt: day(2021, 3, 1), Quantity: 5,
+				Proceeds: 1485, Cost: 500, RealizedPL: 985,
+				Matches: []Match{{TradeID: 1, AcquiredAt: day(2021, 1, 4), Quantity: 5, Cost: 500, Proceeds: 1485}},
+			}},
+		},
+		{
+			name:   "purchase covers a short",
+			method: MethodFIFO,
+			trades: []Trade{
+				{ID: 1, InstrumentID: 10, Quantity: 5, Price: 100, TradedAt: day(2021, 1, 4)},
+				{ID: 2, InstrumentID: 10, IsPurchase: true, Quantity: 10, Price: 50, TradedAt: day(2021, 2, 1)},
+			},
+			wantQuantity: 5,
+			wantCost:     250,
+			wantLots:     []Lot{{TradeID: 2, InstrumentID: 10, AcquiredAt: day(2021, 2, 1), Quantity: 5, Cost: 250}},
+			wantSales:    []Sale{{TradeID: 1, InstrumentID: 10, SoldAt: day(2021, 1, 4), Quantity: 5, Short: 5}},
+		},
+		{
+			name:         "split between the buys and the sale",
+			method:       MethodLIFO,
+			trades:       withSale(Trade{ID: 3, InstrumentID: 10, Quantity: 30, Price: 150, TradedAt: day(2021, 3, 1)}),
+			actions:      []Action{{ID: 1, Type: ActionSplit, InstrumentID: 10, EffectiveDate: day(2021, 2, 15), Ratio: 2}},
+			wantQuantity: 10,
+			wantCost:     500,
+			wantLots:     []Lot{{TradeID: 1, InstrumentID: 10, AcquiredAt: day(2021, 1, 4), Quantity: 10, Cost: 500}},
+			wantSales: []Sale{{
+				TradeID: 3, InstrumentID: 10, SoldAt: day(2021, 3, 1), Quantity: 30,
+				Proceeds: 4500, Cost: 2500, RealizedPL: 2000,
+				Matches: []Match{
+					{TradeID: 2, AcquiredAt: day(2021, 2, 1), Quantity: 20, Cost: 2000, Proceeds: 3000},
+					{TradeID: 1, AcquiredAt: day(2021, 1, 4), Quantity: 10, Cost: 500, Proceeds: 1500},
+				},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Replay(tt.trades, tt.actions, tt.method)[10]
+			if h == nil {
+				t.Fatal("no holding for the instrument")
+			}
+			if !almostEqual(h.Quantity, tt.wantQuantity) || !almostEqual(h.Cost, tt.wantCost) {
+				t.Errorf("holding = %v shares at %v, want %v at %v", h.Quantity, h.Cost, tt.wantQuantity, tt.wantCost)
+			}
+			assertLots(t, h.Lots, tt.wantLots)
+			assertSales(t, h.Sales, tt.wantSales)
+		})
+	}
+}
@@ -0,0 +1,514 @@
+// /backend/quotes/alphavantage.go
+
+package quotes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	AlphaVantageName = "alphavantage"
+	// AlphaVantageBulkName is Alpha Vantage with a premium key, which quotes
+	// up to 100 symbols per request.
+	AlphaVantageBulkName = "alphavantage-bulk"
+	alphaVantageBaseURL  = "https://www.alphavantage.co"
+	alphaVantageMaxBulk  = 100
+)
+
+// AlphaVantage fetches quotes one symbol at a time through GLOBAL_QUOTE.
+// Premium keys can set Batch to use REALTIME_BULK_QUOTES instead; users
+// choose it by storing their key for AlphaVantageBulkName. Currency
+// and crypto pairs are always quoted one at a time through
+// CURRENCY_EXCHANGE_RATE.
+type AlphaVantage struct {
+	APIKey  string
+	BaseURL string
+	Client  *http.Client
+	Batch   bool
+}
+
+type alphaVantageMessages struct {
+	ErrorMessage string `json:"Error Message"`
+	Note         string `json:"Note"`
+	Information  string `json:"Information"`
+}
+
+type alphaVantageGlobalQuote struct {
+	alphaVantageMessages
+	GlobalQuote struct {
+		Symbol           string `json:"01. symbol"`
+		Price            string `json:"05. price"`
+		LatestTradingDay string `json:"07. latest trading day"`
+	} `json:"Global Quote"`
+}
+
+type alphaVantageExchangeRate struct {
+	alphaVantageMessages
+	Rate struct {
+		FromName      string `json:"2. From_Currency Name"`
+		To            string `json:"3. To_Currency Code"`
+		Rate          string `json:"5. Exchange Rate"`
+		LastRefreshed string `json:"6. Last Refreshed"`
+	} `json:"Realtime Currency Exchange Rate"`
+}
+
+type alphaVantageBulkQuotes struct {
+	alphaVantageMessages
+	Data []struct {
+		Symbol    string `json:"symbol"`
+		Timestamp string `json:"timestamp"`
+		Close     string `json:"close"`
+	} `json:"data"`
+}
+
+func NewAlphaVantage(apiKey string) *AlphaVantage {
+	return &AlphaVantage{
+		APIKey:  apiKey,
+		BaseURL: alphaVantageBaseURL,
+		Client:  &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// NewAlphaVantageBulk is NewAlphaVantage for premium keys.
+func NewAlphaVantageBulk(apiKey string) *AlphaVantage {
+	a := NewAlphaVantage(apiKey)
+	a.Batch = true
+	return a
+}
+
+// Name tells the bulk provider apart, as its credit budget is a different
+// one from a free key's.
+func (a *AlphaVantage) Name() string {
+	if a.Batch {
+		return AlphaVantageBulkName
+	}
+	return AlphaVantageName
+}
+
+func (a *AlphaVantage) Capabilities() Capabilities {
+	if a.Batch {
+		return Capabilities{
+			MaxSymbolsPerRequest: alphaVantageMaxBulk,
+			CreditsPerMinute:     75,
+			SupportsExchange:     true,
+		}
+	}
+	return Capabilities{
+		MaxSymbolsPerRequest: 1,
+		CreditsPerMinute:     5,
+		CreditsPerDay:        25,
+		SupportsExchange:     true,
+	}
+}
+
+func (a *AlphaVantage) FetchQuotes(ctx context.Context, symbols []Symbol) ([]Quote, error) {
+	if len(symbols) == 0 {
+		return nil, nil
+	}
+
+	var (
+		quotes []Quote
+		listed []Symbol
+	)
+	for _, s := range symbols {
+		if !s.IsPair() {
+			listed = append(listed, s)
+			continue
+		}
+		quote, ok, err := a.fetchExchangeRate(ctx, s)
+		if err != nil {
+			return quotes, err
+		}
+		if ok {
+			quotes = append(quotes, quote)
+		}
+	}
+
+	if a.Batch && len(listed) > 1 {
+		fetched, err := a.fetchBulk(ctx, listed)
+		return append(quotes, fetched...), err
+	}
+	for _, s := range listed {
+		quote, ok, err := a.fetchGlobalQuote(ctx, s)
+		if err != nil {
+			return quotes, err
+		}
+		if ok {
+			quotes = append(quotes, quote)
+		}
+	}
+	return quotes, nil
+}
+
+func (a *AlphaVantage) fetchGlobalQuote(ctx context.Context, s Symbol) (Quote, bool, error) {
+	params := url.Values{}
+	params.Set("function", "GLOBAL_QUOTE")
+	params.Set("symbol", alphaVantageSymbol(s))
+
+	var response alphaVantageGlobalQuote
+	if err := a.get(ctx, params, &response); err != nil {
+		return Quote{}, false, err
+	}
+	if err := alphaVantageError(response.alphaVantageMessages); err != nil {
+		return Quote{}, false, err
+	}
+
+	// Unknown symbols come back as an empty "Global Quote" object.
+	price, err := strconv.ParseFloat(response.GlobalQuote.Price, 64)
+	if err != nil {
+		return Quote{}, false, nil
+	}
+
+	quote := Quote{
+		Symbol:    s.Symbol,
+		Exchange:  s.Exchange,
+		Price:     price,
+		Timestamp: time.Now().UTC(),
+		Source:    AlphaVantageName,
+	}
+	if day, err := time.Parse("2006-01-02", response.GlobalQuote.LatestTradingDay); err == nil {
+		quote.Timestamp = day
+	}
+	return quote, true, nil
+}
+
+func (a *AlphaVantage) fetchExchangeRate(ctx context.Context, s Symbol) (Quote, bool, error) {
+	base, quoteCurrency, err := ParsePair(s.Symbol)
+	if err != nil {
+		return Quote{}, false, nil
+	}
+
+	params := url.Values{}
+	params.Set("function", "CURRENCY_EXCHANGE_RATE")
+	params.Set("from_currency", base)
+	params.Set("to_currency", quoteCurrency)
+
+	var response alphaVantageExchangeRate
+	if err := a.get(ctx, params, &response); err != nil {
+		return Quote{}, false, err
+	}
+	if err := alphaVantageError(response.alphaVantageMessages); err != nil {
+		// Unknown currencies are reported as an invalid call.
+		if errors.Is(err, ErrSymbolNotFound) {
+			return Quote{}, false, nil
+		}
+		return Quote{}, false, err
+	}
+
+	price, err := strconv.ParseFloat(response.Rate.Rate, 64)
+	if err != nil {
+		return Quote{}, false, nil
+	}
+
+	quote := Quote{
+		Symbol:    s.Symbol,
+		Exchange:  s.Exchange,
+		Name:      response.Rate.FromName,
+		Currency:  response.Rate.To,
+		Price:     price,
+		Timestamp: time.Now().UTC(),
+		Source:    AlphaVantageName,
+	}
+	// Last Refreshed is given in UTC.
+	if ts, err := time.Parse("2006-01-02 15:04:05", response.Rate.LastRefreshed); err == nil {
+		quote.Timestamp = ts
+	}
+	return quote, true, nil
+}
+
+func (a *AlphaVantage) fetchBulk(ctx context.Context, symbols []Symbol) ([]Quote, error) {
+	bySymbol := make(map[string]Symbol, len(symbols))
+	tags := make([]string, 0, len(symbols))
+	for _, s := range symbols {
+		tag := alphaVantageSymbol(s)
+		bySymbol[strings.ToUpper(tag)] = s
+		tags = append(tags, tag)
+	}
+
+	var quotes []Quote
+	for start := 0; start < len(tags); start += alphaVantageMaxBulk {
+		end := start + alphaVantageMaxBulk
+		if end > len(tags) {
+			end = len(tags)
+		}
+
+		params := url.Values{}
+		params.Set("function", "REALTIME_BULK_QUOTES")
+		params.Set("symbol", strings.Join(tags[start:end], ","))
+
+		var response alphaVantageBulkQuotes
+		if err := a.get(ctx, params, &response); err != nil {
+			return quotes, err
+		}
+		if err := alphaVantageError(response.alphaVantageMessages); err != nil {
+			return quotes, err
+		}
+
+		for _, raw := range response.Data {
+			requested, ok := bySymbol[strings.ToUpper(raw.Symbol)]
+			if !ok {
+				continue
+			}
+			price, err := strconv.ParseFloat(raw.Close, 64)
+			if err != nil {
+				continue
+			}
+			quote := Quote{
+				Symbol:    requested.Symbol,
+				Exchange:  requested.Exchange,
+				Price:     price,
+				Timestamp: time.Now().UTC(),
+				Source:    AlphaVantageName,
+			}
+			if ts, err := time.Parse("2006-01-02 15:04:05.000", raw.Timestamp); err == nil {
+				quote.Timestamp = ts
+			}
+			quotes = append(quotes, quote)
+		}
+	}
+	return quotes, nil
+}
+
+type alphaVantageDailyValues map[string]struct {
+	Open   string `json:"1. open"`
+	High   string `json:"2. high"`
+	Low    string `json:"3. low"`
+	Close  string `json:"4. close"`
+	Volume string `json:"5. volume"`
+}
+
+type alphaVantageDailySeries struct {
+	alphaVantageMessages
+	TimeSeries       alphaVantageDailyValues `json:"Time Series (Daily)"`
+	CryptoTimeSeries alphaVantageDailyValues `json:"Time Series (Digital Currency Daily)"`
+	FXTimeSeries     alphaVantageDailyValues `json:"Time Series FX (Daily)"`
+}
+
+// FetchDailyCandles reads TIME_SERIES_DAILY, asking for the full history
+// only when the compact 100 day window cannot cover from. Crypto pairs are
+// read from DIGITAL_CURRENCY_DAILY, which always returns the full history,
+// and currency pairs from FX_DAILY.
+func (a *AlphaVantage) FetchDailyCandles(ctx context.Context, symbol Symbol, from, to time.Time) ([]Candle, error) {
+	params := url.Values{}
+	switch {
+	case symbol.IsPair():
+		base, quoteCurrency, err := ParsePair(symbol.Symbol)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSymbolNotFound, err)
+		}
+		if symbol.IsCrypto() {
+			params.Set("function", "DIGITAL_CURRENCY_DAILY")
+			params.Set("symbol", base)
+			params.Set("market", quoteCurrency)
+		} else {
+			params.Set("function", "FX_DAILY")
+			params.Set("from_symbol", base)
+			params.Set("to_symbol", quoteCurrency)
+			if time.Since(from) > 100*24*time.Hour {
+				params.Set("outputsize", "full")
+			}
+		}
+	default:
+		params.Set("function", "TIME_SERIES_DAILY")
+		params.Set("symbol", alphaVantageSymbol(symbol))
+		if time.Since(from) > 100*24*time.Hour {
+			params.Set("outputsize", "full")
+		}
+	}
+
+	var response alphaVantageDailySeries
+	if err := a.get(ctx, params, &response); err != nil {
+		return nil, err
+	}
+	if err := alphaVantageError(response.alphaVantageMessages); err != nil {
+		return nil, err
+	}
+
+	series := response.TimeSeries
+	switch {
+	case symbol.IsCrypto():
+		series = response.CryptoTimeSeries
+	case symbol.IsPair():
+		series = response.FXTimeSeries
+	}
+	candles := make([]Candle, 0, len(series))
+	for date, v := range series {
+		day, err := time.Parse("2006-01-02", date)
+		if err != nil || day.Before(from) || day.After(to) {
+			continue
+		}
+		candle := Candle{Time: day}
+		if candle.Close, err = strconv.ParseFloat(v.Close, 64); err != nil {
+			continue
+		}
+		candle.Open, _ = strconv.ParseFloat(v.Open, 64)
+		candle.High, _ = strconv.ParseFloat(v.High, 64)
+		candle.Low, _ = strconv.ParseFloat(v.Low, 64)
+		candle.Volume, _ = strconv.ParseFloat(v.Volume, 64)
+		candles = append(candles, candle)
+	}
+	sort.Slice(candles, func(i, j int) bool { return candles[i].Time.Before(candles[j].Time) })
+	return candles, nil
+}
+
+type alphaVantageSplits struct {
+	alphaVantageMessages
+	Data []struct {
+		EffectiveDate string `json:"effective_date"`
+		SplitFactor   string `json:"split_factor"`
+	} `json:"data"`
+}
+
+// FetchSplits reads SPLITS, whose split_factor is already the number of new
+// shares per old share. Only listed securities split.
+func (a *AlphaVantage) FetchSplits(ctx context.Context, symbol Symbol) ([]Split, error) {
+	if symbol.IsPair() {
+		return nil, nil
+	}
+	params := url.Values{}
+	params.Set("function", "SPLITS")
+	params.Set("symbol", alphaVantageSymbol(symbol))
+
+	var response alphaVantageSplits
+	if err := a.get(ctx, params, &response); err != nil {
+		return nil, err
+	}
+	if err := alphaVantageError(response.alphaVantageMessages); err != nil {
+		return nil, err
+	}
+
+	splits := make([]Split, 0, len(response.Data))
+	for _, d := range response.Data {
+		day, err := time.Parse("2006-01-02", d.EffectiveDate)
+		if err != nil {
+			continue
+		}
+		ratio, err := strconv.ParseFloat(d.SplitFactor, 64)
+		if err != nil || ratio <= 0 {
+			continue
+		}
+		splits = append(splits, Split{Date: day, Ratio: ratio})
+	}
+	sort.Slice(splits, func(i, j int) bool { return splits[i].Date.Before(splits[j].Date) })
+	return splits, nil
+}
+
+type alphaVantageSymbolSearch struct {
+	alphaVantageMessages
+	BestMatches []struct {
+		Symbol   string `json:"1. symbol"`
+		Name     string `json:"2. name"`
+		Type     string `json:"3. type"`
+		Region   string `json:"4. region"`
+		Currency string `json:"8. currency"`
+	} `json:"bestMatches"`
+}
+
+// SearchSymbols reads SYMBOL_SEARCH. Listings outside the US carry an
+// exchange suffix, which is mapped back to the venue it stands for.
+func (a *AlphaVantage) SearchSymbols(ctx context.Context, query string) ([]Listing, error) {
+	params := url.Values{}
+	params.Set("function", "SYMBOL_SEARCH")
+	params.Set("keywords", query)
+
+	var response alphaVantageSymbolSearch
+	if err := a.get(ctx, params, &response); err != nil {
+		return nil, err
+	}
+	if err := alphaVantageError(response.alphaVantageMessages); err != nil {
+		return nil, err
+	}
+
+	listings := make([]Listing, 0, len(response.BestMatches))
+	for _, m := range response.BestMatches {
+		listing := Listing{
+			Symbol:   m.Symbol,
+			Name:     m.Name,
+			Currency: m.Currency,
+			Type:     m.Type,
+			Country:  m.Region,
+		}
+		if i := strings.LastIndex(m.Symbol, "."); i > 0 {
+			if e, ok := lookupAlphaVantageSuffix(m.Symbol[i+1:]); ok {
+				listing.Symbol = m.Symbol[:i]
+				listing.Exchange = e.Code
+				listing.MIC = e.MIC
+			} else if m.Region != "United States" {
+				// A venue we cannot address, so it could never be quoted.
+				continue
+			}
+		}
+		listings = append(listings, listing)
+	}
+	return listings, nil
+}
+
+// alphaVantageSymbol applies Alpha Vantage's exchange suffix notation, e.g.
+// SHEL on LSE becomes SHEL.LON. US listings take no suffix.
+func alphaVantageSymbol(s Symbol) string {
+	if e, ok := LookupExchange(s.Exchange); ok && e.AlphaVantageSuffix != "" {
+		return s.Symbol + "." + e.AlphaVantageSuffix
+	}
+	return s.Symbol
+}
+
+func (a *AlphaVantage) get(ctx context.Context, params url.Values, out interface{}) error {
+	params.Set("apikey", a.APIKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.BaseURL+"/query?"+params.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("error building request: %v", err)
+	}
+
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("API returned non-OK status: %s", resp.Status)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %v", err)
+	}
+	if err := json.Unmarshal(bodyBytes, out); err != nil {
+		return fmt.Errorf("JSON Decode error: %v", err)
+	}
+	return nil
+}
+
+// alphaVantageError maps the free-text messages Alpha Vantage returns with a
+// 200 status onto the package's sentinel errors.
+func alphaVantageError(m alphaVantageMessages) error {
+	switch {
+	case m.ErrorMessage != "":
+		if strings.Contains(strings.ToLower(m.ErrorMessage), "apikey") {
+			return fmt.Errorf("%w: %s", ErrInvalidAPIKey, m.ErrorMessage)
+		}
+		return fmt.Errorf("%w: %s", ErrSymbolNotFound, m.ErrorMessage)
+	case m.Note != "":
+		return fmt.Errorf("%w: %s", ErrCreditsExhausted, m.Note)
+	case m.Information != "":
+		info := strings.ToLower(m.Information)
+		if strings.Contains(info, "rate limit") || strings.Contains(info, "requests per day") || strings.Contains(info, "call frequency") {
+			return fmt.Errorf("%w: %s", ErrCreditsExhausted, m.Information)
+		}
+		if strings.Contains(info, "api key") {
+			return fmt.Errorf("%w: %s", ErrInvalidAPIKey, m.Information)
+		}
+		return fmt.Errorf("alpha vantage: %s", m.Information)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// /backend/quotes/alphavantage_test.go
+
+package quotes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newAlphaVantageServer answers every query with the body registered for its
+// function and records the symbol parameters it was asked for.
+func newAlphaVantageServer(t *testing.T, bodies map[string]string) (*AlphaVantage, *[]string) {
+	t.Helper()
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/query" || r.URL.Query().Get("apikey") != "test-key" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		requested = append(requested, r.URL.Query().Get("symbol"))
+		body, ok := bodies[r.URL.Query().Get("function")]
+		if !ok {
+			http.Error(w, "unexpected function", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	a := NewAlphaVantage("test-key")
+	a.BaseURL = server.URL
+	return a, &requested
+}
+
+func TestAlphaVantageGlobalQuote(t *testing.T) {
+	a, requested := newAlphaVantageServer(t, map[string]string{
+		"GLOBAL_QUOTE": `{"Global Quote": {"01. symbol": "SHEL.LON", "05. price": "2650.5000", "07. latest trading day": "2024-03-15"}}`,
+	})
+
+	got, err := a.FetchQuotes(context.Background(), []Symbol{{Symbol: "SHEL", Exchange: "LSE"}})
+	if err != nil {
+		t.Fatalf("FetchQuotes: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d quotes, want 1", len(got))
+	}
+	want := Quote{Symbol: "SHEL", Exchange: "LSE", Price: 2650.5, Timestamp: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), Source: AlphaVantageName}
+	if got[0] != want {
+		t.Errorf("quote = %+v, want %+v", got[0], want)
+	}
+	if len(*requested) != 1 || (*requested)[0] != "SHEL.LON" {
+		t.Errorf("requested symbols %v, want [SHEL.LON]", *requested)
+	}
+}
+
+func TestAlphaVantageBulkQuotes(t *testing.T) {
+	a, requested := newAlphaVantageServer(t, map[string]string{
+		"REALTIME_BULK_QUOTES": `{"data": [
+			{"symbol": "AAPL", "timestamp": "2024-03-15 16:00:00.000", "close": "172.62"},
+			{"symbol": "msft", "timestamp": "2024-03-15 16:00:00.000", "close": "416.42"},
+			{"symbol": "IBM", "timestamp": "2024-03-15 16:00:00.000", "close": "191.07"}
+		]}`,
+	})
+	a.Batch = true
+
+	got, err := a.FetchQuotes(context.Background(), []Symbol{{Symbol: "AAPL", Exchange: "NASDAQ"}, {Symbol: "MSFT", Exchange: "NASDAQ"}})
+	if err != nil {
+		t.Fatalf("FetchQuotes: %v", err)
+	}
+	if len(*requested) != 1 || (*requested)[0] != "AAPL,MSFT" {
+		t.Errorf("requested symbols %v, want one request for [AAPL,MSFT]", *requested)
+	}
+
+	ts := time.Date(2024, 3, 15, 16, 0, 0, 0, time.UTC)
+	want := []Quote{
+		{Symbol: "AAPL", Exchange: "NASDAQ", Price: 172.62, Timestamp: ts, Source: AlphaVantageName},
+		{Symbol: "MSFT", Exchange: "NASDAQ", Price: 416.42, Timestamp: ts, Source: AlphaVantageName},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d quotes, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("quote %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestAlphaVantageRegistry(t *testing.T) {
+	tests := []struct {
+		name          string
+		wantBatch     bool
+		wantPerMinute int
+		wantPerBatch  int
+	}{
+		{name: AlphaVantageName, wantPerMinute: 5, wantPerBatch: 1},
+		{name: AlphaVantageBulkName, wantBatch: true, wantPerMinute: 75, wantPerBatch: alphaVantageMaxBulk},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := New(tt.name, "test-key")
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			a, ok := provider.(*AlphaVantage)
+			if !ok {
+				t.Fatalf("New returned %T, want *AlphaVantage", provider)
+			}
+			if a.Batch != tt.wantBatch || a.Name() != tt.name {
+				t.Errorf("provider %q with Batch %v, want %q with %v", a.Name(), a.Batch, tt.name, tt.wantBatch)
+			}
+			caps := a.Capabilities()
+			if caps.CreditsPerMinute != tt.wantPerMinute || caps.MaxSymbolsPerRequest != tt.wantPerBatch {
+				t.Errorf("capabilities = %+v, want %d per minute and %d per request", caps, tt.wantPerMinute, tt.wantPerBatch)
+			}
+		})
+	}
+}
+
+func TestAlphaVantageErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr error
+	}{
+		{
+			name:    "rate limit note",
+			body:    `{"Note": "Thank you for using Alpha Vantage! Our standard API call frequency is 5 calls per minute and 500 calls per day."}`,
+			wantErr: ErrCreditsExhausted,
+		},
+		{
+			name:    "daily limit information",
+			body:    `{"Information": "Thank you for using Alpha Vantage! Our standard API rate limit is 25 requests per day."}`,
+			wantErr: ErrCreditsExhausted,
+		},
+		{
+			name:    "invalid key information",
+			body:    `{"Information": "The **demo** API key is for demo purposes only. Please claim your free API key."}`,
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name:    "invalid key error message",
+			body:    `{"Error Message": "the parameter apikey is invalid or missing."}`,
+			wantErr: ErrInvalidAPIKey,
+		},
+		{
+			name:    "unknown symbol",
+			body:    `{"Error Message": "Invalid API call. Please retry or visit the documentation for GLOBAL_QUOTE."}`,
+			wantErr: ErrSymbolNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newAlphaVantageServer(t, map[string]string{"GLOBAL_QUOTE": tt.body})
+
+			got, err := a.FetchQuotes(context.Background(), []Symbol{{Symbol: "AAPL", Exchange: "NASDAQ"}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("FetchQuotes error = %v, want %v", err, tt.wantErr)
+			}
+			if len(got) != 0 {
+				t.Errorf("got quotes %+v, want none", got)
+			}
+		})
+	}
+}
+
+func TestAlphaVantageEmptyGlobalQuote(t *testing.T) {
+	// Symbols Alpha Vantage does not know come back as an empty object,
+	// which yields no quote rather than an error.
+	a, _ := newAlphaVantageServer(t, map[string]string{"GLOBAL_QUOTE": `{"Global Quote": {}}`})
+
+	got, err := a.FetchQuotes(context.Background(), []Symbol{{Symbol: "NOPE", Exchange: "NASDAQ"}})
+	if err != nil {
+		t.Fatalf("FetchQuotes: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got quotes %+v, want none", got)
+	}
+}
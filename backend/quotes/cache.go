@@ -0,0 +1,68 @@
+// /backend/quotes/cache.go
+
+package quotes
+
+import (
+	"sync/atomic"
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+)
+
+const DefaultCacheTTL = time.Minute
+
+// Cache holds recently fetched quotes keyed by symbol and exchange so that
+// users holding the same instrument share a single provider call.
+type Cache struct {
+	store  *gocache.Cache
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type CacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+}
+
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{store: gocache.New(ttl, 2*ttl)}
+}
+
+func (c *Cache) Get(s Symbol) (Quote, bool) {
+	if value, ok := c.store.Get(s.Key()); ok {
+		c.hits.Add(1)
+		return value.(Quote), true
+	}
+	c.misses.Add(1)
+	return Quote{}, false
+}
+
+func (c *Cache) Set(q Quote) {
+	c.store.SetDefault(Symbol{Symbol: q.Symbol, Exchange: q.Exchange}.Key(), q)
+}
+
+// Lookup splits symbols into the quotes already cached and the symbols that
+// still have to be fetched.
+func (c *Cache) Lookup(symbols []Symbol) ([]Quote, []Symbol) {
+	var (
+		cached  []Quote
+		missing []Symbol
+	)
+	for _, s := range symbols {
+		if q, ok := c.Get(s); ok {
+			cached = append(cached, q)
+		} else {
+			missing = append(missing, s)
+		}
+	}
+	return cached, missing
+}
+
+func (c *Cache) Stats() CacheStats {
+	return CacheStats{
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Entries: c.store.ItemCount(),
+	}
+}
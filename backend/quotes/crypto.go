@@ -0,0 +1,61 @@
+// /backend/quotes/crypto.go
+
+package quotes
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// CryptoExchange is the venue crypto pairs are stored under. Their price
+	// is the market rate rather than one exchange's order book, and where
+	// the coins are held is recorded per transaction instead. It has no
+	// trading calendar, so crypto is refreshed around the clock.
+	CryptoExchange = "CRYPTO"
+
+	// CryptoDecimals is the precision crypto quantities are kept at, that of
+	// a satoshi.
+	CryptoDecimals = 8
+)
+
+// IsCrypto reports whether s is a crypto pair rather than a listed security.
+func (s Symbol) IsCrypto() bool {
+	return NormalizeExchange(s.Exchange) == CryptoExchange
+}
+
+// ParsePair splits a pair in the notation BASE/QUOTE, such as BTC/USD, and
+// returns both codes in upper case. BTC-USD is accepted as well.
+func ParsePair(pair string) (base, quote string, err error) {
+	pair = strings.ToUpper(strings.TrimSpace(pair))
+	parts := strings.FieldsFunc(pair, func(r rune) bool { return r == '/' || r == '-' })
+	if len(parts) != 2 || !isCurrencyCode(parts[0]) || !isCurrencyCode(parts[1]) {
+		return "", "", fmt.Errorf("invalid crypto pair %q, expected e.g. BTC/USD", pair)
+	}
+	if parts[0] == parts[1] {
+		return "", "", fmt.Errorf("invalid crypto pair %q, base and quote are the same", pair)
+	}
+	return parts[0], parts[1], nil
+}
+
+// RoundCryptoQuantity rounds q to CryptoDecimals, dropping the binary noise
+// float arithmetic leaves in the last digits.
+func RoundCryptoQuantity(q float64) float64 {
+	scale := math.Pow10(CryptoDecimals)
+	return math.Round(q*scale) / scale
+}
+
+// Tickers of coins and tokens run from two letters (OP) to around ten
+// (e.g. 1000SHIB) and may contain digits.
+func isCurrencyCode(code string) bool {
+	if len(code) < 2 || len(code) > 10 {
+		return false
+	}
+	for _, r := range code {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+// /backend/quotes/exchanges.go
+
+package quotes
+
+import "strings"
+
+// Exchange describes a trading venue and the codes providers use for it.
+type Exchange struct {
+	Code               string
+	MIC                string
+	Aliases            []string
+	AlphaVantageSuffix string
+}
+
+var exchanges = []Exchange{
+	{Code: "NASDAQ", MIC: "XNAS", Aliases: []string{"XNGS", "XNMS", "XNCM"}},
+	{Code: "NYSE", MIC: "XNYS"},
+	{Code: "NYSE ARCA", MIC: "ARCX", Aliases: []string{"ARCA", "NYSEARCA"}},
+	{Code: "AMEX", MIC: "XASE", Aliases: []string{"NYSE AMERICAN", "NYSEAMERICAN"}},
+	{Code: "LSE", MIC: "XLON", Aliases: []string{"LON", "LONDON"}, AlphaVantageSuffix: "LON"},
+	{Code: "XETR", MIC: "XETR", Aliases: []string{"XETRA", "DEX"}, AlphaVantageSuffix: "DEX"},
+	{Code: "FSX", MIC: "XFRA", Aliases: []string{"FRA", "FRANKFURT"}, AlphaVantageSuffix: "FRK"},
+	{Code: "Euronext", MIC: "XPAR", Aliases: []string{"EPA", "PARIS"}, AlphaVantageSuffix: "PAR"},
+	{Code: "AMS", MIC: "XAMS", Aliases: []string{"AMSTERDAM"}, AlphaVantageSuffix: "AMS"},
+	{Code: "SIX", MIC: "XSWX", Aliases: []string{"SWX"}, AlphaVantageSuffix: "SWX"},
+	{Code: "TSX", MIC: "XTSE", Aliases: []string{"TOR", "TRT"}, AlphaVantageSuffix: "TRT"},
+	{Code: "TSXV", MIC: "XTSX", Aliases: []string{"TRV"}, AlphaVantageSuffix: "TRV"},
+	{Code: "SSE", MIC: "XSHG", Aliases: []string{"SHH", "SHANGHAI"}, AlphaVantageSuffix: "SHH"},
+	{Code: "SZSE", MIC: "XSHE", Aliases: []string{"SHZ", "SHENZHEN"}, AlphaVantageSuffix: "SHZ"},
+	{Code: "BSE", MIC: "XBOM", Aliases: []string{"BOM"}, AlphaVantageSuffix: "BSE"},
+	{Code: "NSE", MIC: "XNSE", Aliases: []string{"NSI"}, AlphaVantageSuffix: "NSE"},
+	{Code: "WSE", MIC: "XWAR", Aliases: []string{"GPW", "WAR", "WARSAW"}, AlphaVantageSuffix: "WAR"},
+}
+
+var exchangeIndex = func() map[string]Exchange {
+	index := make(map[string]Exchange)
+	for _, e := range exchanges {
+		index[strings.ToUpper(e.Code)] = e
+		index[e.MIC] = e
+		for _, alias := range e.Aliases {
+			index[strings.ToUpper(alias)] = e
+		}
+	}
+	return index
+}()
+
+// LookupExchange finds a venue by its code, MIC or a common alias.
+func LookupExchange(name string) (Exchange, bool) {
+	e, ok := exchangeIndex[strings.ToUpper(strings.TrimSpace(name))]
+	return e, ok
+}
+
+// NormalizeExchange returns the MIC for known venues so that different
+// spellings of the same exchange compare equal.
+func NormalizeExchange(name string) string {
+	if e, ok := LookupExchange(name); ok {
+		return e.MIC
+	}
+	return strings.ToUpper(strings.TrimSpace(name))
+}
+
+func lookupAlphaVantageSuffix(suffix string) (Exchange, bool) {
+	for _, e := range exchanges {
+		if e.AlphaVantageSuffix != "" && strings.EqualFold(e.AlphaVantageSuffix, suffix) {
+			return e, true
+		}
+	}
+	return Exchange{}, false
+}
@@ -0,0 +1,159 @@
+// /backend/quotes/fake.go
+
+package quotes
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const FakeName = "fake"
+
+// Fake is an in-memory QuoteProvider for tests and local development.
+type Fake struct {
+	mu      sync.Mutex
+	quotes  map[string]Quote
+	candles map[string][]Candle
+	splits  map[string][]Split
+	caps    Capabilities
+	err     error
+	calls   int
+}
+
+func NewFake(quotes ...Quote) *Fake {
+	f := &Fake{quotes: make(map[string]Quote), candles: make(map[string][]Candle), splits: make(map[string][]Split)}
+	for _, q := range quotes {
+		f.SetQuote(q)
+	}
+	return f
+}
+
+func (f *Fake) Name() string {
+	return FakeName
+}
+
+func (f *Fake) Capabilities() Capabilities {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.caps
+}
+
+func (f *Fake) SetCapabilities(caps Capabilities) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.caps = caps
+}
+
+func (f *Fake) SetQuote(q Quote) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if q.Timestamp.IsZero() {
+		q.Timestamp = time.Now().UTC()
+	}
+	if q.Source == "" {
+		q.Source = FakeName
+	}
+	f.quotes[fakeKey(Symbol{Symbol: q.Symbol, Exchange: q.Exchange})] = q
+}
+
+// SetError makes every subsequent FetchQuotes call fail with err until it is
+// reset with nil.
+func (f *Fake) SetError(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+func (f *Fake) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func (f *Fake) FetchQuotes(ctx context.Context, symbols []Symbol) ([]Quote, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	var result []Quote
+	for _, s := range symbols {
+		q, ok := f.quotes[fakeKey(s)]
+		if !ok {
+			q, ok = f.quotes[fakeKey(Symbol{Symbol: s.Symbol})]
+		}
+		if ok {
+			q.Exchange = s.Exchange
+			result = append(result, q)
+		}
+	}
+	return result, nil
+}
+
+// SetCandles replaces the daily history served for symbol. Candles must be
+// in ascending order.
+func (f *Fake) SetCandles(symbol Symbol, candles []Candle) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.candles[fakeKey(symbol)] = candles
+}
+
+func (f *Fake) FetchDailyCandles(ctx context.Context, symbol Symbol, from, to time.Time) ([]Candle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	var result []Candle
+	for _, c := range f.candles[fakeKey(symbol)] {
+		if !c.Time.Before(from) && !c.Time.After(to) {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+// SetSplits replaces the split history served for symbol.
+func (f *Fake) SetSplits(symbol Symbol, splits []Split) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.splits[fakeKey(symbol)] = splits
+}
+
+func (f *Fake) FetchSplits(ctx context.Context, symbol Symbol) ([]Split, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.splits[fakeKey(symbol)], nil
+}
+
+// SearchSymbols lists the quotes set on the fake whose symbol starts with, or
+// whose name contains, query.
+func (f *Fake) SearchSymbols(ctx context.Context, query string) ([]Listing, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	var result []Listing
+	for _, q := range f.quotes {
+		if matchesQuery(q.Symbol, q.Name, query) {
+			result = append(result, Listing{Symbol: q.Symbol, Exchange: q.Exchange, Name: q.Name, Currency: q.Currency})
+		}
+	}
+	return result, nil
+}
+
+func fakeKey(s Symbol) string {
+	return s.Symbol + ":" + NormalizeExchange(s.Exchange)
+}
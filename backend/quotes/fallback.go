@@ -0,0 +1,90 @@
+// /backend/quotes/fallback.go
+
+package quotes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Fallback tries each provider in order. When a provider fails, the symbols it
+// did not resolve are handed to the next one; a provider that answers without
+// error ends the chain even if some symbols were unknown to it.
+type Fallback struct {
+	Providers []QuoteProvider
+}
+
+func NewFallback(providers ...QuoteProvider) *Fallback {
+	return &Fallback{Providers: providers}
+}
+
+func (f *Fallback) Name() string {
+	if len(f.Providers) == 0 {
+		return "fallback"
+	}
+	return f.Providers[0].Name()
+}
+
+func (f *Fallback) Capabilities() Capabilities {
+	if len(f.Providers) == 0 {
+		return Capabilities{}
+	}
+	return f.Providers[0].Capabilities()
+}
+
+func (f *Fallback) FetchQuotes(ctx context.Context, symbols []Symbol) ([]Quote, error) {
+	if len(f.Providers) == 0 {
+		return nil, errors.New("no quote provider configured")
+	}
+
+	var (
+		result    []Quote
+		errs      []error
+		remaining = symbols
+	)
+	for _, provider := range f.Providers {
+		if len(remaining) == 0 {
+			break
+		}
+
+		fetched, err := provider.FetchQuotes(ctx, remaining)
+		for _, q := range fetched {
+			if q.Source == "" {
+				q.Source = provider.Name()
+			}
+			result = append(result, q)
+		}
+		if err == nil {
+			return result, nil
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+		remaining = Unresolved(remaining, fetched)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(remaining) == 0 {
+		return result, nil
+	}
+	return result, errors.Join(errs...)
+}
+
+// Unresolved returns the symbols none of the fetched quotes answer, however
+// either side spells the listing.
+func Unresolved(symbols []Symbol, fetched []Quote) []Symbol {
+	done := make(map[string]bool, len(fetched))
+	for _, q := range fetched {
+		done[Symbol{Symbol: q.Symbol, Exchange: q.Exchange}.Key()] = true
+	}
+
+	var rest []Symbol
+	for _, s := range symbols {
+		if !done[s.Key()] {
+			rest = append(rest, s)
+		}
+	}
+	return rest
+}
@@ -0,0 +1,27 @@
+// /backend/quotes/fallback_test.go
+
+package quotes
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnresolved(t *testing.T) {
+	symbols := []Symbol{
+		{Symbol: "AAPL", Exchange: "NASDAQ"},
+		{Symbol: "shel", Exchange: "LSE"},
+		{Symbol: "SAP", Exchange: "XETR"},
+	}
+	fetched := []Quote{
+		{Symbol: "aapl", Exchange: "XNAS"},
+		{Symbol: "SHEL", Exchange: "XLON"},
+		{Symbol: "SAP", Exchange: "XAMS"},
+	}
+
+	got := Unresolved(symbols, fetched)
+	want := []Symbol{{Symbol: "SAP", Exchange: "XETR"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Unresolved = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,244 @@
+// /backend/quotes/file.go
+
+package quotes
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FileName    = "file"
+	anyExchange = "*"
+)
+
+var fileTimestampLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"}
+
+// File serves quotes from a local price file so the backend can run without
+// network access. The file is either CSV with a header row or a JSON array,
+// both using the fields symbol, exchange, price, timestamp and optionally
+// name and currency. It is re-read whenever its size or mtime changes.
+type File struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	quotes  map[string]Quote
+}
+
+type fileQuote struct {
+	Symbol    string  `json:"symbol"`
+	Exchange  string  `json:"exchange"`
+	Name      string  `json:"name"`
+	Currency  string  `json:"currency"`
+	Price     float64 `json:"price"`
+	Timestamp string  `json:"timestamp"`
+}
+
+func NewFile(path string) *File {
+	return &File{Path: path}
+}
+
+func (f *File) Name() string {
+	return FileName
+}
+
+func (f *File) Capabilities() Capabilities {
+	return Capabilities{SupportsExchange: true}
+}
+
+func (f *File) FetchQuotes(ctx context.Context, symbols []Symbol) ([]Quote, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	var result []Quote
+	for _, s := range symbols {
+		q, ok := f.quotes[fileKey(s.Symbol, s.Exchange)]
+		if !ok {
+			q, ok = f.quotes[fileKey(s.Symbol, "")]
+		}
+		if !ok && s.Exchange == "" {
+			q, ok = f.quotes[fileKey(s.Symbol, anyExchange)]
+		}
+		if ok {
+			q.Symbol = s.Symbol
+			q.Exchange = s.Exchange
+			result = append(result, q)
+		}
+	}
+	return result, nil
+}
+
+// SearchSymbols lists the entries of the price file whose symbol starts with,
+// or whose name contains, query.
+func (f *File) SearchSymbols(ctx context.Context, query string) ([]Listing, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	var result []Listing
+	for key, q := range f.quotes {
+		// Skip the aliases added for requests without an exchange.
+		if key != fileKey(q.Symbol, q.Exchange) {
+			continue
+		}
+		if matchesQuery(q.Symbol, q.Name, query) {
+			result = append(result, Listing{Symbol: q.Symbol, Exchange: q.Exchange, Name: q.Name, Currency: q.Currency})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Symbol != result[j].Symbol {
+			return result[i].Symbol < result[j].Symbol
+		}
+		return result[i].Exchange < result[j].Exchange
+	})
+	return result, nil
+}
+
+func (f *File) reloadIfChanged() error {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return fmt.Errorf("error reading quotes file: %v", err)
+	}
+	if f.quotes != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return fmt.Errorf("error reading quotes file: %v", err)
+	}
+	defer file.Close()
+
+	var entries []fileQuote
+	if strings.EqualFold(filepath.Ext(f.Path), ".json") {
+		if err := json.NewDecoder(file).Decode(&entries); err != nil {
+			return fmt.Errorf("error parsing quotes file: %v", err)
+		}
+	} else {
+		if entries, err = readFileQuotesCSV(file); err != nil {
+			return fmt.Errorf("error parsing quotes file: %v", err)
+		}
+	}
+
+	quotes := make(map[string]Quote, len(entries))
+	for _, e := range entries {
+		q := Quote{
+			Symbol:    e.Symbol,
+			Exchange:  e.Exchange,
+			Name:      e.Name,
+			Currency:  e.Currency,
+			Price:     e.Price,
+			Timestamp: info.ModTime().UTC(),
+			Source:    FileName,
+		}
+		if e.Timestamp != "" {
+			ts, err := parseFileTimestamp(e.Timestamp)
+			if err != nil {
+				return fmt.Errorf("error parsing quotes file: %s: %v", e.Symbol, err)
+			}
+			q.Timestamp = ts
+		}
+		quotes[fileKey(e.Symbol, e.Exchange)] = q
+	}
+	// Requests without an exchange fall back to any listing of the symbol.
+	// Entries without an exchange already serve requests for any venue.
+	for _, e := range entries {
+		if _, ok := quotes[fileKey(e.Symbol, anyExchange)]; !ok {
+			quotes[fileKey(e.Symbol, anyExchange)] = quotes[fileKey(e.Symbol, e.Exchange)]
+		}
+	}
+
+	f.quotes = quotes
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return nil
+}
+
+func readFileQuotesCSV(r io.Reader) ([]fileQuote, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["symbol"]; !ok {
+		return nil, fmt.Errorf("missing symbol column")
+	}
+	if _, ok := columns["price"]; !ok {
+		return nil, fmt.Errorf("missing price column")
+	}
+
+	field := func(record []string, name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var entries []fileQuote
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		price, err := strconv.ParseFloat(field(record, "price"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price for %s: %v", field(record, "symbol"), err)
+		}
+		entries = append(entries, fileQuote{
+			Symbol:    field(record, "symbol"),
+			Exchange:  field(record, "exchange"),
+			Name:      field(record, "name"),
+			Currency:  field(record, "currency"),
+			Price:     price,
+			Timestamp: field(record, "timestamp"),
+		})
+	}
+	return entries, nil
+}
+
+func parseFileTimestamp(value string) (time.Time, error) {
+	for _, layout := range fileTimestampLayouts {
+		if ts, err := time.Parse(layout, value); err == nil {
+			return ts.UTC(), nil
+		}
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(unix, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", value)
+}
+
+func fileKey(symbol, exchange string) string {
+	return strings.ToUpper(symbol) + ":" + NormalizeExchange(exchange)
+}
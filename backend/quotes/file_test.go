@@ -0,0 +1,148 @@
+// /backend/quotes/file_test.go
+
+package quotes
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeQuotesFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("writing %s: %v", name, err)
+	}
+	return path
+}
+
+func TestFileFetchQuotes(t *testing.T) {
+	path := writeQuotesFile(t, "quotes.csv", `symbol,exchange,name,currency,price,timestamp
+AAPL,NASDAQ,Apple Inc.,USD,190.5,2024-03-15T20:00:00Z
+SHEL,LSE,Shell plc,GBX,2650.5,2024-03-15 16:30:00
+SHEL,XAMS,Shell plc,EUR,31.2,1710520200
+BTC/USD,,Bitcoin,USD,67000,2024-03-15
+`)
+	f := NewFile(path)
+
+	tests := []struct {
+		name      string
+		symbol    Symbol
+		wantPrice float64
+		wantOK    bool
+	}{
+		{name: "exact listing", symbol: Symbol{Symbol: "AAPL", Exchange: "NASDAQ"}, wantPrice: 190.5, wantOK: true},
+		{name: "exchange given by MIC", symbol: Symbol{Symbol: "SHEL", Exchange: "XLON"}, wantPrice: 2650.5, wantOK: true},
+		{name: "lower case symbol", symbol: Symbol{Symbol: "shel", Exchange: "XAMS"}, wantPrice: 31.2, wantOK: true},
+		{name: "no exchange takes first listing", symbol: Symbol{Symbol: "SHEL"}, wantPrice: 2650.5, wantOK: true},
+		{name: "entry without exchange serves any venue", symbol: Symbol{Symbol: "BTC/USD", Exchange: "CRYPTO"}, wantPrice: 67000, wantOK: true},
+		{name: "other venue is not substituted", symbol: Symbol{Symbol: "AAPL", Exchange: "XETR"}},
+		{name: "unknown symbol", symbol: Symbol{Symbol: "MSFT", Exchange: "NASDAQ"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := f.FetchQuotes(context.Background(), []Symbol{tt.symbol})
+			if err != nil {
+				t.Fatalf("FetchQuotes: %v", err)
+			}
+			if !tt.wantOK {
+				if len(got) != 0 {
+					t.Errorf("got quotes %+v, want none", got)
+				}
+				return
+			}
+			if len(got) != 1 {
+				t.Fatalf("got %d quotes, want 1", len(got))
+			}
+			if got[0].Price != tt.wantPrice {
+				t.Errorf("price = %v, want %v", got[0].Price, tt.wantPrice)
+			}
+			if got[0].Symbol != tt.symbol.Symbol || got[0].Exchange != tt.symbol.Exchange {
+				t.Errorf("quote is for %s:%s, want the requested %s:%s", got[0].Symbol, got[0].Exchange, tt.symbol.Symbol, tt.symbol.Exchange)
+			}
+			if got[0].Source != FileName {
+				t.Errorf("source = %q, want %q", got[0].Source, FileName)
+			}
+		})
+	}
+}
+
+func TestFileTimestamps(t *testing.T) {
+	path := writeQuotesFile(t, "quotes.json", `[
+		{"symbol": "AAPL", "exchange": "NASDAQ", "price": 190.5, "timestamp": "2024-03-15T20:00:00Z"},
+		{"symbol": "MSFT", "exchange": "NASDAQ", "price": 416.42, "timestamp": "1710532800"},
+		{"symbol": "IBM", "exchange": "NYSE", "price": 191.07}
+	]`)
+	modTime := time.Date(2024, 3, 16, 8, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("setting mtime: %v", err)
+	}
+
+	got, err := NewFile(path).FetchQuotes(context.Background(), []Symbol{
+		{Symbol: "AAPL", Exchange: "NASDAQ"},
+		{Symbol: "MSFT", Exchange: "NASDAQ"},
+		{Symbol: "IBM", Exchange: "NYSE"},
+	})
+	if err != nil {
+		t.Fatalf("FetchQuotes: %v", err)
+	}
+	want := []time.Time{
+		time.Date(2024, 3, 15, 20, 0, 0, 0, time.UTC),
+		time.Unix(1710532800, 0).UTC(),
+		modTime,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d quotes, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !got[i].Timestamp.Equal(want[i]) {
+			t.Errorf("%s timestamp = %v, want %v", got[i].Symbol, got[i].Timestamp, want[i])
+		}
+	}
+}
+
+func TestFileReloadsWhenChanged(t *testing.T) {
+	path := writeQuotesFile(t, "quotes.csv", "symbol,exchange,price\nAAPL,NASDAQ,190.5\n")
+	f := NewFile(path)
+	symbols := []Symbol{{Symbol: "AAPL", Exchange: "NASDAQ"}}
+
+	if got, err := f.FetchQuotes(context.Background(), symbols); err != nil || len(got) != 1 || got[0].Price != 190.5 {
+		t.Fatalf("FetchQuotes = %+v, %v, want price 190.5", got, err)
+	}
+
+	if err := os.WriteFile(path, []byte("symbol,exchange,price\nAAPL,NASDAQ,172.25\n"), 0o644); err != nil {
+		t.Fatalf("rewriting quotes file: %v", err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatalf("setting mtime: %v", err)
+	}
+
+	if got, err := f.FetchQuotes(context.Background(), symbols); err != nil || len(got) != 1 || got[0].Price != 172.25 {
+		t.Fatalf("FetchQuotes after change = %+v, %v, want price 172.25", got, err)
+	}
+}
+
+func TestFileInvalid(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+	}{
+		{name: "missing price column", file: "quotes.csv", content: "symbol,exchange\nAAPL,NASDAQ\n"},
+		{name: "invalid price", file: "quotes.csv", content: "symbol,price\nAAPL,abc\n"},
+		{name: "invalid timestamp", file: "quotes.csv", content: "symbol,price,timestamp\nAAPL,190.5,yesterday\n"},
+		{name: "invalid json", file: "quotes.json", content: `{"symbol": "AAPL"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeQuotesFile(t, tt.file, tt.content)
+			if _, err := NewFile(path).FetchQuotes(context.Background(), []Symbol{{Symbol: "AAPL"}}); err == nil {
+				t.Error("FetchQuotes succeeded, want a parse error")
+			}
+		})
+	}
+}
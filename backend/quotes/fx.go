@@ -0,0 +1,63 @@
+// /backend/quotes/fx.go
+
+package quotes
+
+import "strings"
+
+// FXExchange is the venue currency pairs such as EUR/USD are quoted under.
+// The price of a pair is how many units of the quote currency one unit of
+// the base currency buys.
+const FXExchange = "FX"
+
+// minorUnits are currencies that some exchanges quote prices in, mapped to
+// the currency they are a fraction of. The LSE quotes most shares in pence.
+var minorUnits = map[string]struct {
+	Major  string
+	Factor float64
+}{
+	"GBX": {"GBP", 0.01},
+	"ZAC": {"ZAR", 0.01},
+	"ILA": {"ILS", 0.01},
+}
+
+// FXSymbol is the pair that converts from into to.
+func FXSymbol(from, to string) Symbol {
+	return Symbol{Symbol: from + "/" + to, Exchange: FXExchange}
+}
+
+// IsPair reports whether s is a currency or crypto pair in BASE/QUOTE
+// notation rather than a listed security.
+func (s Symbol) IsPair() bool {
+	exchange := NormalizeExchange(s.Exchange)
+	return exchange == FXExchange || exchange == CryptoExchange
+}
+
+// NormalizeCurrency upper-cases a currency code. The mixed-case codes used
+// for minor units, such as GBp for pence, are mapped to their upper-case
+// equivalents first so that they are not mistaken for the major currency.
+func NormalizeCurrency(code string) string {
+	code = strings.TrimSpace(code)
+	switch code {
+	case "GBp":
+		return "GBX"
+	case "ZAc":
+		return "ZAC"
+	}
+	return strings.ToUpper(code)
+}
+
+// IsCurrencyCode reports whether code looks like an ISO 4217 code or a coin
+// ticker.
+func IsCurrencyCode(code string) bool {
+	return isCurrencyCode(NormalizeCurrency(code))
+}
+
+// MajorCurrency returns the currency FX rates are quoted for and the factor
+// that converts an amount in code into it, e.g. GBP and 0.01 for GBX.
+func MajorCurrency(code string) (string, float64) {
+	code = NormalizeCurrency(code)
+	if minor, ok := minorUnits[code]; ok {
+		return minor.Major, minor.Factor
+	}
+	return code, 1
+}
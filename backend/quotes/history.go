@@ -0,0 +1,52 @@
+// /backend/quotes/history.go
+
+package quotes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrHistoryUnsupported = errors.New("provider does not support price history")
+
+// Candle is one day of trading. Time is midnight UTC of the trading day.
+type Candle struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+}
+
+// HistoryProvider is implemented by providers that can return daily OHLCV
+// candles. from and to are inclusive trading days.
+type HistoryProvider interface {
+	FetchDailyCandles(ctx context.Context, symbol Symbol, from, to time.Time) ([]Candle, error)
+}
+
+// FetchDailyCandles asks provider for candles if it supports history.
+func FetchDailyCandles(ctx context.Context, provider QuoteProvider, symbol Symbol, from, to time.Time) ([]Candle, error) {
+	history, ok := provider.(HistoryProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", provider.Name(), ErrHistoryUnsupported)
+	}
+	return history.FetchDailyCandles(ctx, symbol, from, to)
+}
+
+func (f *Fallback) FetchDailyCandles(ctx context.Context, symbol Symbol, from, to time.Time) ([]Candle, error) {
+	var errs []error
+	for _, provider := range f.Providers {
+		candles, err := FetchDailyCandles(ctx, provider, symbol, from, to)
+		if err == nil {
+			return candles, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no quote provider configured")
+	}
+	return nil, errors.Join(errs...)
+}
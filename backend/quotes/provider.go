@@ -0,0 +1,105 @@
+// /backend/quotes/provider.go
+
+package quotes
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidAPIKey    = errors.New("invalid API key")
+	ErrCreditsExhausted = errors.New("API credits exhausted")
+	ErrSymbolNotFound   = errors.New("symbol not found")
+)
+
+// Symbol identifies an instrument on a given exchange. Exchange may be empty
+// when the provider should pick its default listing.
+type Symbol struct {
+	Symbol   string
+	Exchange string
+}
+
+type Quote struct {
+	Symbol    string
+	Exchange  string
+	Name      string
+	Currency  string
+	Price     float64
+	Timestamp time.Time
+	Source    string
+}
+
+// Capabilities describes what a provider supports and how much it may be
+// called. Zero limits mean "unlimited".
+type Capabilities struct {
+	MaxSymbolsPerRequest int
+	CreditsPerMinute     int
+	CreditsPerDay        int
+	SupportsExchange     bool
+	// SplitCredits is what one split history request costs, where it costs
+	// more than the single credit of a quote.
+	SplitCredits int
+}
+
+// SplitCost returns the credits one split history request spends.
+func (c Capabilities) SplitCost() int {
+	if c.SplitCredits > 0 {
+		return c.SplitCredits
+	}
+	return 1
+}
+
+// QuoteProvider is implemented by every market data source. FetchQuotes
+// returns the quotes it could resolve; symbols it could not resolve are
+// simply missing from the result. A provider that fails part way through may
+// return the quotes fetched so far together with the error.
+type QuoteProvider interface {
+	Name() string
+	Capabilities() Capabilities
+	FetchQuotes(ctx context.Context, symbols []Symbol) ([]Quote, error)
+}
+
+func (s Symbol) String() string {
+	if s.Exchange == "" {
+		return s.Symbol
+	}
+	return s.Symbol + ":" + s.Exchange
+}
+
+// Normalize returns s in the form instruments are stored in: the symbol
+// upper-cased and the exchange replaced by the MIC of the venue it names.
+func (s Symbol) Normalize() Symbol {
+	return Symbol{Symbol: strings.ToUpper(strings.TrimSpace(s.Symbol)), Exchange: NormalizeExchange(s.Exchange)}
+}
+
+// Key identifies the listing s names regardless of how its symbol and
+// exchange are spelled, so that AAPL on NASDAQ and aapl on XNAS share one.
+func (s Symbol) Key() string {
+	n := s.Normalize()
+	return n.Symbol + ":" + n.Exchange
+}
+
+// GroupByExchange splits symbols by venue, preserving their order, for
+// providers that take the exchange as a request parameter.
+func GroupByExchange(symbols []Symbol) [][]Symbol {
+	var (
+		order  []string
+		groups = make(map[string][]Symbol)
+	)
+	for _, s := range symbols {
+		key := NormalizeExchange(s.Exchange)
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], s)
+	}
+
+	result := make([][]Symbol, 0, len(order))
+	for _, key := range order {
+		result = append(result, groups[key])
+	}
+	return result
+}
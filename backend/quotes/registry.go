@@ -0,0 +1,56 @@
+// /backend/quotes/registry.go
+
+package quotes
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory builds a provider from the credential a user stored for it.
+type Factory func(apiKey string) QuoteProvider
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{
+		TwelveDataName:       func(apiKey string) QuoteProvider { return NewTwelveData(apiKey) },
+		AlphaVantageName:     func(apiKey string) QuoteProvider { return NewAlphaVantage(apiKey) },
+		AlphaVantageBulkName: func(apiKey string) QuoteProvider { return NewAlphaVantageBulk(apiKey) },
+	}
+)
+
+// Register adds or replaces the factory used for the given provider name.
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+func New(name, apiKey string) (QuoteProvider, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown quote provider %q", name)
+	}
+	return factory(apiKey), nil
+}
+
+func IsRegistered(name string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	_, ok := registry[name]
+	return ok
+}
+
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,84 @@
+// /backend/quotes/search.go
+
+package quotes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrSearchUnsupported = errors.New("provider does not support symbol search")
+
+// Listing is one security a provider knows about. Exchange is empty when the
+// provider only addresses the listing by its bare symbol, as Alpha Vantage
+// does for US venues.
+type Listing struct {
+	Symbol   string
+	Exchange string
+	MIC      string
+	Name     string
+	Currency string
+	Type     string
+	Country  string
+}
+
+// Matches reports whether s refers to this listing. A listing without an
+// exchange matches any venue that is addressed without a suffix.
+func (l Listing) Matches(s Symbol) bool {
+	if !strings.EqualFold(l.Symbol, s.Symbol) {
+		return false
+	}
+	if s.Exchange == "" {
+		return true
+	}
+	if l.Exchange == "" {
+		e, ok := LookupExchange(s.Exchange)
+		return ok && e.AlphaVantageSuffix == ""
+	}
+	if l.MIC != "" && l.MIC == NormalizeExchange(s.Exchange) {
+		return true
+	}
+	return NormalizeExchange(l.Exchange) == NormalizeExchange(s.Exchange)
+}
+
+// SymbolSearcher is implemented by providers that can look up listings by a
+// partial symbol or company name.
+type SymbolSearcher interface {
+	SearchSymbols(ctx context.Context, query string) ([]Listing, error)
+}
+
+// SearchSymbols asks provider for listings if it supports search.
+func SearchSymbols(ctx context.Context, provider QuoteProvider, query string) ([]Listing, error) {
+	searcher, ok := provider.(SymbolSearcher)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", provider.Name(), ErrSearchUnsupported)
+	}
+	return searcher.SearchSymbols(ctx, query)
+}
+
+func (f *Fallback) SearchSymbols(ctx context.Context, query string) ([]Listing, error) {
+	var errs []error
+	for _, provider := range f.Providers {
+		listings, err := SearchSymbols(ctx, provider, query)
+		if err == nil {
+			return listings, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no quote provider configured")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// matchesQuery is the search used by providers that hold their listings in
+// memory: a symbol prefix or a substring of the name.
+func matchesQuery(symbol, name, query string) bool {
+	query = strings.ToUpper(strings.TrimSpace(query))
+	if query == "" {
+		return false
+	}
+	return strings.HasPrefix(strings.ToUpper(symbol), query) || strings.Contains(strings.ToUpper(name), query)
+}
@@ -0,0 +1,50 @@
+// /backend/quotes/splits.go
+
+package quotes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrSplitsUnsupported = errors.New("provider does not support split history")
+
+// Split is a stock split taking effect at the open of Date, midnight UTC.
+// Ratio is the number of new shares per old share: 4 for a 4-for-1 split,
+// 0.1 for a 1-for-10 reverse split.
+type Split struct {
+	Date  time.Time
+	Ratio float64
+}
+
+// SplitProvider is implemented by providers that know the split history of
+// a listing.
+type SplitProvider interface {
+	FetchSplits(ctx context.Context, symbol Symbol) ([]Split, error)
+}
+
+// FetchSplits asks provider for the splits of symbol if it knows them.
+func FetchSplits(ctx context.Context, provider QuoteProvider, symbol Symbol) ([]Split, error) {
+	splits, ok := provider.(SplitProvider)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", provider.Name(), ErrSplitsUnsupported)
+	}
+	return splits.FetchSplits(ctx, symbol)
+}
+
+func (f *Fallback) FetchSplits(ctx context.Context, symbol Symbol) ([]Split, error) {
+	var errs []error
+	for _, provider := range f.Providers {
+		splits, err := FetchSplits(ctx, provider, symbol)
+		if err == nil {
+			return splits, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no quote provider configured")
+	}
+	return nil, errors.Join(errs...)
+}
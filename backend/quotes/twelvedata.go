@@ -0,0 +1,389 @@
+// /backend/quotes/twelvedata.go
+
+package quotes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TwelveDataName    = "twelvedata"
+	twelveDataBaseURL = "https://api.twelvedata.com"
+)
+
+type TwelveData struct {
+	APIKey  string
+	BaseURL string
+	Client  *http.Client
+}
+
+type twelveDataQuote struct {
+	Symbol        string `json:"symbol"`
+	Name          string `json:"name"`
+	Exchange      string `json:"exchange"`
+	Currency      string `json:"currency"`
+	CurrencyQuote string `json:"currency_quote"`
+	Close         string `json:"close"`
+	Timestamp     int64  `json:"timestamp"`
+	Code          int    `json:"code"`
+	Message       string `json:"message"`
+	Status        string `json:"status"`
+}
+
+func NewTwelveData(apiKey string) *TwelveData {
+	return &TwelveData{
+		APIKey:  apiKey,
+		BaseURL: twelveDataBaseURL,
+		Client:  &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (t *TwelveData) Name() string {
+	return TwelveDataName
+}
+
+func (t *TwelveData) Capabilities() Capabilities {
+	return Capabilities{
+		MaxSymbolsPerRequest: 8,
+		CreditsPerMinute:     8,
+		CreditsPerDay:        800,
+		SupportsExchange:     true,
+		// /splits is priced as a fundamentals endpoint.
+		SplitCredits: 20,
+	}
+}
+
+// FetchQuotes sends one /quote request per exchange, since Twelve Data takes
+// the venue as a request parameter rather than per symbol.
+func (t *TwelveData) FetchQuotes(ctx context.Context, symbols []Symbol) ([]Quote, error) {
+	var quotes []Quote
+	for _, group := range GroupByExchange(symbols) {
+		fetched, err := t.fetchExchange(ctx, group)
+		quotes = append(quotes, fetched...)
+		if err != nil {
+			return quotes, err
+		}
+	}
+	return quotes, nil
+}
+
+func (t *TwelveData) fetchExchange(ctx context.Context, symbols []Symbol) ([]Quote, error) {
+	tags := make([]string, 0, len(symbols))
+	for _, s := range symbols {
+		tags = append(tags, s.Symbol)
+	}
+
+	params := url.Values{}
+	params.Set("symbol", strings.Join(tags, ","))
+	params.Set("apikey", t.APIKey)
+	setTwelveDataExchange(params, symbols[0].Exchange)
+
+	bodyBytes, err := t.get(ctx, "/quote", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var quotes []Quote
+	if len(symbols) == 1 {
+		var raw twelveDataQuote
+		if err := json.Unmarshal(bodyBytes, &raw); err != nil {
+			return nil, fmt.Errorf("JSON Decode error: %v", err)
+		}
+		if err := twelveDataError(raw); err != nil {
+			return nil, err
+		}
+		if quote, ok := raw.toQuote(symbols[0]); ok {
+			quotes = append(quotes, quote)
+		}
+		return quotes, nil
+	}
+
+	// A batch answer is keyed by symbol, but a request that fails as a whole
+	// comes back as a single error object instead.
+	var topLevel twelveDataQuote
+	if err := json.Unmarshal(bodyBytes, &topLevel); err == nil {
+		if err := twelveDataError(topLevel); err != nil {
+			return nil, err
+		}
+	}
+
+	var response map[string]twelveDataQuote
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, fmt.Errorf("JSON Decode error: %v", err)
+	}
+
+	for _, requested := range symbols {
+		raw, ok := response[requested.Symbol]
+		if !ok || raw.Status == "error" {
+			continue
+		}
+		if quote, ok := raw.toQuote(requested); ok {
+			quotes = append(quotes, quote)
+		}
+	}
+	return quotes, nil
+}
+
+// setTwelveDataExchange prefers the MIC for venues we know and passes other
+// exchange names through unchanged. Currency and crypto pairs are quoted
+// without one, which gives the aggregated market rate.
+func setTwelveDataExchange(params url.Values, exchange string) {
+	if exchange == "" || (Symbol{Exchange: exchange}).IsPair() {
+		return
+	}
+	if e, ok := LookupExchange(exchange); ok {
+		params.Set("mic_code", e.MIC)
+		return
+	}
+	params.Set("exchange", exchange)
+}
+
+type twelveDataTimeSeries struct {
+	Values []struct {
+		Datetime string `json:"datetime"`
+		Open     string `json:"open"`
+		High     string `json:"high"`
+		Low      string `json:"low"`
+		Close    string `json:"close"`
+		Volume   string `json:"volume"`
+	} `json:"values"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// FetchDailyCandles reads /time_series at a daily interval. Twelve Data caps
+// a response at 5000 values, which is roughly twenty years of trading days.
+func (t *TwelveData) FetchDailyCandles(ctx context.Context, symbol Symbol, from, to time.Time) ([]Candle, error) {
+	params := url.Values{}
+	params.Set("symbol", symbol.Symbol)
+	params.Set("interval", "1day")
+	params.Set("start_date", from.Format("2006-01-02"))
+	params.Set("end_date", to.Format("2006-01-02"))
+	params.Set("outputsize", "5000")
+	params.Set("order", "ASC")
+	params.Set("apikey", t.APIKey)
+	setTwelveDataExchange(params, symbol.Exchange)
+
+	bodyBytes, err := t.get(ctx, "/time_series", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var response twelveDataTimeSeries
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, fmt.Errorf("JSON Decode error: %v", err)
+	}
+	if err := twelveDataError(twelveDataQuote{Code: response.Code, Message: response.Message, Status: response.Status}); err != nil {
+		// Twelve Data reports an empty range as an error; it just means
+		// there was no trading in it.
+		if errors.Is(err, ErrSymbolNotFound) && strings.Contains(strings.ToLower(response.Message), "no data") {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	candles := make([]Candle, 0, len(response.Values))
+	for _, v := range response.Values {
+		day, err := time.Parse("2006-01-02", v.Datetime)
+		if err != nil {
+			continue
+		}
+		candle := Candle{Time: day}
+		if candle.Close, err = strconv.ParseFloat(v.Close, 64); err != nil {
+			continue
+		}
+		candle.Open, _ = strconv.ParseFloat(v.Open, 64)
+		candle.High, _ = strconv.ParseFloat(v.High, 64)
+		candle.Low, _ = strconv.ParseFloat(v.Low, 64)
+		candle.Volume, _ = strconv.ParseFloat(v.Volume, 64)
+		candles = append(candles, candle)
+	}
+	return candles, nil
+}
+
+type twelveDataSplits struct {
+	Splits []struct {
+		Date       string  `json:"date"`
+		Ratio      float64 `json:"ratio"`
+		FromFactor float64 `json:"from_factor"`
+		ToFactor   float64 `json:"to_factor"`
+	} `json:"splits"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// FetchSplits reads /splits over the full history of the listing. A 4-for-1
+// split is reported with a from_factor of 4 and a to_factor of 1.
+func (t *TwelveData) FetchSplits(ctx context.Context, symbol Symbol) ([]Split, error) {
+	params := url.Values{}
+	params.Set("symbol", symbol.Symbol)
+	params.Set("range", "full")
+	params.Set("apikey", t.APIKey)
+	setTwelveDataExchange(params, symbol.Exchange)
+
+	bodyBytes, err := t.get(ctx, "/splits", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var response twelveDataSplits
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, fmt.Errorf("JSON Decode error: %v", err)
+	}
+	if err := twelveDataError(twelveDataQuote{Code: response.Code, Message: response.Message, Status: response.Status}); err != nil {
+		return nil, err
+	}
+
+	splits := make([]Split, 0, len(response.Splits))
+	for _, s := range response.Splits {
+		day, err := time.Parse("2006-01-02", s.Date)
+		if err != nil {
+			continue
+		}
+		split := Split{Date: day}
+		switch {
+		case s.FromFactor > 0 && s.ToFactor > 0:
+			split.Ratio = s.FromFactor / s.ToFactor
+		case s.Ratio > 0:
+			split.Ratio = 1 / s.Ratio
+		default:
+			continue
+		}
+		splits = append(splits, split)
+	}
+	sort.Slice(splits, func(i, j int) bool { return splits[i].Date.Before(splits[j].Date) })
+	return splits, nil
+}
+
+type twelveDataSymbolSearch struct {
+	Data []struct {
+		Symbol         string `json:"symbol"`
+		InstrumentName string `json:"instrument_name"`
+		Exchange       string `json:"exchange"`
+		MICCode        string `json:"mic_code"`
+		InstrumentType string `json:"instrument_type"`
+		Country        string `json:"country"`
+		Currency       string `json:"currency"`
+	} `json:"data"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// SearchSymbols reads /symbol_search, which matches both symbols and
+// instrument names.
+func (t *TwelveData) SearchSymbols(ctx context.Context, query string) ([]Listing, error) {
+	params := url.Values{}
+	params.Set("symbol", query)
+	params.Set("outputsize", "30")
+	params.Set("apikey", t.APIKey)
+
+	bodyBytes, err := t.get(ctx, "/symbol_search", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var response twelveDataSymbolSearch
+	if err := json.Unmarshal(bodyBytes, &response); err != nil {
+		return nil, fmt.Errorf("JSON Decode error: %v", err)
+	}
+	if err := twelveDataError(twelveDataQuote{Code: response.Code, Message: response.Message, Status: response.Status}); err != nil {
+		return nil, err
+	}
+
+	listings := make([]Listing, 0, len(response.Data))
+	for _, d := range response.Data {
+		listings = append(listings, Listing{
+			Symbol:   d.Symbol,
+			Exchange: d.Exchange,
+			MIC:      d.MICCode,
+			Name:     d.InstrumentName,
+			Currency: d.Currency,
+			Type:     d.InstrumentType,
+			Country:  d.Country,
+		})
+	}
+	return listings, nil
+}
+
+func (t *TwelveData) get(ctx context.Context, path string, params url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.BaseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building request: %v", err)
+	}
+
+	resp, err := t.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, ErrCreditsExhausted
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API returned non-OK status: %s", resp.Status)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %v", err)
+	}
+	return bodyBytes, nil
+}
+
+func twelveDataError(raw twelveDataQuote) error {
+	if raw.Status != "error" {
+		return nil
+	}
+	switch raw.Code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrInvalidAPIKey, raw.Message)
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %s", ErrCreditsExhausted, raw.Message)
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrSymbolNotFound, raw.Message)
+	}
+	return fmt.Errorf("twelve data error %d: %s", raw.Code, raw.Message)
+}
+
+func (raw twelveDataQuote) toQuote(requested Symbol) (Quote, bool) {
+	price, err := strconv.ParseFloat(raw.Close, 64)
+	if err != nil {
+		return Quote{}, false
+	}
+
+	quote := Quote{
+		Symbol:   requested.Symbol,
+		Exchange: requested.Exchange,
+		Name:     raw.Name,
+		Currency: raw.Currency,
+		Price:    price,
+		Source:   TwelveDataName,
+	}
+	if quote.Exchange == "" {
+		quote.Exchange = raw.Exchange
+	}
+	// Crypto pairs report the currency they are priced in separately.
+	if quote.Currency == "" {
+		quote.Currency = raw.CurrencyQuote
+	}
+	if raw.Timestamp > 0 {
+		quote.Timestamp = time.Unix(raw.Timestamp, 0).UTC()
+	} else {
+		quote.Timestamp = time.Now().UTC()
+	}
+	return quote, true
+}
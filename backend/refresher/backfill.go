@@ -0,0 +1,163 @@
+// /backend/refresher/backfill.go
+
+package refresher
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"myinvestmap/quotes"
+	"sync"
+	"time"
+)
+
+const (
+	selectBackfillInstrumentSQL = `SELECT i.symbol, i.exchange, MIN(substr(a.tradedAt, 1, 10)), i.backfilledFrom, i.backfilledTo FROM instruments i JOIN assets a ON a.instrument_id = i.id WHERE i.id = ? GROUP BY i.id`
+	updateBackfilledRangeSQL    = `UPDATE instruments SET backfilledFrom = MIN(COALESCE(backfilledFrom, ?1), ?1), backfilledTo = MAX(COALESCE(backfilledTo, ?2), ?2) WHERE id = ?3`
+	selectUserInstrumentIDsSQL  = `SELECT DISTINCT instrument_id FROM assets WHERE user_id = ? AND instrument_id IS NOT NULL`
+	upsertCandleSQL             = `INSERT INTO price_candles (instrument_id, tradingDay, open, high, low, close, volume, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(instrument_id, tradingDay) DO UPDATE SET open = excluded.open, high = excluded.high, low = excluded.low, close = excluded.close, volume = excluded.volume, source = excluded.source`
+)
+
+// BackfillResult reports what a backfill stored for one instrument. From and
+// To are zero when the instrument was already complete.
+type BackfillResult struct {
+	Symbol  quotes.Symbol
+	From    time.Time
+	To      time.Time
+	Candles int
+	Err     error
+}
+
+var (
+	backfillMu      sync.Mutex
+	backfillRunning = make(map[int]bool)
+)
+
+// BackfillInstrument stores daily candles for an instrument from its earliest
+// transaction up to today. Days already requested are skipped, except the
+// most recent one, which may have been fetched while it was still trading.
+func BackfillInstrument(ctx context.Context, db *sql.DB, instrumentID, userID int) BackfillResult {
+	var (
+		result                   BackfillResult
+		earliest, covered, until sql.NullString
+	)
+	err := db.QueryRow(selectBackfillInstrumentSQL, instrumentID).Scan(&result.Symbol.Symbol, &result.Symbol.Exchange, &earliest, &covered, &until)
+	if err == sql.ErrNoRows {
+		result.Err = fmt.Errorf("instrument %d is not held by anyone", instrumentID)
+		return result
+	}
+	if err != nil {
+		result.Err = fmt.Errorf("error fetching instrument: %v", err)
+		return result
+	}
+
+	backfillMu.Lock()
+	if backfillRunning[instrumentID] {
+		backfillMu.Unlock()
+		result.Err = fmt.Errorf("backfill of %s already running", result.Symbol)
+		return result
+	}
+	backfillRunning[instrumentID] = true
+	backfillMu.Unlock()
+	defer func() {
+		backfillMu.Lock()
+		delete(backfillRunning, instrumentID)
+		backfillMu.Unlock()
+	}()
+
+	start, err := time.Parse("2006-01-02", earliest.String)
+	if err != nil {
+		result.Err = fmt.Errorf("error parsing earliest trade date %q: %v", earliest.String, err)
+		return result
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	type dayRange struct{ from, to time.Time }
+	var ranges []dayRange
+	if !covered.Valid {
+		ranges = append(ranges, dayRange{start, today})
+	} else {
+		first, _ := time.Parse("2006-01-02", covered.String)
+		last, _ := time.Parse("2006-01-02", until.String)
+		if start.Before(first) {
+			ranges = append(ranges, dayRange{start, first.AddDate(0, 0, -1)})
+		}
+		ranges = append(ranges, dayRange{last, today})
+	}
+
+	provider, err := ProviderFor(db, userID)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	for _, r := range ranges {
+		if r.from.After(r.to) {
+			continue
+		}
+		if Limits.Remaining(userID, provider.Name(), provider.Capabilities()) == 0 {
+			result.Err = fmt.Errorf("%w: backfill of %s will resume later", quotes.ErrCreditsExhausted, result.Symbol)
+			return result
+		}
+
+		candles, err := quotes.FetchDailyCandles(ctx, provider, result.Symbol, r.from, r.to)
+		Limits.Spend(userID, provider.Name(), 1)
+		if err != nil {
+			result.Err = fmt.Errorf("error fetching candles for %s: %w", result.Symbol, err)
+			return result
+		}
+
+		for _, c := range candles {
+			if _, err := db.Exec(upsertCandleSQL, instrumentID, c.Time.Format("2006-01-02"), c.Open, c.High, c.Low, c.Close, c.Volume, provider.Name()); err != nil {
+				result.Err = fmt.Errorf("error storing candle: %v", err)
+				return result
+			}
+		}
+		if _, err := db.Exec(updateBackfilledRangeSQL, r.from.Format("2006-01-02"), r.to.Format("2006-01-02"), instrumentID); err != nil {
+			result.Err = fmt.Errorf("error storing backfilled range: %v", err)
+			return result
+		}
+
+		if result.From.IsZero() || r.from.Before(result.From) {
+			result.From = r.from
+		}
+		if r.to.After(result.To) {
+			result.To = r.to
+		}
+		result.Candles += len(candles)
+	}
+	return result
+}
+
+// BackfillUser backfills every instrument the user holds. Instruments that no
+// longer fit the user's credits report ErrCreditsExhausted and resume on the
+// next run.
+func BackfillUser(ctx context.Context, db *sql.DB, userID int) ([]BackfillResult, error) {
+	rows, err := db.Query(selectUserInstrumentIDsSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching instruments: %v", err)
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning instrument: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching instruments: %v", err)
+	}
+
+	results := make([]BackfillResult, 0, len(ids))
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		results = append(results, BackfillInstrument(ctx, db, id, userID))
+	}
+	return results, nil
+}
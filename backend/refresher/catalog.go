@@ -0,0 +1,155 @@
+// /backend/refresher/catalog.go
+
+package refresher
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"myinvestmap/quotes"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	upsertListingSQL       = `INSERT INTO instruments (symbol, exchange, name, currency, mic, country, listedAt) VALUES (?1, ?2, NULLIF(?3, ''), NULLIF(?4, ''), NULLIF(?5, ''), NULLIF(?6, ''), CURRENT_TIMESTAMP) ON CONFLICT(symbol, exchange) DO UPDATE SET name = COALESCE(instruments.name, excluded.name), currency = COALESCE(instruments.currency, excluded.currency), mic = COALESCE(excluded.mic, instruments.mic), country = COALESCE(excluded.country, instruments.country), listedAt = CURRENT_TIMESTAMP, updatedAt = CURRENT_TIMESTAMP`
+	selectKnownListingsSQL = `SELECT symbol, exchange, COALESCE(mic, ''), COALESCE(name, ''), COALESCE(currency, ''), COALESCE(country, '') FROM instruments WHERE symbol = ? COLLATE NOCASE AND (listedAt IS NOT NULL OR lastPrice IS NOT NULL)`
+	searchCatalogSQL       = `SELECT symbol, exchange, COALESCE(mic, ''), COALESCE(name, ''), COALESCE(currency, ''), COALESCE(country, '') FROM instruments WHERE (listedAt IS NOT NULL OR lastPrice IS NOT NULL) AND (symbol LIKE ?1 || '%' OR name LIKE '%' || ?1 || '%') ORDER BY symbol, exchange LIMIT 30`
+)
+
+// ErrUnknownSymbol is returned by ValidateSymbol when no provider lists the
+// symbol on the requested exchange.
+var ErrUnknownSymbol = errors.New("unknown symbol")
+
+// searchResults keeps provider answers per query so that typing the same
+// prefix again does not spend credits.
+var searchResults = cache.New(time.Hour, 10*time.Minute)
+
+// SearchSymbols looks up listings matching query with the user's providers
+// and records them in the instrument catalog. When no provider can answer,
+// the catalog itself is searched instead.
+func SearchSymbols(ctx context.Context, db *sql.DB, query string, userID int) ([]quotes.Listing, error) {
+	key := strings.ToUpper(strings.TrimSpace(query))
+	if cached, ok := searchResults.Get(key); ok {
+		return cached.([]quotes.Listing), nil
+	}
+
+	listings, err := searchProviders(ctx, db, query, userID)
+	if err != nil {
+		log.Printf("Symbol search for %q served from the catalog: %v", query, err)
+		return searchCatalog(db, query)
+	}
+	searchResults.Set(key, listings, cache.DefaultExpiration)
+	return listings, nil
+}
+
+// ValidateSymbol checks that s is a listing known to the catalog or to the
+// user's providers and returns it. Errors other than ErrUnknownSymbol mean
+// the listing could not be checked.
+func ValidateSymbol(ctx context.Context, db *sql.DB, s quotes.Symbol, userID int) (quotes.Listing, error) {
+	known, err := queryListings(db, selectKnownListingsSQL, s.Symbol)
+	if err != nil {
+		return quotes.Listing{}, err
+	}
+	if listing, ok := findListing(known, s); ok {
+		return listing, nil
+	}
+
+	found, err := searchProviders(ctx, db, s.Symbol, userID)
+	if err != nil {
+		return quotes.Listing{}, fmt.Errorf("error looking up %s: %w", s, err)
+	}
+	if listing, ok := findListing(found, s); ok {
+		return listing, nil
+	}
+
+	var venues []string
+	for _, l := range append(known, found...) {
+		if strings.EqualFold(l.Symbol, s.Symbol) && l.Exchange != "" {
+			venues = append(venues, l.Exchange)
+		}
+	}
+	if s.Exchange == "" || len(venues) == 0 {
+		return quotes.Listing{}, fmt.Errorf("%w: no listing found for %s", ErrUnknownSymbol, s)
+	}
+	return quotes.Listing{}, fmt.Errorf("%w: %s is not listed on %s, try one of %s", ErrUnknownSymbol, s.Symbol, s.Exchange, strings.Join(uniqueVenues(venues), ", "))
+}
+
+func searchProviders(ctx context.Context, db *sql.DB, query string, userID int) ([]quotes.Listing, error) {
+	provider, err := ProviderFor(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if Limits.Remaining(userID, provider.Name(), provider.Capabilities()) == 0 {
+		return nil, quotes.ErrCreditsExhausted
+	}
+
+	listings, err := quotes.SearchSymbols(ctx, provider, query)
+	if errors.Is(err, quotes.ErrSearchUnsupported) {
+		return nil, err
+	}
+	Limits.Spend(userID, provider.Name(), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range listings {
+		// Stored normalized, like instruments created for trades.
+		listing := quotes.Symbol{Symbol: l.Symbol, Exchange: l.Exchange}.Normalize()
+		if _, err := db.Exec(upsertListingSQL, listing.Symbol, listing.Exchange, l.Name, l.Currency, l.MIC, l.Country); err != nil {
+			return nil, fmt.Errorf("error storing listing %s: %v", l.Symbol, err)
+		}
+	}
+	return listings, nil
+}
+
+func searchCatalog(db *sql.DB, query string) ([]quotes.Listing, error) {
+	return queryListings(db, searchCatalogSQL, strings.TrimSpace(query))
+}
+
+func queryListings(db *sql.DB, query string, args ...interface{}) ([]quotes.Listing, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying instrument catalog: %v", err)
+	}
+	defer rows.Close()
+
+	var listings []quotes.Listing
+	for rows.Next() {
+		var l quotes.Listing
+		if err := rows.Scan(&l.Symbol, &l.Exchange, &l.MIC, &l.Name, &l.Currency, &l.Country); err != nil {
+			return nil, fmt.Errorf("error scanning listing: %v", err)
+		}
+		listings = append(listings, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying instrument catalog: %v", err)
+	}
+	return listings, nil
+}
+
+func findListing(listings []quotes.Listing, s quotes.Symbol) (quotes.Listing, bool) {
+	for _, l := range listings {
+		if l.Matches(s) {
+			return l, true
+		}
+	}
+	return quotes.Listing{}, false
+}
+
+// uniqueVenues drops later spellings of an exchange already in the list.
+func uniqueVenues(exchanges []string) []string {
+	seen := make(map[string]bool, len(exchanges))
+	var result []string
+	for _, e := range exchanges {
+		if key := quotes.NormalizeExchange(e); !seen[key] {
+			seen[key] = true
+			result = append(result, e)
+		}
+	}
+	return result
+}
@@ -0,0 +1,386 @@
+// /backend/refresher/fx.go
+
+package refresher
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"myinvestmap/quotes"
+	"sort"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	upsertFXRateSQL      = `INSERT INTO fx_rates (base, quote, rateDate, rate, source, fetchedAt) VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP) ON CONFLICT(base, quote, rateDate) DO UPDATE SET rate = excluded.rate, source = excluded.source, fetchedAt = excluded.fetchedAt`
+	selectFXRateOnSQL    = `SELECT rate, rateDate, source FROM fx_rates WHERE base = ? AND quote = ? AND rateDate <= ? AND rateDate >= ? ORDER BY rateDate DESC LIMIT 1`
+	selectFXRateAfterSQL = `SELECT rate, rateDate, source FROM fx_rates WHERE base = ? AND quote = ? AND rateDate > ? ORDER BY rateDate ASC LIMIT 1`
+)
+
+// fxLookback is how far back the rate of an earlier day stands in for a day
+// without one, such as a weekend or a bank holiday.
+const fxLookback = 7 * 24 * time.Hour
+
+// fxCrossCurrency is the currency rates are converted through when a pair
+// is not quoted directly.
+const fxCrossCurrency = "USD"
+
+// ErrNoFXRate is returned when no rate for a currency pair is stored and
+// none can be fetched.
+var ErrNoFXRate = errors.New("no FX rate")
+
+// FXOffline serves FX rates when the user's providers cannot. main sets it
+// when FX_RATES_FILE is configured.
+var FXOffline quotes.QuoteProvider
+
+// fxMisses remembers pairs and days whose history could not be fetched, so
+// that totals over old trades do not ask the provider again on every request.
+var fxMisses = cache.New(time.Hour, 10*time.Minute)
+
+// FXRate converts one unit of a currency into another on Date.
+type FXRate struct {
+	Rate   float64
+	Date   time.Time
+	Source string
+}
+
+// FXRateOn returns the rate converting from into to on day; a zero day, or
+// one after today, asks for the current rate. Rates are read from fx_rates,
+// fetched with the user's providers followed by FXOffline when missing, and
+// when neither can answer the closest stored rate of any age is used. A past
+// day without any stored rate falls back to the current one. Pairs no source
+// quotes are converted through USD.
+func FXRateOn(ctx context.Context, db *sql.DB, userID int, from, to string, day time.Time) (FXRate, error) {
+	fromMajor, fromFactor := quotes.MajorCurrency(from)
+	toMajor, toFactor := quotes.MajorCurrency(to)
+	factor := fromFactor / toFactor
+
+	today := StartOfDay(time.Now())
+	if day.IsZero() || day.After(today) {
+		day = today
+	}
+	day = StartOfDay(day)
+	if fromMajor == toMajor {
+		return FXRate{Rate: factor, Date: day}, nil
+	}
+
+	rate, ok, err := lookupFXRate(ctx, db, userID, fromMajor, toMajor, day, today)
+	if err != nil {
+		return FXRate{}, err
+	}
+	if !ok && fromMajor != fxCrossCurrency && toMajor != fxCrossCurrency {
+		rate, ok, err = crossFXRate(ctx, db, userID, fromMajor, toMajor, day, today)
+		if err != nil {
+			return FXRate{}, err
+		}
+	}
+	if !ok {
+		return FXRate{}, fmt.Errorf("%w for %s/%s on %s", ErrNoFXRate, fromMajor, toMajor, day.Format("2006-01-02"))
+	}
+	rate.Rate *= factor
+	return rate, nil
+}
+
+func lookupFXRate(ctx context.Context, db *sql.DB, userID int, from, to string, day, today time.Time) (FXRate, bool, error) {
+	var (
+		rate FXRate
+		ok   bool
+		err  error
+	)
+	if day.Equal(today) {
+		rate, ok = currentFXRate(ctx, db, userID, from, to)
+	} else {
+		rate, ok, err = historicalFXRate(ctx, db, userID, from, to, day)
+		if err != nil {
+			return FXRate{}, false, err
+		}
+	}
+	if !ok {
+		if rate, ok, err = closestStoredFXRate(db, from, to, day); err != nil {
+			return FXRate{}, false, err
+		}
+	}
+	if !ok && !day.Equal(today) {
+		rate, ok = currentFXRate(ctx, db, userID, from, to)
+	}
+	return rate, ok, nil
+}
+
+// crossFXRate converts through fxCrossCurrency, for pairs such as GBP/EUR
+// that sources only quote against the dollar.
+func crossFXRate(ctx context.Context, db *sql.DB, userID int, from, to string, day, today time.Time) (FXRate, bool, error) {
+	first, ok, err := lookupFXRate(ctx, db, userID, from, fxCrossCurrency, day, today)
+	if !ok || err != nil {
+		return FXRate{}, false, err
+	}
+	second, ok, err := lookupFXRate(ctx, db, userID, fxCrossCurrency, to, day, today)
+	if !ok || err != nil {
+		return FXRate{}, false, err
+	}
+
+	rate := FXRate{Rate: first.Rate * second.Rate, Date: first.Date, Source: first.Source}
+	if second.Date.Before(rate.Date) {
+		rate.Date = second.Date
+	}
+	if second.Source != first.Source {
+		rate.Source = first.Source + "+" + second.Source
+	}
+	return rate, true, nil
+}
+
+// currentFXRate asks each source for the pair, and for its inverse when the
+// source does not quote the pair in that direction.
+func currentFXRate(ctx context.Context, db *sql.DB, userID int, from, to string) (FXRate, bool) {
+	for _, pair := range [][2]string{{from, to}, {to, from}} {
+		if cached, missing := Cache.Lookup([]quotes.Symbol{quotes.FXSymbol(pair[0], pair[1])}); len(missing) == 0 && len(cached) == 1 {
+			return invertFXRate(fxRateFromQuote(cached[0]), pair[0] != from), true
+		}
+	}
+
+	sources, err := fxSources(db, userID)
+	for _, src := range sources {
+		for _, pair := range [][2]string{{from, to}, {to, from}} {
+			var fetched []quotes.Quote
+			fetched, err = src.provider.FetchQuotes(ctx, []quotes.Symbol{quotes.FXSymbol(pair[0], pair[1])})
+			src.spend(1)
+			if len(fetched) == 0 {
+				if err == nil || errors.Is(err, quotes.ErrSymbolNotFound) {
+					err = fmt.Errorf("%s returned no quote", src.provider.Name())
+					continue
+				}
+				break
+			}
+
+			q := fetched[0]
+			Cache.Set(q)
+			rate := fxRateFromQuote(q)
+			if _, err := db.Exec(upsertFXRateSQL, pair[0], pair[1], rate.Date.Format("2006-01-02"), rate.Rate, rate.Source); err != nil {
+				log.Printf("Could not store %s/%s rate: %v", pair[0], pair[1], err)
+			}
+			return invertFXRate(rate, pair[0] != from), true
+		}
+	}
+	log.Printf("No current %s/%s rate: %v", from, to, err)
+	return FXRate{}, false
+}
+
+// historicalFXRate reads the rate on day from fx_rates, fetching the daily
+// history from a week before day up to today when it is missing. Fetching up
+// to today lets later trades in the same currency find their rate stored.
+func historicalFXRate(ctx context.Context, db *sql.DB, userID int, from, to string, day time.Time) (FXRate, bool, error) {
+	rate, ok, err := storedFXRate(db, from, to, day, day.Add(-fxLookback))
+	if ok || err != nil {
+		return rate, ok, err
+	}
+
+	missKey := from + "/" + to + ":" + day.Format("2006-01-02")
+	if _, missed := fxMisses.Get(missKey); missed {
+		return FXRate{}, false, nil
+	}
+
+	sources, err := fxSources(db, userID)
+fetch:
+	for _, src := range sources {
+		for _, pair := range [][2]string{{from, to}, {to, from}} {
+			var candles []quotes.Candle
+			candles, err = quotes.FetchDailyCandles(ctx, src.provider, quotes.FXSymbol(pair[0], pair[1]), day.Add(-fxLookback), StartOfDay(time.Now()))
+			if errors.Is(err, quotes.ErrHistoryUnsupported) {
+				continue fetch
+			}
+			src.spend(1)
+			for _, c := range candles {
+				if _, err := db.Exec(upsertFXRateSQL, pair[0], pair[1], c.Time.Format("2006-01-02"), c.Close, src.provider.Name()); err != nil {
+					return FXRate{}, false, fmt.Errorf("error storing FX rate: %v", err)
+				}
+			}
+			if len(candles) > 0 {
+				break fetch
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("No %s/%s history for %s: %v", from, to, day.Format("2006-01-02"), err)
+	}
+
+	rate, ok, err = storedFXRate(db, from, to, day, day.Add(-fxLookback))
+	if !ok && err == nil {
+		fxMisses.Set(missKey, true, cache.DefaultExpiration)
+	}
+	return rate, ok, err
+}
+
+// closestStoredFXRate is the offline answer: the latest stored rate on or
+// before day, or failing that the earliest one after it.
+func closestStoredFXRate(db *sql.DB, from, to string, day time.Time) (FXRate, bool, error) {
+	rate, ok, err := storedFXRate(db, from, to, day, time.Time{})
+	if ok || err != nil {
+		return rate, ok, err
+	}
+	for _, pair := range [][2]string{{from, to}, {to, from}} {
+		rate, ok, err := queryFXRate(db, selectFXRateAfterSQL, pair[0], pair[1], day.Format("2006-01-02"))
+		if err != nil || ok {
+			return invertFXRate(rate, pair[0] != from), ok, err
+		}
+	}
+	return FXRate{}, false, nil
+}
+
+// storedFXRate returns the latest stored rate between since and day, in
+// either direction of the pair.
+func storedFXRate(db *sql.DB, from, to string, day, since time.Time) (FXRate, bool, error) {
+	sinceDate := ""
+	if !since.IsZero() {
+		sinceDate = since.Format("2006-01-02")
+	}
+
+	var (
+		best  FXRate
+		found bool
+	)
+	for _, pair := range [][2]string{{from, to}, {to, from}} {
+		rate, ok, err := queryFXRate(db, selectFXRateOnSQL, pair[0], pair[1], day.Format("2006-01-02"), sinceDate)
+		if err != nil {
+			return FXRate{}, false, err
+		}
+		if ok && (!found || rate.Date.After(best.Date)) {
+			best, found = invertFXRate(rate, pair[0] != from), true
+		}
+	}
+	return best, found, nil
+}
+
+func queryFXRate(db *sql.DB, query string, args ...interface{}) (FXRate, bool, error) {
+	var (
+		rate FXRate
+		date string
+	)
+	err := db.QueryRow(query, args...).Scan(&rate.Rate, &date, &rate.Source)
+	if err == sql.ErrNoRows {
+		return FXRate{}, false, nil
+	}
+	if err != nil {
+		return FXRate{}, false, fmt.Errorf("error fetching FX rate: %v", err)
+	}
+	if rate.Date, err = time.Parse("2006-01-02", date); err != nil {
+		return FXRate{}, false, fmt.Errorf("error parsing FX rate date: %v", err)
+	}
+	return rate, rate.Rate > 0, nil
+}
+
+func invertFXRate(rate FXRate, invert bool) FXRate {
+	if invert && rate.Rate != 0 {
+		rate.Rate = 1 / rate.Rate
+	}
+	return rate
+}
+
+type fxSource struct {
+	provider quotes.QuoteProvider
+	spend    func(credits int)
+}
+
+// fxSources lists the user's providers, while they have credits left, and
+// then FXOffline. They are tried one after another rather than through a
+// quotes.Fallback, because a provider that does not quote a pair answers
+// without an error, which would end the fallback chain.
+func fxSources(db *sql.DB, userID int) ([]fxSource, error) {
+	var sources []fxSource
+	provider, err := ProviderFor(db, userID)
+	if err == nil {
+		if Limits.Remaining(userID, provider.Name(), provider.Capabilities()) != 0 {
+			sources = append(sources, fxSource{provider, func(credits int) { Limits.Spend(userID, provider.Name(), credits) }})
+		} else {
+			err = quotes.ErrCreditsExhausted
+		}
+	}
+	if FXOffline != nil {
+		sources = append(sources, fxSource{FXOffline, func(int) {}})
+	}
+	return sources, err
+}
+
+func fxRateFromQuote(q quotes.Quote) FXRate {
+	day := StartOfDay(q.Timestamp)
+	if q.Timestamp.IsZero() {
+		day = StartOfDay(time.Now())
+	}
+	return FXRate{Rate: q.Price, Date: day, Source: q.Source}
+}
+
+// StartOfDay returns midnight UTC of the UTC day t falls on, the form days
+// are compared and stored in.
+func StartOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Converter converts amounts into a base currency, looking up each currency
+// and day at most once. It is meant to live for a single request.
+type Converter struct {
+	Base string
+
+	ctx     context.Context
+	db      *sql.DB
+	userID  int
+	rates   map[string]FXRate
+	missing map[string]bool
+}
+
+func NewConverter(ctx context.Context, db *sql.DB, userID int, base string) *Converter {
+	return &Converter{
+		Base:    quotes.NormalizeCurrency(base),
+		ctx:     ctx,
+		db:      db,
+		userID:  userID,
+		rates:   make(map[string]FXRate),
+		missing: make(map[string]bool),
+	}
+}
+
+// Rate returns the rate from currency into the base currency on day, zero
+// meaning today. An empty currency is taken to be the base currency.
+func (c *Converter) Rate(currency string, day time.Time) (FXRate, bool) {
+	if currency == "" {
+		return FXRate{Rate: 1}, true
+	}
+	key := quotes.NormalizeCurrency(currency) + ":"
+	if !day.IsZero() {
+		key += day.UTC().Format("2006-01-02")
+	}
+	if rate, ok := c.rates[key]; ok {
+		return rate, true
+	}
+
+	rate, err := FXRateOn(c.ctx, c.db, c.userID, currency, c.Base, day)
+	if err != nil {
+		if !errors.Is(err, ErrNoFXRate) {
+			log.Printf("FX conversion %s/%s failed: %v", currency, c.Base, err)
+		}
+		major, _ := quotes.MajorCurrency(currency)
+		c.missing[major] = true
+		return FXRate{}, false
+	}
+	c.rates[key] = rate
+	return rate, true
+}
+
+// Convert returns amount in currency on day in the base currency. ok is false
+// when no rate is known.
+func (c *Converter) Convert(amount float64, currency string, day time.Time) (float64, bool) {
+	rate, ok := c.Rate(currency, day)
+	return amount * rate.Rate, ok
+}
+
+// Missing lists the currencies that could not be converted.
+func (c *Converter) Missing() []string {
+	missing := make([]string, 0, len(c.missing))
+	for currency := range c.missing {
+		missing = append(missing, currency)
+	}
+	sort.Strings(missing)
+	return missing
+}
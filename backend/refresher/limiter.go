@@ -0,0 +1,162 @@
+// /backend/refresher/limiter.go
+
+package refresher
+
+import (
+	"myinvestmap/quotes"
+	"sync"
+	"time"
+)
+
+// Limits is the process-wide credit limiter used by every refresh path.
+var Limits = NewLimiter()
+
+// Limiter tracks the credits each user has spent with each provider in the
+// current minute and UTC day, and splits symbol lists into batches that fit
+// the provider's budgets.
+type Limiter struct {
+	mu    sync.Mutex
+	usage map[limiterKey]*creditUsage
+	now   func() time.Time
+}
+
+type limiterKey struct {
+	userID   int
+	provider string
+}
+
+type creditUsage struct {
+	minute      time.Time
+	minuteCount int
+	day         time.Time
+	dayCount    int
+}
+
+// BatchPlan is the outcome of fitting a symbol list into a provider's limits.
+// Batches may be sent now; Deferred symbols must wait until RefreshAt.
+type BatchPlan struct {
+	Batches  [][]quotes.Symbol
+	Deferred []DeferredSymbol
+}
+
+type DeferredSymbol struct {
+	Symbol    quotes.Symbol
+	RefreshAt time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		usage: make(map[limiterKey]*creditUsage),
+		now:   func() time.Time { return time.Now().UTC() },
+	}
+}
+
+func (l *Limiter) Spend(userID int, provider string, credits int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u := l.current(limiterKey{userID, provider})
+	u.minuteCount += credits
+	u.dayCount += credits
+}
+
+// Remaining reports how many credits the user may still spend with the
+// provider right now, or -1 when the provider has no limits.
+func (l *Limiter) Remaining(userID int, provider string, caps quotes.Capabilities) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return remaining(l.current(limiterKey{userID, provider}), caps)
+}
+
+// Plan reserves nothing; it only computes which symbols fit into the current
+// budget, how to batch them, and when each remaining symbol is expected to
+// fit, assuming every later minute is spent on this list alone.
+func (l *Limiter) Plan(userID int, provider string, caps quotes.Capabilities, symbols []quotes.Symbol) BatchPlan {
+	l.mu.Lock()
+	u := *l.current(limiterKey{userID, provider})
+	l.mu.Unlock()
+
+	var plan BatchPlan
+	available := remaining(&u, caps)
+	now := available
+	if now < 0 || now > len(symbols) {
+		now = len(symbols)
+	}
+	plan.Batches = batch(symbols[:now], caps.MaxSymbolsPerRequest)
+
+	// Walk forward window by window, as if the deferred symbols were sent as
+	// soon as credits become available again.
+	u.minuteCount += now
+	u.dayCount += now
+	for _, symbol := range symbols[now:] {
+		for remaining(&u, caps) == 0 {
+			next := u.minute.Add(time.Minute)
+			if caps.CreditsPerDay > 0 && u.dayCount >= caps.CreditsPerDay {
+				next = u.day.Add(24 * time.Hour)
+				u.day, u.dayCount = next, 0
+			}
+			u.minute, u.minuteCount = next, 0
+		}
+		plan.Deferred = append(plan.Deferred, DeferredSymbol{Symbol: symbol, RefreshAt: u.minute})
+		u.minuteCount++
+		u.dayCount++
+	}
+	return plan
+}
+
+func (l *Limiter) current(key limiterKey) *creditUsage {
+	now := l.now()
+	u, ok := l.usage[key]
+	if !ok {
+		u = &creditUsage{}
+		l.usage[key] = u
+	}
+	if minute := now.Truncate(time.Minute); u.minute.Before(minute) {
+		u.minute = minute
+		u.minuteCount = 0
+	}
+	if day := now.Truncate(24 * time.Hour); u.day.Before(day) {
+		u.day = day
+		u.dayCount = 0
+	}
+	return u
+}
+
+func remaining(u *creditUsage, caps quotes.Capabilities) int {
+	left, limited := 0, false
+	if caps.CreditsPerMinute > 0 {
+		left, limited = caps.CreditsPerMinute-u.minuteCount, true
+	}
+	if caps.CreditsPerDay > 0 {
+		if daily := caps.CreditsPerDay - u.dayCount; !limited || daily < left {
+			left = daily
+		}
+		limited = true
+	}
+	if !limited {
+		return -1
+	}
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+func batch(symbols []quotes.Symbol, size int) [][]quotes.Symbol {
+	if len(symbols) == 0 {
+		return nil
+	}
+	if size <= 0 {
+		return [][]quotes.Symbol{symbols}
+	}
+
+	var batches [][]quotes.Symbol
+	for start := 0; start < len(symbols); start += size {
+		end := start + size
+		if end > len(symbols) {
+			end = len(symbols)
+		}
+		batches = append(batches, symbols[start:end])
+	}
+	return batches
+}
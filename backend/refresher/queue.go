@@ -0,0 +1,76 @@
+// /backend/refresher/queue.go
+
+package refresher
+
+import (
+	"myinvestmap/quotes"
+	"sync"
+)
+
+// Pending holds symbols a user asked to refresh that did not fit into the
+// provider's budget. The scheduler drains it before walking held symbols.
+var Pending = newQueue()
+
+type queue struct {
+	mu     sync.Mutex
+	byUser map[int][]quotes.Symbol
+}
+
+func newQueue() *queue {
+	return &queue{byUser: make(map[int][]quotes.Symbol)}
+}
+
+func (q *queue) Add(userID int, symbols []quotes.Symbol) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued := make(map[quotes.Symbol]bool, len(q.byUser[userID]))
+	for _, s := range q.byUser[userID] {
+		queued[s] = true
+	}
+	for _, s := range symbols {
+		if !queued[s] {
+			queued[s] = true
+			q.byUser[userID] = append(q.byUser[userID], s)
+		}
+	}
+}
+
+func (q *queue) Peek(userID int) []quotes.Symbol {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]quotes.Symbol(nil), q.byUser[userID]...)
+}
+
+func (q *queue) Users() []int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	users := make([]int, 0, len(q.byUser))
+	for userID := range q.byUser {
+		users = append(users, userID)
+	}
+	return users
+}
+
+func (q *queue) Remove(userID int, symbols []quotes.Symbol) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	done := make(map[quotes.Symbol]bool, len(symbols))
+	for _, s := range symbols {
+		done[s] = true
+	}
+
+	var rest []quotes.Symbol
+	for _, s := range q.byUser[userID] {
+		if !done[s] {
+			rest = append(rest, s)
+		}
+	}
+	if len(rest) == 0 {
+		delete(q.byUser, userID)
+		return
+	}
+	q.byUser[userID] = rest
+}
@@ -0,0 +1,238 @@
+// /backend/refresher/refresher.go
+
+package refresher
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"myinvestmap/calendar"
+	"myinvestmap/events"
+	"myinvestmap/models"
+	"myinvestmap/quotes"
+	"time"
+)
+
+const (
+	selectProviderCredentialsSQL = `SELECT provider, api_key FROM provider_credentials WHERE user_id = ? ORDER BY is_primary DESC, priority ASC, id ASC`
+	updateInstrumentQuoteSQL     = `UPDATE instruments SET name = COALESCE(NULLIF(?, ''), name), currency = COALESCE(NULLIF(?, ''), currency), lastPrice = ?, lastPriceAt = ?, lastPriceSource = ?, lastRefreshAt = ?, lastRefreshError = NULL, lastRefreshErrorAt = NULL, updatedAt = CURRENT_TIMESTAMP WHERE symbol = ? AND exchange = ?`
+	updateInstrumentErrorSQL     = `UPDATE instruments SET lastRefreshError = ?, lastRefreshErrorAt = ? WHERE symbol = ? AND exchange = ?`
+	insertPriceHistorySQL        = `INSERT OR IGNORE INTO price_history (instrument_id, price, priceAt, source) SELECT id, ?, ?, ? FROM instruments WHERE symbol = ? AND exchange = ?`
+)
+
+// Cache is shared by all users. main replaces it when QUOTE_CACHE_TTL is
+// configured.
+var Cache = quotes.NewCache(quotes.DefaultCacheTTL)
+
+// DefaultStaleAfter is how old a price may get before positions report it as
+// stale. main replaces StaleAfter when STALE_PRICE_AFTER is configured.
+const DefaultStaleAfter = time.Hour
+
+var StaleAfter = DefaultStaleAfter
+
+// IsStale reports whether a price last refreshed at refreshedAt is too old to
+// show without a warning. Prices refreshed after their exchange closed stay
+// fresh until it opens again; a missing price is always stale.
+func IsStale(exchange string, refreshedAt time.Time) bool {
+	if refreshedAt.IsZero() {
+		return true
+	}
+	now := time.Now()
+	return !refreshedSinceClose(exchange, refreshedAt, now) && now.Sub(refreshedAt) > StaleAfter
+}
+
+// refreshedSinceClose reports whether the exchange is closed at now and the
+// price was refreshed after its last session ended, so it cannot have changed
+// since. Exchanges without a calendar are never considered closed.
+func refreshedSinceClose(exchange string, refreshedAt, now time.Time) bool {
+	cal, ok := calendar.Lookup(exchange)
+	if !ok || cal.IsOpen(now) {
+		return false
+	}
+	return !refreshedAt.IsZero() && !refreshedAt.Before(cal.LastClose(now))
+}
+
+// ProviderFor resolves the market data provider used to refresh prices for a
+// user: the primary credential first, then the fallbacks in priority order.
+// Replace it to plug in a different provider or a fake in tests.
+var ProviderFor = func(db *sql.DB, userID int) (quotes.QuoteProvider, error) {
+	rows, err := db.Query(selectProviderCredentialsSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching provider credentials: %v", err)
+	}
+	defer rows.Close()
+
+	var providers []quotes.QuoteProvider
+	for rows.Next() {
+		var name, apiKey string
+		if err := rows.Scan(&name, &apiKey); err != nil {
+			return nil, fmt.Errorf("error scanning provider credential: %v", err)
+		}
+		provider, err := quotes.New(name, apiKey)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching provider credentials: %v", err)
+	}
+
+	switch len(providers) {
+	case 0:
+		return nil, fmt.Errorf("no API key configured")
+	case 1:
+		return providers[0], nil
+	}
+	return quotes.NewFallback(providers...), nil
+}
+
+// RefreshSymbols stores current prices for symbols, serving what it can from
+// Cache and fetching the rest with the user's providers. Each quote updates
+// the instrument listed under the symbol it was requested as and is appended
+// to its price history.
+func RefreshSymbols(ctx context.Context, db *sql.DB, symbols []quotes.Symbol, userID int) error {
+	if len(symbols) == 0 {
+		return nil
+	}
+
+	stockData, missing := Cache.Lookup(symbols)
+
+	var fetchErr error
+	if len(missing) > 0 {
+		provider, err := ProviderFor(db, userID)
+		if err != nil {
+			recordRefreshError(db, userID, missing, err)
+			return err
+		}
+
+		var fetched []quotes.Quote
+		fetched, fetchErr = provider.FetchQuotes(ctx, missing)
+		Limits.Spend(userID, provider.Name(), len(missing))
+		for _, q := range fetched {
+			Cache.Set(q)
+		}
+		stockData = append(stockData, fetched...)
+
+		if failed := quotes.Unresolved(missing, fetched); len(failed) > 0 {
+			if fetchErr != nil {
+				recordRefreshError(db, userID, failed, fetchErr)
+			} else {
+				recordRefreshError(db, userID, failed, fmt.Errorf("%s returned no quote", provider.Name()))
+			}
+		}
+	}
+	// Every symbol asked for leaves the queue, stored or not, so that one the
+	// provider cannot resolve is not retried with the user's credits forever.
+	Pending.Remove(userID, symbols)
+
+	// Quotes are stored under the symbol they were requested as, since the
+	// cache and providers may answer a request for AAPL on XNAS with one
+	// fetched as AAPL on NASDAQ.
+	requested := make(map[string][]quotes.Symbol, len(symbols))
+	for _, s := range symbols {
+		requested[s.Key()] = append(requested[s.Key()], s)
+	}
+
+	now := time.Now().UTC()
+	for _, data := range stockData {
+		priceAt := data.Timestamp.UTC()
+		key := quotes.Symbol{Symbol: data.Symbol, Exchange: data.Exchange}.Key()
+		for _, s := range requested[key] {
+			result, err := db.Exec(updateInstrumentQuoteSQL, data.Name, data.Currency, data.Price, priceAt, data.Source, now, s.Symbol, s.Exchange)
+			if err != nil {
+				return fmt.Errorf("error updating instrument in database: %v", err)
+			}
+			if n, err := result.RowsAffected(); err == nil && n == 0 {
+				log.Printf("No instrument listed as %s to store its quote", s)
+				continue
+			}
+			if _, err := db.Exec(insertPriceHistorySQL, data.Price, priceAt, data.Source, s.Symbol, s.Exchange); err != nil {
+				return fmt.Errorf("error recording price history: %v", err)
+			}
+			events.Default.Publish(events.Event{
+				Type: events.QuoteUpdated,
+				Data: events.Quote{Symbol: s.Symbol, Exchange: s.Exchange, Price: data.Price, PriceAt: priceAt, Source: data.Source},
+			})
+		}
+		delete(requested, key)
+	}
+
+	if fetchErr != nil {
+		return fmt.Errorf("error fetching stock data: %w", fetchErr)
+	}
+	return nil
+}
+
+// RefreshRequested refreshes as many of the requested symbols as the user's
+// provider budget allows right now and queues the rest for the scheduler.
+func RefreshRequested(ctx context.Context, db *sql.DB, symbols []quotes.Symbol, userID int) (BatchPlan, error) {
+	// Cache hits are refreshed as requested, not as the cached quote names
+	// its listing, so that they are stored under the instrument asked for.
+	var hits, missing []quotes.Symbol
+	for _, s := range uniqueSymbols(symbols) {
+		if _, ok := Cache.Get(s); ok {
+			hits = append(hits, s)
+		} else {
+			missing = append(missing, s)
+		}
+	}
+
+	var plan BatchPlan
+	if len(hits) > 0 {
+		plan.Batches = append(plan.Batches, hits)
+	}
+
+	if len(missing) > 0 {
+		provider, err := ProviderFor(db, userID)
+		if err != nil {
+			return plan, err
+		}
+
+		limited := Limits.Plan(userID, provider.Name(), provider.Capabilities(), missing)
+		plan.Batches = append(plan.Batches, limited.Batches...)
+		plan.Deferred = limited.Deferred
+	}
+
+	deferred := make([]quotes.Symbol, 0, len(plan.Deferred))
+	for _, d := range plan.Deferred {
+		deferred = append(deferred, d.Symbol)
+	}
+	Pending.Add(userID, deferred)
+
+	for _, batch := range plan.Batches {
+		if err := RefreshSymbols(ctx, db, batch, userID); err != nil {
+			return plan, err
+		}
+	}
+	return plan, nil
+}
+
+// recordRefreshError keeps the reason the last refresh of symbols failed
+// next to their last good price and tells the user whose refresh it was.
+func recordRefreshError(db *sql.DB, userID int, symbols []quotes.Symbol, cause error) {
+	now := time.Now().UTC()
+	failure := events.RefreshFailure{Error: cause.Error()}
+	for _, s := range symbols {
+		if _, err := db.Exec(updateInstrumentErrorSQL, cause.Error(), now, s.Symbol, s.Exchange); err != nil {
+			log.Printf("Could not record refresh error for %s: %v", s, err)
+		}
+		failure.Symbols = append(failure.Symbols, models.SymbolRef{Symbol: s.Symbol, Exchange: s.Exchange})
+	}
+	events.Default.Publish(events.Event{Type: events.RefreshFailed, UserID: userID, Data: failure})
+}
+
+// uniqueSymbols normalizes symbols and drops repeats and empty ones.
+func uniqueSymbols(symbols []quotes.Symbol) []quotes.Symbol {
+	seen := make(map[quotes.Symbol]bool, len(symbols))
+	unique := make([]quotes.Symbol, 0, len(symbols))
+	for _, s := range symbols {
+		s = s.Normalize()
+		if s.Symbol != "" && !seen[s] {
+			seen[s] = true
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
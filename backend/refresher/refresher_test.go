@@ -0,0 +1,247 @@
+// /backend/refresher/refresher_test.go
+
+package refresher
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"myinvestmap/database"
+	"myinvestmap/quotes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testUserID = 1
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db := database.InitDB(filepath.Join(t.TempDir(), "test.db"))
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// useProvider makes RefreshSymbols fetch from provider, starting from an
+// empty cache, for the rest of the test.
+func useProvider(t *testing.T, provider quotes.QuoteProvider) {
+	t.Helper()
+	providerFor, cache := ProviderFor, Cache
+	ProviderFor = func(db *sql.DB, userID int) (quotes.QuoteProvider, error) {
+		return provider, nil
+	}
+	Cache = quotes.NewCache(quotes.DefaultCacheTTL)
+	t.Cleanup(func() { ProviderFor, Cache = providerFor, cache })
+}
+
+func insertInstrument(t *testing.T, db *sql.DB, symbol, exchange string) {
+	t.Helper()
+	if _, err := db.Exec(`INSERT INTO instruments (symbol, exchange) VALUES (?, ?)`, symbol, exchange); err != nil {
+		t.Fatalf("inserting instrument %s:%s: %v", symbol, exchange, err)
+	}
+}
+
+type storedQuote struct {
+	price        sql.NullFloat64
+	source       sql.NullString
+	refreshError sql.NullString
+	history      int
+}
+
+func loadStoredQuote(t *testing.T, db *sql.DB, symbol, exchange string) storedQuote {
+	t.Helper()
+	var q storedQuote
+	err := db.QueryRow(`SELECT lastPrice, lastPriceSource, lastRefreshError, (SELECT COUNT(*) FROM price_history WHERE instrument_id = instruments.id) FROM instruments WHERE symbol = ? AND exchange = ?`, symbol, exchange).
+		Scan(&q.price, &q.source, &q.refreshError, &q.history)
+	if err != nil {
+		t.Fatalf("loading instrument %s:%s: %v", symbol, exchange, err)
+	}
+	return q
+}
+
+func TestRefreshSymbolsStoresFetchedQuotes(t *testing.T) {
+	db := newTestDB(t)
+	insertInstrument(t, db, "AAPL", "XNAS")
+	fake := quotes.NewFake(quotes.Quote{Symbol: "AAPL", Exchange: "XNAS", Price: 190.5, Currency: "USD", Timestamp: time.Date(2024, 3, 15, 20, 0, 0, 0, time.UTC)})
+	useProvider(t, fake)
+
+	symbols := []quotes.Symbol{{Symbol: "AAPL", Exchange: "XNAS"}}
+	if err := RefreshSymbols(context.Background(), db, symbols, testUserID); err != nil {
+		t.Fatalf("RefreshSymbols: %v", err)
+	}
+
+	got := loadStoredQuote(t, db, "AAPL", "XNAS")
+	if !got.price.Valid || got.price.Float64 != 190.5 {
+		t.Errorf("lastPrice = %v, want 190.5", got.price)
+	}
+	if got.source.String != quotes.FakeName {
+		t.Errorf("lastPriceSource = %q, want %q", got.source.String, quotes.FakeName)
+	}
+	if got.history != 1 {
+		t.Errorf("price history has %d rows, want 1", got.history)
+	}
+
+	// A second refresh is served from the cache.
+	if err := RefreshSymbols(context.Background(), db, symbols, testUserID); err != nil {
+		t.Fatalf("RefreshSymbols: %v", err)
+	}
+	if fake.Calls() != 1 {
+		t.Errorf("provider called %d times, want 1", fake.Calls())
+	}
+}
+
+func TestRefreshSymbolsStoresUnderRequestedSymbol(t *testing.T) {
+	db := newTestDB(t)
+	insertInstrument(t, db, "AAPL", "XNAS")
+	fake := quotes.NewFake()
+	useProvider(t, fake)
+	// Cached for another user who spelled the listing differently.
+	Cache.Set(quotes.Quote{Symbol: "aapl", Exchange: "NASDAQ", Price: 190.5, Timestamp: time.Now().UTC(), Source: quotes.FakeName})
+
+	if err := RefreshSymbols(context.Background(), db, []quotes.Symbol{{Symbol: "AAPL", Exchange: "XNAS"}}, testUserID); err != nil {
+		t.Fatalf("RefreshSymbols: %v", err)
+	}
+	if fake.Calls() != 0 {
+		t.Errorf("provider called %d times, want the cached quote", fake.Calls())
+	}
+	if got := loadStoredQuote(t, db, "AAPL", "XNAS"); got.price.Float64 != 190.5 || got.history != 1 {
+		t.Errorf("lastPrice = %v with %d history rows, want 190.5 with 1", got.price, got.history)
+	}
+}
+
+func TestRefreshSymbolsFromFile(t *testing.T) {
+	db := newTestDB(t)
+	insertInstrument(t, db, "AAPL", "NASDAQ")
+	insertInstrument(t, db, "SHEL", "XLON")
+	insertInstrument(t, db, "TSLA", "NASDAQ")
+
+	path := filepath.Join(t.TempDir(), "quotes.csv")
+	content := "symbol,exchange,price,timestamp\nAAPL,NASDAQ,190.5,2024-03-15T20:00:00Z\nSHEL,LSE,2650.5,2024-03-15T16:30:00Z\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("writing quotes file: %v", err)
+	}
+	useProvider(t, quotes.NewFile(path))
+
+	symbols := []quotes.Symbol{{Symbol: "AAPL", Exchange: "NASDAQ"}, {Symbol: "SHEL", Exchange: "XLON"}, {Symbol: "TSLA", Exchange: "NASDAQ"}}
+	if err := RefreshSymbols(context.Background(), db, symbols, testUserID); err != nil {
+		t.Fatalf("RefreshSymbols: %v", err)
+	}
+
+	tests := []struct {
+		symbol, exchange string
+		wantPrice        float64
+		wantError        string
+	}{
+		{symbol: "AAPL", exchange: "NASDAQ", wantPrice: 190.5},
+		{symbol: "SHEL", exchange: "XLON", wantPrice: 2650.5},
+		{symbol: "TSLA", exchange: "NASDAQ", wantError: "file returned no quote"},
+	}
+	for _, tt := range tests {
+		got := loadStoredQuote(t, db, tt.symbol, tt.exchange)
+		if tt.wantError != "" {
+			if got.price.Valid || got.refreshError.String != tt.wantError {
+				t.Errorf("%s: lastPrice = %v, lastRefreshError = %q, want no price and %q", tt.symbol, got.price, got.refreshError.String, tt.wantError)
+			}
+			continue
+		}
+		if got.price.Float64 != tt.wantPrice || got.source.String != quotes.FileName || got.history != 1 {
+			t.Errorf("%s: lastPrice = %v from %q with %d history rows, want %v from %q with 1", tt.symbol, got.price, got.source.String, got.history, tt.wantPrice, quotes.FileName)
+		}
+	}
+}
+
+func TestRefreshSymbolsRecordsErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(f *quotes.Fake)
+		wantErr   bool
+		wantCause string
+	}{
+		{
+			name:      "provider fails",
+			setup:     func(f *quotes.Fake) { f.SetError(errors.New("service unavailable")) },
+			wantErr:   true,
+			wantCause: "service unavailable",
+		},
+		{
+			name:      "no quote returned",
+			setup:     func(f *quotes.Fake) {},
+			wantCause: "fake returned no quote",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			insertInstrument(t, db, "MSFT", "XNAS")
+			fake := quotes.NewFake()
+			tt.setup(fake)
+			useProvider(t, fake)
+
+			err := RefreshSymbols(context.Background(), db, []quotes.Symbol{{Symbol: "MSFT", Exchange: "XNAS"}}, testUserID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RefreshSymbols error = %v, want error %v", err, tt.wantErr)
+			}
+
+			got := loadStoredQuote(t, db, "MSFT", "XNAS")
+			if got.price.Valid {
+				t.Errorf("lastPrice = %v, want none", got.price.Float64)
+			}
+			if got.refreshError.String != tt.wantCause {
+				t.Errorf("lastRefreshError = %q, want %q", got.refreshError.String, tt.wantCause)
+			}
+		})
+	}
+}
+
+func TestRefreshRequestedNormalizesSymbols(t *testing.T) {
+	db := newTestDB(t)
+	insertInstrument(t, db, "AAPL", "XNAS")
+	insertInstrument(t, db, "SHEL", "XLON")
+	fake := quotes.NewFake(quotes.Quote{Symbol: "SHEL", Exchange: "XLON", Price: 2650.5, Timestamp: time.Now().UTC()})
+	useProvider(t, fake)
+	// Cached as the provider spelled the listing.
+	Cache.Set(quotes.Quote{Symbol: "aapl", Exchange: "NASDAQ", Price: 190.5, Timestamp: time.Now().UTC(), Source: quotes.FakeName})
+
+	plan, err := RefreshRequested(context.Background(), db, []quotes.Symbol{{Symbol: "aapl", Exchange: "nasdaq"}, {Symbol: " shel ", Exchange: "LSE"}}, testUserID)
+	if err != nil {
+		t.Fatalf("RefreshRequested: %v", err)
+	}
+
+	want := [][]quotes.Symbol{{{Symbol: "AAPL", Exchange: "XNAS"}}, {{Symbol: "SHEL", Exchange: "XLON"}}}
+	if !reflect.DeepEqual(plan.Batches, want) {
+		t.Errorf("batches = %v, want %v", plan.Batches, want)
+	}
+	if got := loadStoredQuote(t, db, "AAPL", "XNAS"); got.price.Float64 != 190.5 || got.history != 1 {
+		t.Errorf("AAPL: lastPrice = %v with %d history rows, want 190.5 with 1", got.price, got.history)
+	}
+	if got := loadStoredQuote(t, db, "SHEL", "XLON"); got.price.Float64 != 2650.5 || got.history != 1 {
+		t.Errorf("SHEL: lastPrice = %v with %d history rows, want 2650.5 with 1", got.price, got.history)
+	}
+}
+
+// usePending starts the test with an empty refresh queue.
+func usePending(t *testing.T) {
+	t.Helper()
+	pending := Pending
+	Pending = newQueue()
+	t.Cleanup(func() { Pending = pending })
+}
+
+func TestRefreshSymbolsDequeuesUnresolvedSymbols(t *testing.T) {
+	db := newTestDB(t)
+	insertInstrument(t, db, "MSFT", "XNAS")
+	useProvider(t, quotes.NewFake())
+	usePending(t)
+
+	symbols := []quotes.Symbol{{Symbol: "MSFT", Exchange: "XNAS"}}
+	Pending.Add(testUserID, symbols)
+	if err := RefreshSymbols(context.Background(), db, symbols, testUserID); err != nil {
+		t.Fatalf("RefreshSymbols: %v", err)
+	}
+	if got := Pending.Peek(testUserID); len(got) != 0 {
+		t.Errorf("pending = %v, want none", got)
+	}
+}
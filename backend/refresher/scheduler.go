@@ -0,0 +1,211 @@
+// /backend/refresher/scheduler.go
+
+package refresher
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"myinvestmap/quotes"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultInterval = time.Minute
+
+	// selectHeldSymbolsSQL lists every instrument held by every user, least
+	// recently priced first. Shares that changed symbol or were spun off are
+	// held in the instrument they became; the old symbol is no longer quoted.
+	selectHeldSymbolsSQL = `SELECT h.user_id, i.symbol, i.exchange, i.lastRefreshAt FROM (SELECT user_id, instrument_id FROM assets WHERE user_id IS NOT NULL UNION SELECT a.user_id, ca.new_instrument_id FROM corporate_actions ca JOIN assets a ON a.instrument_id = ca.instrument_id AND ca.user_id IN (0, a.user_id) WHERE ca.new_instrument_id IS NOT NULL) h JOIN instruments i ON i.id = h.instrument_id WHERE NOT EXISTS (SELECT 1 FROM corporate_actions ca WHERE ca.instrument_id = i.id AND ca.type = 'symbol_change' AND ca.user_id IN (0, h.user_id)) GROUP BY h.user_id, i.id ORDER BY i.lastPriceAt ASC, i.id ASC`
+)
+
+// Scheduler refreshes the prices of all held symbols in the background.
+// Each pass hands every symbol to one of the users holding it, never plans
+// more than Limits allows for that user's provider, and spreads the
+// resulting calls evenly over the interval. Symbols whose exchange is closed
+// are skipped once they have been refreshed after the close.
+type Scheduler struct {
+	db       *sql.DB
+	interval time.Duration
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+type refreshCall struct {
+	userID  int
+	symbols []quotes.Symbol
+}
+
+func NewScheduler(db *sql.DB, interval time.Duration) *Scheduler {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Scheduler{db: db, interval: interval}
+}
+
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go s.run(ctx, s.done)
+}
+
+// Stop cancels the running pass and waits for the scheduler goroutine to
+// exit.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (s *Scheduler) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.refreshOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Price refresh failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Scheduler) refreshOnce(ctx context.Context) error {
+	calls, err := s.plan()
+	if err != nil {
+		return err
+	}
+	if len(calls) == 0 {
+		return nil
+	}
+
+	spacing := s.interval / time.Duration(len(calls)+1)
+	for i, call := range calls {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(spacing):
+			}
+		}
+		if err := RefreshSymbols(ctx, s.db, call.symbols, call.userID); err != nil {
+			log.Printf("Price refresh for user %d failed: %v", call.userID, err)
+		}
+	}
+	return nil
+}
+
+// plan assigns every held symbol to the first user holding it who still has
+// credits and batches each user's symbols by the provider's request size.
+func (s *Scheduler) plan() ([]refreshCall, error) {
+	rows, err := s.db.Query(selectHeldSymbolsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching held symbols: %v", err)
+	}
+
+	var (
+		users     []int
+		byUser    = make(map[int][]quotes.Symbol)
+		holders   []int
+		open      = make(map[int][]quotes.Symbol)
+		held      = make(map[int]map[quotes.Symbol]bool)
+		scheduled = make(map[quotes.Symbol]bool)
+	)
+	now := time.Now()
+	for rows.Next() {
+		var userID int
+		var symbol quotes.Symbol
+		var refreshedAt sql.NullTime
+		if err := rows.Scan(&userID, &symbol.Symbol, &symbol.Exchange, &refreshedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning held symbol: %v", err)
+		}
+		if held[userID] == nil {
+			holders = append(holders, userID)
+			held[userID] = make(map[quotes.Symbol]bool)
+		}
+		held[userID][symbol.Normalize()] = true
+		// A closed market is refreshed once after the close and then left
+		// alone until it opens again.
+		if refreshedSinceClose(symbol.Exchange, refreshedAt.Time, now) {
+			continue
+		}
+		open[userID] = append(open[userID], symbol)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching held symbols: %v", err)
+	}
+
+	// Symbols queued by an explicit refresh request go first, as long as the
+	// user still holds them.
+	for _, userID := range Pending.Users() {
+		var gone []quotes.Symbol
+		for _, symbol := range Pending.Peek(userID) {
+			if held[userID][symbol.Normalize()] {
+				byUser[userID] = append(byUser[userID], symbol)
+			} else {
+				gone = append(gone, symbol)
+			}
+		}
+		Pending.Remove(userID, gone)
+		if len(byUser[userID]) > 0 {
+			users = append(users, userID)
+		}
+	}
+	for _, userID := range holders {
+		if len(open[userID]) == 0 {
+			continue
+		}
+		if _, ok := byUser[userID]; !ok {
+			users = append(users, userID)
+		}
+		byUser[userID] = append(byUser[userID], open[userID]...)
+	}
+
+	var calls []refreshCall
+	for _, userID := range users {
+		provider, err := ProviderFor(s.db, userID)
+		if err != nil {
+			continue
+		}
+		var symbols []quotes.Symbol
+		for _, symbol := range uniqueSymbols(byUser[userID]) {
+			if !scheduled[symbol] {
+				symbols = append(symbols, symbol)
+			}
+		}
+
+		plan := Limits.Plan(userID, provider.Name(), provider.Capabilities(), symbols)
+		for _, batch := range plan.Batches {
+			for _, symbol := range batch {
+				scheduled[symbol] = true
+			}
+			calls = append(calls, refreshCall{userID: userID, symbols: batch})
+		}
+	}
+	return calls, nil
+}
@@ -0,0 +1,34 @@
+// /backend/refresher/scheduler_test.go
+
+package refresher
+
+import (
+	"myinvestmap/quotes"
+	"reflect"
+	"testing"
+)
+
+func TestPlanDropsPendingSymbolsNoLongerHeld(t *testing.T) {
+	db := newTestDB(t)
+	insertInstrument(t, db, "AAPL", "XNAS")
+	if _, err := db.Exec(`INSERT INTO assets (user_id, instrument_id, stockTag, exchange, price, quantity) SELECT ?, id, symbol, exchange, 150, 10 FROM instruments WHERE symbol = 'AAPL'`, testUserID); err != nil {
+		t.Fatalf("inserting asset: %v", err)
+	}
+	useProvider(t, quotes.NewFake())
+	usePending(t)
+
+	held := quotes.Symbol{Symbol: "AAPL", Exchange: "XNAS"}
+	Pending.Add(testUserID, []quotes.Symbol{{Symbol: "TSLA", Exchange: "XNAS"}, held})
+
+	calls, err := NewScheduler(db, 0).plan()
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	want := []refreshCall{{userID: testUserID, symbols: []quotes.Symbol{held}}}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %+v, want %+v", calls, want)
+	}
+	if got := Pending.Peek(testUserID); !reflect.DeepEqual(got, []quotes.Symbol{held}) {
+		t.Errorf("pending = %v, want %v", got, []quotes.Symbol{held})
+	}
+}
@@ -0,0 +1,98 @@
+// /backend/refresher/splits.go
+
+package refresher
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"myinvestmap/portfolio"
+	"myinvestmap/quotes"
+)
+
+const (
+	selectSplitInstrumentsSQL = `SELECT DISTINCT i.id, i.symbol, i.exchange FROM assets a JOIN instruments i ON i.id = a.instrument_id WHERE a.user_id = ? AND i.type = 'stock'`
+	upsertSplitSQL            = `INSERT INTO corporate_actions (user_id, instrument_id, type, effectiveDate, newShares, oldShares, source) VALUES (0, ?, ?, ?, ?, ?, ?) ON CONFLICT(instrument_id, type, effectiveDate, user_id) DO UPDATE SET newShares = excluded.newShares, oldShares = excluded.oldShares, source = excluded.source`
+)
+
+// SplitResult reports the splits stored for one instrument.
+type SplitResult struct {
+	Symbol quotes.Symbol
+	Splits int
+	Err    error
+}
+
+// FetchSplits asks the user's provider for the split history of every stock
+// they hold and stores it for everyone holding them. Each instrument costs
+// the provider's split cost; those that no longer fit the user's credits
+// report ErrCreditsExhausted.
+func FetchSplits(ctx context.Context, db *sql.DB, userID int) ([]SplitResult, error) {
+	rows, err := db.Query(selectSplitInstrumentsSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching instruments: %v", err)
+	}
+	type instrument struct {
+		id     int
+		symbol quotes.Symbol
+	}
+	var instruments []instrument
+	for rows.Next() {
+		var i instrument
+		if err := rows.Scan(&i.id, &i.symbol.Symbol, &i.symbol.Exchange); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning instrument: %v", err)
+		}
+		instruments = append(instruments, i)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching instruments: %v", err)
+	}
+
+	provider, err := ProviderFor(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SplitResult, 0, len(instruments))
+	for _, i := range instruments {
+		result := SplitResult{Symbol: i.symbol}
+		caps := provider.Capabilities()
+		if left := Limits.Remaining(userID, provider.Name(), caps); left >= 0 && left < caps.SplitCost() {
+			result.Err = quotes.ErrCreditsExhausted
+			results = append(results, result)
+			continue
+		}
+
+		splits, err := quotes.FetchSplits(ctx, provider, i.symbol)
+		Limits.Spend(userID, provider.Name(), caps.SplitCost())
+		if err != nil {
+			result.Err = fmt.Errorf("error fetching splits for %s: %w", i.symbol, err)
+			results = append(results, result)
+			continue
+		}
+		for _, s := range splits {
+			actionType, newShares, oldShares := splitShares(s.Ratio)
+			if _, err := db.Exec(upsertSplitSQL, i.id, actionType, s.Date.Format("2006-01-02"), newShares, oldShares, provider.Name()); err != nil {
+				return results, fmt.Errorf("error storing split: %v", err)
+			}
+			result.Splits++
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// splitShares writes a split ratio the way splits are announced: 4 for 1
+// rather than 0.25, and 1 for 10 rather than 0.1.
+func splitShares(ratio float64) (string, float64, float64) {
+	if ratio >= 1 {
+		return portfolio.ActionSplit, roundShares(ratio), 1
+	}
+	return portfolio.ActionReverseSplit, 1, roundShares(1 / ratio)
+}
+
+func roundShares(shares float64) float64 {
+	return math.Round(shares*1e6) / 1e6
+}
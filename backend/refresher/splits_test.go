@@ -0,0 +1,61 @@
+// /backend/refresher/splits_test.go
+
+package refresher
+
+import (
+	"context"
+	"errors"
+	"myinvestmap/quotes"
+	"testing"
+	"time"
+)
+
+func TestFetchSplitsSpendsSplitCost(t *testing.T) {
+	db := newTestDB(t)
+	limits := Limits
+	Limits = NewLimiter()
+	t.Cleanup(func() { Limits = limits })
+
+	symbols := []quotes.Symbol{{Symbol: "AAPL", Exchange: "XNAS"}, {Symbol: "MSFT", Exchange: "XNAS"}, {Symbol: "NVDA", Exchange: "XNAS"}}
+	for _, s := range symbols {
+		insertInstrument(t, db, s.Symbol, s.Exchange)
+		if _, err := db.Exec(`INSERT INTO assets (user_id, instrument_id, stockTag, exchange, price, quantity) SELECT ?, id, symbol, exchange, 100, 1 FROM instruments WHERE symbol = ?`, testUserID, s.Symbol); err != nil {
+			t.Fatalf("inserting asset: %v", err)
+		}
+	}
+
+	fake := quotes.NewFake()
+	fake.SetCapabilities(quotes.Capabilities{CreditsPerMinute: 50, SplitCredits: 20})
+	fake.SetSplits(symbols[0], []quotes.Split{{Date: time.Date(2020, 8, 31, 0, 0, 0, 0, time.UTC), Ratio: 4}})
+	useProvider(t, fake)
+
+	results, err := FetchSplits(context.Background(), db, testUserID)
+	if err != nil {
+		t.Fatalf("FetchSplits: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+
+	var fetched, exhausted, splits int
+	for _, r := range results {
+		switch {
+		case r.Err == nil:
+			fetched++
+			splits += r.Splits
+		case errors.Is(r.Err, quotes.ErrCreditsExhausted):
+			exhausted++
+		default:
+			t.Errorf("%s: unexpected error %v", r.Symbol, r.Err)
+		}
+	}
+	if fetched != 2 || exhausted != 1 || splits != 1 {
+		t.Errorf("fetched %d, exhausted %d, stored %d splits; want 2, 1 and 1", fetched, exhausted, splits)
+	}
+	if fake.Calls() != 2 {
+		t.Errorf("provider called %d times, want 2", fake.Calls())
+	}
+	if left := Limits.Remaining(testUserID, fake.Name(), fake.Capabilities()); left != 10 {
+		t.Errorf("%d credits left, want 10", left)
+	}
+}
@@ -1,15 +1,18 @@
-import React, { useState, useEffect } from 'react';
-import { Modal, Button, Form, Alert } from 'react-bootstrap';
-import { addAssetApi } from '../services/api';
+import React, { useState, useEffect, useRef } from 'react';
+import { Modal, Button, Form, Alert, ListGroup } from 'react-bootstrap';
+import { addAssetApi, searchSymbolsApi } from '../services/api';
 
 function AddAssetForm({ onAssetAdded }) {
-  const [asset, setAsset] = useState({ stockTag: '', exchange: '', price: 0, quantity: 0 });
+  const [asset, setAsset] = useState({ assetType: 'stock', stockTag: '', exchange: '', account: '', price: 0, quantity: 0, fee: '', tax: '', tradedAt: '' });
   const [showModal, setShowModal] = useState(false);
   const [notification, setNotification] = useState({ message: '', type: '' });
+  const isCrypto = asset.assetType === 'crypto';
+  const [suggestions, setSuggestions] = useState([]);
+  const pickedSuggestion = useRef(false);
 
   const handleChange = (event) => {
     const { name, value } = event.target;
-    if (name === 'price' || name === 'quantity') {
+    if (name === 'price' || name === 'quantity' || name === 'fee' || name === 'tax') {
       if (value === '' || value.match(/^(\d+)?([.,](\d+)?)?$/)) {
         const formattedValue = value.replace(',', '.');
         setAsset({ ...asset, [name]: formattedValue });
@@ -19,6 +22,26 @@ function AddAssetForm({ onAssetAdded }) {
     }
   };
 
+  useEffect(() => {
+    if (pickedSuggestion.current || asset.assetType === 'crypto' || asset.stockTag.trim().length < 2) {
+      pickedSuggestion.current = false;
+      setSuggestions([]);
+      return;
+    }
+    const timer = setTimeout(() => {
+      searchSymbolsApi(asset.stockTag.trim())
+        .then(response => setSuggestions(response.data.slice(0, 8)))
+        .catch(() => setSuggestions([]));
+    }, 400);
+    return () => clearTimeout(timer);
+  }, [asset.stockTag, asset.assetType]);
+
+  const handleSuggestion = (listing) => {
+    pickedSuggestion.current = listing.symbol !== asset.stockTag;
+    setAsset({ ...asset, stockTag: listing.symbol, exchange: listing.exchange });
+    setSuggestions([]);
+  };
+
    useEffect(() => {
     if (showModal) {
       setNotification({ message: '', type: '' });
@@ -29,16 +52,20 @@ function AddAssetForm({ onAssetAdded }) {
     event.preventDefault();
     asset.price = parseFloat(asset.price);
     asset.quantity = parseFloat(asset.quantity);
-    addAssetApi(asset)
+    asset.fee = parseFloat(asset.fee) || 0;
+    asset.tax = parseFloat(asset.tax) || 0;
+    const { tradedAt, ...rest } = asset;
+    addAssetApi(tradedAt ? { ...rest, tradedAt: new Date(tradedAt).toISOString() } : rest)
     .then(response => {
       onAssetAdded();
       setShowModal(false);
-      setAsset({ stockTag: '', exchange: '', price: '', quantity: '' });
+      setAsset({ assetType: 'stock', stockTag: '', exchange: '', account: '', price: '', quantity: '', fee: '', tax: '', tradedAt: '' });
       setNotification({ message: 'Asset added successfully!', type: 'success' });
     })
     .catch(error => {
         console.error('Error adding asset:', error);
-        setNotification({ message: 'Error adding asset. Please try again.', type: 'danger' });
+        const reason = error.response && typeof error.response.data === 'string' ? error.response.data.trim() : '';
+        setNotification({ message: reason ? `Error adding asset: ${reason}` : 'Error adding asset. Please try again.', type: 'danger' });
     });
   };
 
@@ -58,23 +85,56 @@ function AddAssetForm({ onAssetAdded }) {
           )}
           <Form onSubmit={handleSubmit}>
             <Form.Group className="mb-3">
-              <Form.Label>Stock Tag</Form.Label>
+              <Form.Label>Asset Type</Form.Label>
+              <Form.Select name="assetType" value={asset.assetType} onChange={handleChange}>
+                <option value="stock">Stock</option>
+                <option value="crypto">Crypto</option>
+              </Form.Select>
+            </Form.Group>
+            <Form.Group className="mb-3">
+              <Form.Label>{isCrypto ? 'Pair' : 'Stock Tag'}</Form.Label>
               <Form.Control 
                 type="text" 
                 name="stockTag" 
                 value={asset.stockTag} 
                 onChange={handleChange} 
-                placeholder="Stock Tag" 
+                placeholder={isCrypto ? 'BTC/USD' : 'Stock Tag'} 
+                autoComplete="off"
               />
+              {suggestions.length > 0 && (
+                <ListGroup className="mt-1">
+                  {suggestions.map(listing => (
+                    <ListGroup.Item
+                      key={`${listing.symbol}:${listing.exchange}`}
+                      action
+                      onClick={() => handleSuggestion(listing)}
+                    >
+                      {listing.symbol} {listing.exchange && `(${listing.exchange})`} {listing.name}
+                    </ListGroup.Item>
+                  ))}
+                </ListGroup>
+              )}
             </Form.Group>
+            {!isCrypto && (
+              <Form.Group className="mb-3">
+                <Form.Label>Exchange</Form.Label>
+                <Form.Control 
+                  type="text" 
+                  name="exchange" 
+                  value={asset.exchange} 
+                  onChange={handleChange} 
+                  placeholder="Exchange" 
+                />
+              </Form.Group>
+            )}
             <Form.Group className="mb-3">
-              <Form.Label>Exchange</Form.Label>
+              <Form.Label>{isCrypto ? 'Wallet / Account' : 'Account'}</Form.Label>
               <Form.Control 
                 type="text" 
-                name="exchange" 
-                value={asset.exchange} 
+                name="account" 
+                value={asset.account} 
                 onChange={handleChange} 
-                placeholder="Exchange" 
+                placeholder={isCrypto ? 'e.g. Ledger, Coinbase' : 'Account (optional)'} 
               />
             </Form.Group>
             <Form.Group className="mb-3">
@@ -97,6 +157,35 @@ function AddAssetForm({ onAssetAdded }) {
                 placeholder="Quantity" 
               />
             </Form.Group>
+            <Form.Group className="mb-3">
+              <Form.Label>Fee</Form.Label>
+              <Form.Control 
+                type="text" 
+                name="fee" 
+                value={asset.fee} 
+                onChange={handleChange} 
+                placeholder="Commission (optional)" 
+              />
+            </Form.Group>
+            <Form.Group className="mb-3">
+              <Form.Label>Tax</Form.Label>
+              <Form.Control 
+                type="text" 
+                name="tax" 
+                value={asset.tax} 
+                onChange={handleChange} 
+                placeholder="Stamp duty or transaction tax (optional)" 
+              />
+            </Form.Group>
+            <Form.Group className="mb-3">
+              <Form.Label>Trade Date</Form.Label>
+              <Form.Control 
+                type="date" 
+                name="tradedAt" 
+                value={asset.tradedAt} 
+                onChange={handleChange} 
+              />
+            </Form.Group>
             <Button variant="primary" type="submit">
               Add Asset
             </Button>
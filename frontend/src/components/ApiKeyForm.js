@@ -5,12 +5,14 @@ import { Button, Form, FormGroup, FormControl, Container, Row, Col } from 'react
 
 function ApiKeyForm() {
   const [apiKey, setApiKey] = useState('');
+  const [provider, setProvider] = useState('twelvedata');
   const [errorMessage, setErrorMessage] = useState('');
 
   useEffect(() => {
     getApiKey(apiKey)
     .then(response => {
         setApiKey(response.data.api_key)
+        setProvider(response.data.provider || 'twelvedata')
     })
     .catch(error => {
       if (error.message) {
@@ -26,9 +28,10 @@ function ApiKeyForm() {
 
   const handleSubmit = async (e) => {
     e.preventDefault();
-    await saveApiKey(apiKey)
+    await saveApiKey(apiKey, provider)
     .then(response => {
         setApiKey(response.data.api_key)
+        setProvider(response.data.provider)
     })
     .catch(error => {
       if (error.message) {
@@ -46,6 +49,15 @@ function ApiKeyForm() {
     <form className='my-4' onSubmit={handleSubmit}>
       <div className='mb-3'>
        <FormGroup className='mb-3'>
+            <Form.Select
+                className='mb-2'
+                value={provider}
+                onChange={(e) => setProvider(e.target.value)}
+            >
+                <option value="twelvedata">Twelve Data</option>
+                <option value="alphavantage">Alpha Vantage</option>
+                <option value="alphavantage-bulk">Alpha Vantage (premium)</option>
+            </Form.Select>
             <FormControl
                 type="text"
                 value={apiKey}
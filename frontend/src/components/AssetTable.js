@@ -1,10 +1,11 @@
-import React, { useState, useEffect, useCallback} from 'react';
+import React, { useState, useEffect, useCallback, useRef } from 'react';
 import AddAssetForm from './AddAssetForm';
 import SellAssetForm from './SellAssetForm';
 import EditAssetModal from './EditAssetModal';
 import ApiKeyForm from './ApiKeyForm';
 import { Table } from 'react-bootstrap';
-import { getAssetsApi, deleteAssetApi, refreshAssetsApi } from '../services/api';
+import { getAssetsApi, deleteAssetApi, refreshAssetsApi, getPortfolioTotalsApi, getPositionsApi, getPnlApi } from '../services/api';
+import openPriceStream from '../services/priceStream';
 
 function AssetTable() {
   const [assets, setAssets] = useState([]);
@@ -12,6 +13,10 @@ function AssetTable() {
   const [showEditModal, setShowEditModal] = useState(false);
   const [selectedAssets, setSelectedAssets] = useState(new Set());
   const [errorMessage, setErrorMessage] = useState('');
+  const [infoMessage, setInfoMessage] = useState('');
+  const [totals, setTotals] = useState(null);
+  const [positions, setPositions] = useState(null);
+  const [pnl, setPnl] = useState(null);
 
   const handleEdit = (asset) => {
     setEditingAsset(asset);
@@ -29,10 +34,6 @@ function AssetTable() {
       if (newSelectedAssets.has(assetId)) {
         newSelectedAssets.delete(assetId);
       } else {
-        if (newSelectedAssets.size >= 8) {
-          alert("You can select a maximum of 8 assets.");
-          return prevSelectedAssets;
-        }
         newSelectedAssets.add(assetId);
       }
       return newSelectedAssets;
@@ -40,19 +41,24 @@ function AssetTable() {
   };
 
   function handleRefreshSelected() {
-    if (selectedAssets.length > 8) {
-      alert("You can select a maximum of 8 assets for refresh.");
-      return;
-    }
-
-    const selectedSymbols = assets.filter(asset => selectedAssets.has(asset.id)).map(asset => asset.stockTag);
+    const selectedSymbols = assets.filter(asset => selectedAssets.has(asset.id)).map(asset => ({
+      symbol: asset.stockTag,
+      exchange: asset.exchange
+    }));
     if (selectedSymbols.length > 0) {
-      const symbolsToUpdate = selectedSymbols.slice(0, 8);
       refreshAssetsApi({
-        symbols: symbolsToUpdate
+        assets: selectedSymbols
       })
       .then(response => {
         console.log('Assets updated:', response.data);
+        const deferred = response.data.deferred || [];
+        if (deferred.length > 0) {
+          setInfoMessage('Deferred by provider limits: ' + deferred.map(d =>
+            d.symbol + ' (at ' + new Date(d.refreshAt).toLocaleTimeString() + ')'
+          ).join(', '));
+        } else {
+          setInfoMessage('');
+        }
         fetchAssets();
         setSelectedAssets(new Set())
       })
@@ -69,10 +75,27 @@ function AssetTable() {
     }
   }
 
+  const priceStream = useRef(null);
+
   const fetchAssets = useCallback(() => {
     getAssetsApi()
     .then(response => {
       setAssets(response.data);
+      if (priceStream.current) {
+        priceStream.current.resubscribe();
+      }
+    })
+    .then(() => getPositionsApi())
+    .then(response => {
+      setPositions(response.data);
+    })
+    .then(() => getPnlApi())
+    .then(response => {
+      setPnl(response.data);
+    })
+    .then(() => getPortfolioTotalsApi())
+    .then(response => {
+      setTotals(response.data);
     })
     .catch(error => () => {
       if (error.message) {
@@ -88,8 +111,23 @@ function AssetTable() {
 
   useEffect(() => {
     fetchAssets();
-    // const interval = setInterval(fetchAssets, 180000); 
-    // return () => clearInterval(interval); 
+    const applyPosition = (position, fresh) => {
+      setAssets(prevAssets => prevAssets.map(asset => {
+        if (asset.stockTag !== position.symbol || asset.exchange !== position.exchange || position.price === null) {
+          return asset;
+        }
+        const updated = { ...asset, currentPrice: { Float64: position.price, Valid: true }, priceAsOf: position.priceAt, priceSource: position.source };
+        return fresh ? { ...updated, isStale: false, refreshError: undefined } : updated;
+      }));
+    };
+    priceStream.current = openPriceStream((message) => {
+      if (message.type === 'snapshot') {
+        message.positions.forEach(position => applyPosition(position, false));
+      } else if (message.type === 'quote') {
+        applyPosition(message.position, true);
+      }
+    });
+    return () => priceStream.current.close();
   }, [fetchAssets]);
 
   const deleteAsset = (id) => {
@@ -113,9 +151,34 @@ function AssetTable() {
     return asset.name.Valid ? asset.name.String : 'N/A';
   };
   
+  const getPriceTitle = (asset) => {
+    const parts = [];
+    if (asset.priceAsOf) {
+      parts.push(`As of ${new Date(asset.priceAsOf).toLocaleString()}${asset.priceSource ? ` from ${asset.priceSource}` : ''}`);
+    } else {
+      parts.push('No price yet');
+    }
+    if (asset.refreshError) {
+      parts.push(`Last refresh failed: ${asset.refreshError}`);
+    }
+    return parts.join('\n');
+  };
+
   const getCurrentPrice = (asset) => {
+    if (asset.adjustment && asset.adjustment.currentPrice !== undefined) {
+      return asset.adjustment.currentPrice;
+    }
     return asset.currentPrice.Valid ? asset.currentPrice.Float64 : 0;
   };
+
+  // Splits and other corporate actions restate a transaction in today's shares.
+  const getQuantity = (asset) => {
+    return asset.adjustment ? asset.adjustment.quantity : asset.quantity;
+  };
+
+  const getPrice = (asset) => {
+    return asset.adjustment ? asset.adjustment.price : asset.price;
+  };
   
   const calculateInvestment = (asset) => {
       return asset.price * asset.quantity;
@@ -127,8 +190,8 @@ function AssetTable() {
         return 0;
       }
 
-      let currentTotal = currentPrice * asset.quantity;
-      let initialTotal = asset.price * asset.quantity;
+      let currentTotal = currentPrice * getQuantity(asset);
+      let initialTotal = getPrice(asset) * getQuantity(asset);
       return currentTotal - initialTotal;
   };
 
@@ -136,39 +199,6 @@ function AssetTable() {
       return (calculateProfitOrLoss(asset) / calculateInvestment(asset)) * 100;
   }
 
-  const calculateTotalInvestment = () => {
-    return assets?.reduce((total, asset) => {
-      const assetQuantity = parseFloat(asset.quantity);
-      const assetPrice = parseFloat(asset.price);
-      return asset.isPurchase ? total + (assetPrice * assetQuantity) : total - (assetPrice * assetQuantity);
-    }, 0).toFixed(4);
-  };
-  
-  const calculateTotalProfitLoss = () => {
-    return assets?.reduce((total, asset) => {
-
-      const currentPrice = parseFloat(getCurrentPrice(asset));
-      if (!currentPrice) {
-        return total + 0;
-      }
-      const assetQuantity = parseFloat(asset.quantity);
-      const assetPrice = parseFloat(asset.price);
-      const profitOrLoss = (currentPrice - assetPrice) * assetQuantity;
-      if (!asset.isPurchase) {
-        return total - calculateProfitOrLoss(asset);
-      }
-      return total + profitOrLoss;
-    }, 0).toFixed(4);
-  };
-  
-  const calculatePortfolioValue = () => {
-    return parseFloat(calculateTotalInvestment()) + parseFloat(calculateTotalProfitLoss());
-  };
-
-  const calculateTotalProfitLossPercentage = () => {
-    return (calculateTotalProfitLoss() / calculateTotalInvestment()) * 100;
-  }
-
   const countUniqueAssetTags = () => {
     const uniqueTags = new Set();
     assets.forEach(asset => uniqueTags.add(asset.stockTag));
@@ -178,6 +208,7 @@ function AssetTable() {
   return (
     <div className="container mt-4">
     {errorMessage && <div className="alert alert-danger">{errorMessage}</div>}
+    {infoMessage && <div className="alert alert-info">{infoMessage}</div>}
     <ApiKeyForm />
     <h2 className="mb-4">MyInvestMap Portfolio</h2>
 
@@ -228,11 +259,13 @@ function AssetTable() {
               ? <td className="text-primary">Purchase</td> 
               : <td className="text-warning">Sale</td>}
               <td>{asset.stockTag}</td>
-              <td>{asset.exchange}</td>
+              <td>{asset.exchange}{asset.account && <div className="text-muted small">{asset.account}</div>}</td>
               <td>{getName(asset)}</td>
-              <td>{asset.price}</td>
-              <td>{asset.quantity}</td>
-              <td>{formatCurrency(getCurrentPrice(asset))}</td>
+              <td title={asset.adjustment ? `Adjusted for corporate actions: ${asset.adjustment.quantity} ${asset.adjustment.stockTag} at ${asset.adjustment.price}` : undefined}>{asset.price}</td>
+              <td>{asset.quantity}{asset.adjustment && <div className="text-muted small">{asset.adjustment.quantity} {asset.adjustment.stockTag}</div>}</td>
+              <td className={asset.isStale ? 'text-muted' : undefined} title={getPriceTitle(asset)}>
+                {formatCurrency(getCurrentPrice(asset))}{asset.isStale && ' ⚠'}
+              </td>
               <td>{formatCurrency(calculateInvestment(asset))}</td>
               <td className={calculateProfitOrLoss(asset) >= 0 ? 'text-success' : 'text-danger'}>
                 {formatCurrency(calculateProfitOrLoss(asset))} ({formatCurrency(calculateProfitOrLossPercentage(asset))}%)
@@ -249,23 +282,51 @@ function AssetTable() {
       ))}
       </tbody>
       <tfoot className="bg-light">
-        <tr className="table-primary">
-          <td colSpan="8"></td>
-          <td colSpan="2" className="text-end" >Total investment:</td>
-          <td>{formatCurrency(calculateTotalInvestment())}</td>
-        </tr>
-        <tr className="table-primary">
-          <td colSpan="8"></td>
-          <td colSpan="2" className="text-end" >Total profit/loss:</td>
-          <td className={parseFloat(calculateTotalProfitLoss()) >= 0 ? 'text-success' : 'text-danger'}>
-            {formatCurrency(calculateTotalProfitLoss())} ({formatCurrency(calculateTotalProfitLossPercentage())}%) 
-          </td>
-        </tr>
-        <tr className="table-primary">
-          <td colSpan="8"></td>
-          <td colSpan="2" className="text-end" >Total portfolio value:</td>
-          <td>{formatCurrency(calculatePortfolioValue())}</td>
-        </tr>
+        {positions && (
+          <>
+            <tr className="table-primary">
+              <td colSpan="8"></td>
+              <td colSpan="2" className="text-end" >Total cost basis:</td>
+              <td>{formatCurrency(positions.costBasis)}</td>
+            </tr>
+            <tr className="table-primary">
+              <td colSpan="8"></td>
+              <td colSpan="2" className="text-end" >Unrealized profit/loss:</td>
+              <td className={positions.unrealizedPL >= 0 ? 'text-success' : 'text-danger'}>
+                {formatCurrency(positions.unrealizedPL)} ({formatCurrency(positions.unrealizedPLPercent)}%)
+              </td>
+            </tr>
+          </>
+        )}
+        {pnl && (
+          <tr className="table-primary">
+            <td colSpan="8"></td>
+            <td colSpan="2" className="text-end" >Realized profit/loss in {pnl.baseCurrency}:</td>
+            <td className={pnl.realizedPL >= 0 ? 'text-success' : 'text-danger'}>
+              {formatCurrency(pnl.realizedPL)}
+            </td>
+          </tr>
+        )}
+        {totals && (
+          <tr className="table-primary">
+            <td colSpan="8"></td>
+            <td colSpan="2" className="text-end" title={totals.missingRates ? 'No FX rate for ' + totals.missingRates.join(', ') : undefined}>
+              Portfolio value in {totals.baseCurrency}:{totals.missingRates && ' ⚠'}
+            </td>
+            <td className={totals.profitLoss >= 0 ? 'text-success' : 'text-danger'}>
+              {formatCurrency(totals.marketValue)} ({formatCurrency(totals.profitLossPercent)}%)
+            </td>
+          </tr>
+        )}
+        {totals && totals.income !== 0 && (
+          <tr className="table-primary">
+            <td colSpan="8"></td>
+            <td colSpan="2" className="text-end" >Total return incl. {formatCurrency(totals.income)} income:</td>
+            <td className={totals.totalReturn >= 0 ? 'text-success' : 'text-danger'}>
+              {formatCurrency(totals.totalReturn)} ({formatCurrency(totals.totalReturnPercent)}%)
+            </td>
+          </tr>
+        )}
         <tr className="table-secondary">
           <td colSpan="8"></td>
           <td colSpan="2" className="text-end" >Total Unique Assets:</td>
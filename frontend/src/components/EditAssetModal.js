@@ -73,6 +73,16 @@ function EditAssetModal({ show, handleClose, asset, onAssetUpdated }) {
             />
           </Form.Group>
 
+          <Form.Group className="mb-3">
+            <Form.Label>{updatedAsset.assetType === 'crypto' ? 'Wallet / Account' : 'Account'}</Form.Label>
+            <Form.Control
+              type="text"
+              name="account"
+              value={updatedAsset.account || ''}
+              onChange={handleChange}
+            />
+          </Form.Group>
+
           <Form.Group className="mb-3">
             <Form.Label>Price</Form.Label>
             <Form.Control
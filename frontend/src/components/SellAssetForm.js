@@ -3,13 +3,14 @@ import { Modal, Button, Form, Alert } from 'react-bootstrap';
 import { addSellAssetApi } from '../services/api';
 
 function SellAssetForm({ onAssetSold }) {
-  const [asset, setAsset] = useState({ stockTag: '', exchange: '', price: 0, quantity: 0 });
+  const [asset, setAsset] = useState({ assetType: 'stock', stockTag: '', exchange: '', account: '', price: 0, quantity: 0, fee: '', tax: '', tradedAt: '' });
   const [showModal, setShowModal] = useState(false);
   const [notification, setNotification] = useState({ message: '', type: '' });
+  const isCrypto = asset.assetType === 'crypto';
 
   const handleChange = (event) => {
     const { name, value } = event.target;
-    if (name === 'price' || name === 'quantity') {
+    if (name === 'price' || name === 'quantity' || name === 'fee' || name === 'tax') {
       if (value === '' || value.match(/^(\d+)?([.,](\d+)?)?$/)) {
         const formattedValue = value.replace(',', '.');
         setAsset({ ...asset, [name]: formattedValue });
@@ -28,16 +29,24 @@ function SellAssetForm({ onAssetSold }) {
   const handleSubmit = (event) => {
     asset.price = parseFloat(asset.price);
     asset.quantity = parseFloat(asset.quantity);
+    asset.fee = parseFloat(asset.fee) || 0;
+    asset.tax = parseFloat(asset.tax) || 0;
     event.preventDefault();
-    addSellAssetApi(asset)
+    const { tradedAt, ...rest } = asset;
+    addSellAssetApi(tradedAt ? { ...rest, tradedAt: new Date(tradedAt).toISOString() } : rest)
     .then(response => {
         onAssetSold();
         setShowModal(false);
-        setAsset({ stockTag: '', exchange: '', price: '', quantity: '' });
+        setAsset({ assetType: 'stock', stockTag: '', exchange: '', account: '', price: '', quantity: '', fee: '', tax: '', tradedAt: '' });
         setNotification({ message: 'Asset sold successfully!', type: 'success' });
     })
     .catch(error => {
         console.error('Error selling asset:', error);
+        const oversold = error.response && error.response.status === 422 && error.response.data;
+        if (oversold) {
+          setNotification({ message: `Only ${oversold.available} ${oversold.stockTag} available to sell.`, type: 'danger' });
+          return;
+        }
         setNotification({ message: 'Error selling asset. Please try again.', type: 'danger' });
     });
   };
@@ -58,23 +67,42 @@ function SellAssetForm({ onAssetSold }) {
           )}
           <Form onSubmit={handleSubmit}>
             <Form.Group className="mb-3">
-              <Form.Label>Stock Tag</Form.Label>
+              <Form.Label>Asset Type</Form.Label>
+              <Form.Select name="assetType" value={asset.assetType} onChange={handleChange}>
+                <option value="stock">Stock</option>
+                <option value="crypto">Crypto</option>
+              </Form.Select>
+            </Form.Group>
+            <Form.Group className="mb-3">
+              <Form.Label>{isCrypto ? 'Pair' : 'Stock Tag'}</Form.Label>
               <Form.Control 
                 type="text" 
                 name="stockTag" 
                 value={asset.stockTag} 
                 onChange={handleChange} 
-                placeholder="Stock Tag" 
+                placeholder={isCrypto ? 'BTC/USD' : 'Stock Tag'} 
               />
             </Form.Group>
+            {!isCrypto && (
+              <Form.Group className="mb-3">
+                <Form.Label>Exchange</Form.Label>
+                <Form.Control 
+                  type="text" 
+                  name="exchange" 
+                  value={asset.exchange} 
+                  onChange={handleChange} 
+                  placeholder="Exchange" 
+                />
+              </Form.Group>
+            )}
             <Form.Group className="mb-3">
-              <Form.Label>Exchange</Form.Label>
+              <Form.Label>{isCrypto ? 'Wallet / Account' : 'Account'}</Form.Label>
               <Form.Control 
                 type="text" 
-                name="exchange" 
-                value={asset.exchange} 
+                name="account" 
+                value={asset.account} 
                 onChange={handleChange} 
-                placeholder="Exchange" 
+                placeholder={isCrypto ? 'e.g. Ledger, Coinbase' : 'Account (optional)'} 
               />
             </Form.Group>
             <Form.Group className="mb-3">
@@ -97,6 +125,35 @@ function SellAssetForm({ onAssetSold }) {
                 placeholder="Quantity" 
               />
             </Form.Group>
+            <Form.Group className="mb-3">
+              <Form.Label>Fee</Form.Label>
+              <Form.Control 
+                type="text" 
+                name="fee" 
+                value={asset.fee} 
+                onChange={handleChange} 
+                placeholder="Commission (optional)" 
+              />
+            </Form.Group>
+            <Form.Group className="mb-3">
+              <Form.Label>Tax</Form.Label>
+              <Form.Control 
+                type="text" 
+                name="tax" 
+                value={asset.tax} 
+                onChange={handleChange} 
+                placeholder="Stamp duty or transaction tax (optional)" 
+              />
+            </Form.Group>
+            <Form.Group className="mb-3">
+              <Form.Label>Trade Date</Form.Label>
+              <Form.Control 
+                type="date" 
+                name="tradedAt" 
+                value={asset.tradedAt} 
+                onChange={handleChange} 
+              />
+            </Form.Group>
             <Button variant="warning" type="submit">
               Sell Asset
             </Button>
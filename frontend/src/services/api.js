@@ -17,10 +17,26 @@ const getApiKey = async () => {
     return secureAxios.get('/api/api-key');
 };
   
-const saveApiKey = async (apiKey) => {
-    return secureAxios.post('/api/api-key', { api_key: apiKey });
+const saveApiKey = async (apiKey, provider) => {
+    return secureAxios.post('/api/api-key', { api_key: apiKey, provider: provider });
 }
 
+const getProvidersApi = () => {
+    return secureAxios.get('/api/providers');
+};
+
+const createProviderApi = (provider) => {
+    return secureAxios.post('/api/providers', provider);
+};
+
+const updateProviderApi = (providerId, provider) => {
+    return secureAxios.put(`/api/providers/${providerId}`, provider);
+};
+
+const deleteProviderApi = (providerId) => {
+    return secureAxios.delete(`/api/providers/${providerId}`);
+};
+
 const logoutApi = () => {
     return secureAxios.get('/api/logout');
 };
@@ -45,9 +61,77 @@ const getAssetsApi = () => {
     return secureAxios.get('/api/assets');
 };
 
+const searchSymbolsApi = (query) => {
+    return secureAxios.get('/api/symbols/search', { params: { q: query } });
+};
+
 const refreshAssetsApi = (data) => {
     return secureAxios.post('/api/refresh-assets', data);
 };
 
+const getSettingsApi = () => {
+    return secureAxios.get('/api/settings');
+};
+
+const updateSettingsApi = (settings) => {
+    return secureAxios.put('/api/settings', settings);
+};
+
+const getPortfolioTotalsApi = () => {
+    return secureAxios.get('/api/portfolio/totals');
+};
+
+const getIncomeApi = (params) => {
+    return secureAxios.get('/api/income', { params });
+};
+
+const addIncomeApi = (income) => {
+    return secureAxios.post('/api/income', income);
+};
+
+const updateIncomeApi = (incomeId, income) => {
+    return secureAxios.put(`/api/income/${incomeId}`, income);
+};
+
+const deleteIncomeApi = (incomeId) => {
+    return secureAxios.delete(`/api/income/${incomeId}`);
+};
+
+const getIncomeByPeriodApi = (period) => {
+    return secureAxios.get('/api/income/by-period', { params: { period } });
+};
+
+const getIncomeByInstrumentApi = () => {
+    return secureAxios.get('/api/income/by-instrument');
+};
+
+const getCorporateActionsApi = () => {
+    return secureAxios.get('/api/corporate-actions');
+};
+
+const addCorporateActionApi = (action) => {
+    return secureAxios.post('/api/corporate-actions', action);
+};
+
+const deleteCorporateActionApi = (actionId) => {
+    return secureAxios.delete(`/api/corporate-actions/${actionId}`);
+};
+
+const fetchCorporateActionsApi = () => {
+    return secureAxios.post('/api/corporate-actions/fetch');
+};
+
+const getPositionsApi = () => {
+    return secureAxios.get('/api/positions');
+};
+
+const getPnlApi = (params) => {
+    return secureAxios.get('/api/performance/pnl', { params });
+};
+
+const getFeesApi = (params) => {
+    return secureAxios.get('/api/performance/fees', { params });
+};
+
 
-export { getApiKey, saveApiKey, loginApi, registerApi, logoutApi, addAssetApi, addSellAssetApi, deleteAssetApi, updateAssetApi, getAssetsApi, refreshAssetsApi };
+export { getApiKey, saveApiKey, getProvidersApi, createProviderApi, updateProviderApi, deleteProviderApi, loginApi, registerApi, logoutApi, addAssetApi, addSellAssetApi, deleteAssetApi, updateAssetApi, getAssetsApi, searchSymbolsApi, refreshAssetsApi, getSettingsApi, updateSettingsApi, getPortfolioTotalsApi, getIncomeApi, addIncomeApi, updateIncomeApi, deleteIncomeApi, getIncomeByPeriodApi, getIncomeByInstrumentApi, getCorporateActionsApi, addCorporateActionApi, deleteCorporateActionApi, fetchCorporateActionsApi, getPositionsApi, getPnlApi, getFeesApi };
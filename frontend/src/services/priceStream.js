@@ -0,0 +1,59 @@
+// Keeps a WebSocket to /api/ws/prices open, reconnecting with backoff and
+// resuming from the last event seen.
+const openPriceStream = (onMessage) => {
+    const baseUrl = (process.env.REACT_APP_BACKEND_URL || window.location.origin).replace(/^http/, 'ws');
+    let socket = null;
+    let lastEventId = null;
+    let retryDelay = 1000;
+    let retryTimer = null;
+    let closed = false;
+
+    const connect = () => {
+        const token = localStorage.getItem('token');
+        if (!token || closed) {
+            return;
+        }
+        socket = new WebSocket(`${baseUrl}/api/ws/prices?token=${encodeURIComponent(token)}`);
+        socket.onopen = () => {
+            retryDelay = 1000;
+            const request = { type: 'subscribe' };
+            if (lastEventId !== null) {
+                request.since = lastEventId;
+            }
+            socket.send(JSON.stringify(request));
+        };
+        socket.onmessage = (event) => {
+            const message = JSON.parse(event.data);
+            if (message.eventId) {
+                lastEventId = message.eventId;
+            }
+            onMessage(message);
+        };
+        socket.onclose = () => {
+            if (closed) {
+                return;
+            }
+            retryTimer = setTimeout(connect, retryDelay);
+            retryDelay = Math.min(retryDelay * 2, 30000);
+        };
+    };
+
+    connect();
+
+    return {
+        resubscribe: () => {
+            if (socket && socket.readyState === WebSocket.OPEN) {
+                socket.send(JSON.stringify({ type: 'subscribe' }));
+            }
+        },
+        close: () => {
+            closed = true;
+            clearTimeout(retryTimer);
+            if (socket) {
+                socket.close();
+            }
+        },
+    };
+};
+
+export default openPriceStream;